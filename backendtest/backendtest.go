@@ -0,0 +1,422 @@
+// Package backendtest contains a test suite for implementations of the
+// backend interfaces.
+//
+// Each backend runs the suite in its own tests, for example:
+//
+//	func TestNotify(t *testing.T) {
+//		backendtest.Notify(t, func(t *testing.T) backendtest.NotifyBackend {
+//			return memory.New()
+//		})
+//	}
+//
+// The function to create the backend is called for each subtest. It has to
+// return a backend without any data.
+//
+// The package is not internal, so backends outside of this module can run the
+// suite as well.
+package backendtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/applause"
+	"github.com/OpenSlides/openslides-icc-service/internal/notify"
+)
+
+// NotifyBackend has the same methods as notify.Backend.
+type NotifyBackend interface {
+	NotifyPublish([]byte) error
+	NotifyReceive(ctx context.Context) (messages [][]byte, err error)
+}
+
+// ApplauseBackend has the methods of applause.Backend and the method to
+// remove old applause.
+type ApplauseBackend interface {
+	ApplausePublish(meetingID, userID int, time int64) error
+	ApplauseSince(time int64) (map[int]int, error)
+
+	// ApplauseCleanOld removes applause that is older then a given time.
+	ApplauseCleanOld(olderThen int64) error
+}
+
+// The interfaces of the suite have to stay in sync with the interfaces of
+// the services.
+var (
+	_ notify.Backend   = NotifyBackend(nil)
+	_ applause.Backend = ApplauseBackend(nil)
+)
+
+const (
+	// blockTime is the time a blocking function has to block to pass the
+	// test.
+	blockTime = 20 * time.Millisecond
+
+	// waitTime is the maximum time to wait for a function, that should
+	// return.
+	waitTime = time.Second
+)
+
+// Notify tests the contract of notify.Backend.
+func Notify(t *testing.T, newBackend func(t *testing.T) NotifyBackend) {
+	t.Run("Receive blocks", func(t *testing.T) {
+		backend := newBackend(t)
+		received := startReceive(t, backend)
+
+		select {
+		case r := <-received:
+			t.Errorf("NotifyReceive returned (%q, %v), expected it to block", r.messages, r.err)
+		case <-time.After(blockTime):
+		}
+	})
+
+	t.Run("Receive unblocks on cancel", func(t *testing.T) {
+		backend := newBackend(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		received := make(chan receiveResult, 1)
+		go func() {
+			messages, err := backend.NotifyReceive(ctx)
+			received <- receiveResult{messages, err}
+		}()
+
+		time.Sleep(blockTime)
+		cancel()
+
+		select {
+		case r := <-received:
+			if !errors.Is(r.err, context.Canceled) {
+				t.Errorf("NotifyReceive returned error `%v`, expected context.Canceled", r.err)
+			}
+		case <-time.After(waitTime):
+			t.Errorf("NotifyReceive did not return after the context was canceled")
+		}
+	})
+
+	t.Run("Receive gets a published message", func(t *testing.T) {
+		backend := newBackend(t)
+		received := startReceive(t, backend)
+		time.Sleep(blockTime)
+
+		publish(t, backend, "my message")
+
+		got := waitReceive(t, received)
+		if len(got) != 1 || got[0] != "my message" {
+			t.Errorf("NotifyReceive returned %q, expected [my message]", got)
+		}
+	})
+
+	t.Run("Receive keeps the order", func(t *testing.T) {
+		backend := newBackend(t)
+		received := startReceive(t, backend)
+		time.Sleep(blockTime)
+
+		for i := range 5 {
+			publish(t, backend, fmt.Sprintf("message %d", i))
+		}
+
+		// The messages can be returned in one or many calls.
+		var got []string
+		for len(got) < 5 {
+			if len(got) > 0 {
+				received = startReceive(t, backend)
+			}
+			got = append(got, waitReceive(t, received)...)
+		}
+
+		for i, message := range got {
+			if expect := fmt.Sprintf("message %d", i); message != expect {
+				t.Errorf("message %d is `%s`, expected `%s`", i, message, expect)
+			}
+		}
+	})
+
+	t.Run("Receive returns many messages at once", func(t *testing.T) {
+		backend := newBackend(t)
+		received := startReceive(t, backend)
+		time.Sleep(blockTime)
+
+		publish(t, backend, "first")
+		got := waitReceive(t, received)
+
+		// The next call starts after the messages are published, so it can
+		// return all of them.
+		for i := range 5 {
+			publish(t, backend, fmt.Sprintf("message %d", i))
+		}
+
+		received = startReceive(t, backend)
+		got = append(got, waitReceive(t, received)...)
+
+		if len(got) != 6 {
+			t.Errorf("got %d messages in two calls, expected 6: %q", len(got), got)
+		}
+	})
+
+	t.Run("Receive after cancel", func(t *testing.T) {
+		backend := newBackend(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		canceled := make(chan struct{})
+		go func() {
+			backend.NotifyReceive(ctx)
+			close(canceled)
+		}()
+		time.Sleep(blockTime)
+		cancel()
+		<-canceled
+
+		received := startReceive(t, backend)
+		time.Sleep(blockTime)
+
+		publish(t, backend, "after cancel")
+
+		got := waitReceive(t, received)
+		if len(got) != 1 || got[0] != "after cancel" {
+			t.Errorf("NotifyReceive returned %q, expected [after cancel]", got)
+		}
+	})
+}
+
+// Applause tests the contract of applause.Backend and of ApplauseCleanOld.
+func Applause(t *testing.T, newBackend func(t *testing.T) ApplauseBackend) {
+	for _, tt := range []struct {
+		name    string
+		publish [][3]int64 // meetingID, userID, time
+		since   int64
+		expect  map[int]int
+	}{
+		{
+			"No applause",
+			nil,
+			10,
+			map[int]int{},
+		},
+		{
+			"One user",
+			[][3]int64{{1, 1, 10}},
+			10,
+			map[int]int{1: 1},
+		},
+		{
+			"One user twice",
+			[][3]int64{{1, 1, 10}, {1, 1, 11}},
+			10,
+			map[int]int{1: 1},
+		},
+		{
+			"One user many times",
+			[][3]int64{{1, 1, 10}, {1, 1, 10}, {1, 1, 10}},
+			10,
+			map[int]int{1: 1},
+		},
+		{
+			"Too old",
+			[][3]int64{{1, 1, 9}},
+			10,
+			map[int]int{},
+		},
+		{
+			"Old and new of the same user",
+			[][3]int64{{1, 1, 5}, {1, 1, 10}},
+			10,
+			map[int]int{1: 1},
+		},
+		{
+			"Two users",
+			[][3]int64{{1, 1, 10}, {1, 2, 10}},
+			10,
+			map[int]int{1: 2},
+		},
+		{
+			"Two users, one too old",
+			[][3]int64{{1, 1, 9}, {1, 2, 10}},
+			10,
+			map[int]int{1: 1},
+		},
+		{
+			"Two meetings",
+			[][3]int64{{1, 1, 10}, {2, 2, 10}},
+			10,
+			map[int]int{1: 1, 2: 1},
+		},
+		{
+			"Same user in two meetings",
+			[][3]int64{{1, 1, 10}, {2, 1, 10}},
+			10,
+			map[int]int{1: 1, 2: 1},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newBackend(t)
+
+			for _, p := range tt.publish {
+				if err := backend.ApplausePublish(int(p[0]), int(p[1]), p[2]); err != nil {
+					t.Fatalf("ApplausePublish: %v", err)
+				}
+			}
+
+			got, err := backend.ApplauseSince(tt.since)
+			if err != nil {
+				t.Fatalf("ApplauseSince: %v", err)
+			}
+
+			checkApplause(t, got, tt.expect)
+		})
+	}
+
+	t.Run("Window moves", func(t *testing.T) {
+		backend := newBackend(t)
+
+		if err := backend.ApplausePublish(1, 1, 10); err != nil {
+			t.Fatalf("ApplausePublish: %v", err)
+		}
+
+		for _, tt := range []struct {
+			since  int64
+			expect int
+		}{
+			{9, 1},
+			{10, 1},
+			{11, 0},
+		} {
+			got, err := backend.ApplauseSince(tt.since)
+			if err != nil {
+				t.Fatalf("ApplauseSince: %v", err)
+			}
+
+			if got[1] != tt.expect {
+				t.Errorf("ApplauseSince(%d) returned %d for meeting 1, expected %d", tt.since, got[1], tt.expect)
+			}
+		}
+	})
+
+	for _, tt := range []struct {
+		name      string
+		publish   [][3]int64 // meetingID, userID, time
+		olderThen int64
+		expect    map[int]int
+	}{
+		{
+			"Remove old",
+			[][3]int64{{1, 1, 5}},
+			10,
+			map[int]int{},
+		},
+		{
+			"Keep applause at the time",
+			[][3]int64{{1, 1, 10}},
+			10,
+			map[int]int{1: 1},
+		},
+		{
+			"Remove only old",
+			[][3]int64{{1, 1, 5}, {1, 2, 10}, {2, 3, 9}},
+			10,
+			map[int]int{1: 1},
+		},
+		{
+			"Keep user with new applause",
+			[][3]int64{{1, 1, 5}, {1, 1, 10}},
+			10,
+			map[int]int{1: 1},
+		},
+	} {
+		t.Run("Clean old: "+tt.name, func(t *testing.T) {
+			backend := newBackend(t)
+
+			for _, p := range tt.publish {
+				if err := backend.ApplausePublish(int(p[0]), int(p[1]), p[2]); err != nil {
+					t.Fatalf("ApplausePublish: %v", err)
+				}
+			}
+
+			if err := backend.ApplauseCleanOld(tt.olderThen); err != nil {
+				t.Fatalf("ApplauseCleanOld: %v", err)
+			}
+
+			// ApplauseSince(0) counts all applause, that was not removed.
+			got, err := backend.ApplauseSince(0)
+			if err != nil {
+				t.Fatalf("ApplauseSince: %v", err)
+			}
+
+			checkApplause(t, got, tt.expect)
+		})
+	}
+}
+
+// checkApplause compares the result of ApplauseSince with the expected levels.
+func checkApplause(t *testing.T, got, expect map[int]int) {
+	t.Helper()
+
+	for meetingID, level := range expect {
+		if got[meetingID] != level {
+			t.Errorf("got %d applause for meeting %d, expected %d", got[meetingID], meetingID, level)
+		}
+	}
+
+	for meetingID, level := range got {
+		if _, ok := expect[meetingID]; !ok && level != 0 {
+			t.Errorf("got %d applause for meeting %d, expected none", level, meetingID)
+		}
+	}
+}
+
+type receiveResult struct {
+	messages [][]byte
+	err      error
+}
+
+// startReceive calls NotifyReceive in the background. It is canceled, when
+// the test is finished.
+func startReceive(t *testing.T, backend NotifyBackend) <-chan receiveResult {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	received := make(chan receiveResult, 1)
+	go func() {
+		messages, err := backend.NotifyReceive(ctx)
+		received <- receiveResult{messages, err}
+	}()
+	return received
+}
+
+// waitReceive waits for the result of startReceive.
+func waitReceive(t *testing.T, received <-chan receiveResult) []string {
+	t.Helper()
+
+	select {
+	case r := <-received:
+		if r.err != nil {
+			t.Fatalf("NotifyReceive: %v", r.err)
+		}
+
+		if len(r.messages) == 0 {
+			t.Fatalf("NotifyReceive returned no message and no error")
+		}
+
+		messages := make([]string, len(r.messages))
+		for i, m := range r.messages {
+			messages[i] = string(m)
+		}
+		return messages
+
+	case <-time.After(waitTime):
+		t.Fatalf("NotifyReceive did not return")
+		return nil
+	}
+}
+
+func publish(t *testing.T, backend NotifyBackend, message string) {
+	t.Helper()
+
+	if err := backend.NotifyPublish([]byte(message)); err != nil {
+		t.Fatalf("NotifyPublish: %v", err)
+	}
+}
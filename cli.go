@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"text/tabwriter"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/client"
+)
+
+// connectionFlags are the options to connect to a running service.
+type connectionFlags struct {
+	Host     string `help:"Host of the service" short:"h" default:"localhost"`
+	Port     string `help:"Port of the service" short:"p" default:"9007" env:"ICC_PORT"`
+	UseHTTPS bool   `help:"Use https to connect to the service" short:"s"`
+	Insecure bool   `help:"Accept invalid cert" short:"k"`
+}
+
+// baseURL returns the url of the service without the icc path.
+func (f connectionFlags) baseURL() string {
+	proto := "http"
+	if f.UseHTTPS {
+		proto = "https"
+	}
+	return fmt.Sprintf("%s://%s:%s", proto, f.Host, f.Port)
+}
+
+func (f connectionFlags) httpClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if f.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// authFlags are the options to authenticate the requests.
+type authFlags struct {
+	Token  string `help:"Value of the Authentication header, for example 'bearer ey...'" short:"t" env:"ICC_AUTH_TOKEN"`
+	Cookie string `help:"Value of the refreshId cookie" env:"ICC_AUTH_COOKIE"`
+}
+
+func (f authFlags) auth(r *http.Request) error {
+	if f.Token != "" {
+		r.Header.Set("Authentication", f.Token)
+	}
+
+	if f.Cookie != "" {
+		r.AddCookie(&http.Cookie{Name: "refreshId", Value: f.Cookie})
+	}
+	return nil
+}
+
+// clientFlags are the options for the subcommands, that use the api.
+type clientFlags struct {
+	connectionFlags `embed:""`
+	authFlags       `embed:""`
+}
+
+func (f clientFlags) client() *client.Client {
+	c := client.New(f.baseURL())
+	c.HTTPClient = f.httpClient()
+	c.Auth = f.auth
+	return c
+}
+
+// get sends a GET request to a route. Returns an error, if the status is not
+// 200. The body of the response has to be closed.
+func (f clientFlags) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := f.baseURL() + client.Path + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	if err := f.auth(req); err != nil {
+		return nil, fmt.Errorf("authenticating request: %w", err)
+	}
+
+	resp, err := f.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+
+	if resp.StatusCode != 200 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("server returned status %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	return resp, nil
+}
+
+// stream opens a stream route and writes each line indented to w.
+func (f clientFlags) stream(ctx context.Context, w io.Writer, path string, query url.Values) error {
+	resp, err := f.get(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, scanner.Bytes(), "", "  "); err != nil {
+			// Print invalid json as it is.
+			indented.Reset()
+			indented.Write(scanner.Bytes())
+		}
+
+		if _, err := fmt.Fprintln(w, indented.String()); err != nil {
+			return fmt.Errorf("writing message: %w", err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading stream: %w", err)
+	}
+	return nil
+}
+
+// notifyListenCmd is the subcommand `notify listen`.
+type notifyListenCmd struct {
+	clientFlags `embed:""`
+	MeetingID   int `help:"Receive the messages to this meeting" short:"m"`
+}
+
+func (cmd notifyListenCmd) run(ctx context.Context, w io.Writer) error {
+	query := url.Values{}
+	if cmd.MeetingID != 0 {
+		query.Set("meeting_id", fmt.Sprint(cmd.MeetingID))
+	}
+	return cmd.stream(ctx, w, "/notify", query)
+}
+
+// notifyPublishCmd is the subcommand `notify publish`.
+type notifyPublishCmd struct {
+	clientFlags `embed:""`
+	ChannelID   string   `help:"Channel id of the sender. If empty, a new channel is created."`
+	ToMeeting   int      `help:"Send the message to all users of this meeting"`
+	ToUsers     []int    `help:"Send the message to these users"`
+	ToChannels  []string `help:"Send the message to these channels"`
+	Name        string   `help:"Name of the message" short:"n" required:""`
+	Message     string   `help:"The message as json. If empty, it is read from stdin." short:"m"`
+}
+
+func (cmd notifyPublishCmd) run(ctx context.Context, stdin io.Reader) error {
+	message := []byte(cmd.Message)
+	if cmd.Message == "" {
+		var err error
+		message, err = io.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("reading message from stdin: %w", err)
+		}
+	}
+
+	message = bytes.TrimSpace(message)
+	if !json.Valid(message) {
+		return fmt.Errorf("message is not valid json: %s", message)
+	}
+
+	return cmd.client().NotifyPublish(ctx, client.Message{
+		ToMeeting:  cmd.ToMeeting,
+		ToUsers:    cmd.ToUsers,
+		ToChannels: cmd.ToChannels,
+		Name:       cmd.Name,
+		Message:    message,
+	}.WithChannelID(cmd.ChannelID))
+}
+
+// applauseWatchCmd is the subcommand `applause watch`.
+type applauseWatchCmd struct {
+	clientFlags `embed:""`
+	MeetingID   int `help:"Receive the applause of this meeting" short:"m" required:""`
+}
+
+func (cmd applauseWatchCmd) run(ctx context.Context, w io.Writer) error {
+	return cmd.stream(ctx, w, "/applause", url.Values{"meeting_id": {fmt.Sprint(cmd.MeetingID)}})
+}
+
+// applauseSendCmd is the subcommand `applause send`.
+type applauseSendCmd struct {
+	clientFlags `embed:""`
+	MeetingID   int `help:"Send applause to this meeting" short:"m" required:""`
+}
+
+func (cmd applauseSendCmd) run(ctx context.Context) error {
+	return cmd.client().ApplauseSend(ctx, cmd.MeetingID)
+}
+
+// adminInstancesCmd is the subcommand `admin instances`.
+type adminInstancesCmd struct {
+	clientFlags `embed:""`
+}
+
+func (cmd adminInstancesCmd) run(ctx context.Context, w io.Writer) error {
+	resp, err := cmd.get(ctx, "/admin/instances", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Instances []struct {
+			ID      string    `json:"id"`
+			Streams int       `json:"streams"`
+			Updated time.Time `json:"updated"`
+		} `json:"instances"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("decoding instances: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "INSTANCE\tSTREAMS\tUPDATED")
+	for _, instance := range response.Instances {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", instance.ID, instance.Streams, instance.Updated.Format(time.RFC3339))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("writing instances: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestFlags returns the flags to connect to a test server as user.
+func newTestFlags(t *testing.T, userID int) clientFlags {
+	server, _ := newTestServer(t)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("parsing server url: %v", err)
+	}
+
+	return clientFlags{
+		connectionFlags: connectionFlags{Host: u.Hostname(), Port: u.Port()},
+		authFlags:       authFlags{Token: "user-" + strconv.Itoa(userID)},
+	}
+}
+
+func TestCLINotify(t *testing.T) {
+	flags := newTestFlags(t, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	listenCtx, stopListen := context.WithCancel(ctx)
+	defer stopListen()
+
+	messages := make(messageWriter, 100)
+	listenDone := make(chan error, 1)
+	go func() {
+		listenDone <- notifyListenCmd{clientFlags: flags, MeetingID: 1}.run(listenCtx, messages)
+	}()
+
+	if got := messages.next(ctx); !strings.Contains(got, "channel_id") {
+		t.Fatalf("first message `%s` does not contain the channel id", got)
+	}
+
+	publish := notifyPublishCmd{clientFlags: flags, ToMeeting: 1, Name: "cli"}
+	if err := publish.run(ctx, strings.NewReader(`{"from": "stdin"}`)); err != nil {
+		t.Fatalf("notify publish: %v", err)
+	}
+
+	got := messages.next(ctx)
+	if !strings.Contains(got, `"from": "stdin"`) || !strings.Contains(got, `"name": "cli"`) {
+		t.Errorf("got `%s`, expected the pretty printed message", got)
+	}
+
+	stopListen()
+	<-listenDone
+}
+
+func TestCLINotifyPublishInvalid(t *testing.T) {
+	flags := newTestFlags(t, 2)
+
+	err := notifyPublishCmd{clientFlags: flags, ToMeeting: 1, Name: "cli", Message: "{invalid"}.run(context.Background(), nil)
+	if err == nil {
+		t.Errorf("publish with invalid json did not return an error")
+	}
+}
+
+func TestCLIApplause(t *testing.T) {
+	flags := newTestFlags(t, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := (applauseSendCmd{clientFlags: flags, MeetingID: 1}).run(ctx); err != nil {
+		t.Fatalf("applause send: %v", err)
+	}
+
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+
+	messages := make(messageWriter, 100)
+	go applauseWatchCmd{clientFlags: flags, MeetingID: 1}.run(watchCtx, messages)
+
+	if got := messages.next(ctx); !strings.Contains(got, `"present_users"`) {
+		t.Errorf("got `%s`, expected applause", got)
+	}
+}
+
+func TestCLIAnonymous(t *testing.T) {
+	flags := newTestFlags(t, 0)
+
+	err := (applauseSendCmd{clientFlags: flags, MeetingID: 1}).run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("got error `%v`, expected unauthorized", err)
+	}
+}
+
+func TestCLIAdminInstances(t *testing.T) {
+	flags := newTestFlags(t, 1)
+
+	var out strings.Builder
+	if err := (adminInstancesCmd{clientFlags: flags}).run(context.Background(), &out); err != nil {
+		t.Fatalf("admin instances: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, expected a header and one instance:\n%s", len(lines), out.String())
+	}
+
+	if fields := strings.Fields(lines[1]); len(fields) != 3 || fields[0] != "test" {
+		t.Errorf("got line `%s`, expected the test instance", lines[1])
+	}
+}
+
+// messageWriter is a writer, that can be read message by message from another
+// goroutine. Each call to Write is one message.
+type messageWriter chan string
+
+func (w messageWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+// next returns the next message or an empty string, if ctx is done.
+func (w messageWriter) next(ctx context.Context) string {
+	select {
+	case message := <-w:
+		return message
+	case <-ctx.Done():
+		return ""
+	}
+}
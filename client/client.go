@@ -0,0 +1,426 @@
+// Package client is a go client for the icc-service.
+//
+// It receives notify messages and applause with the long polling routes of
+// the service. They return a cursor with each response, so the client can
+// reconnect after an error and resume where it left off.
+//
+// A notify channel only exists on the instance of the service, that created
+// it. If many instances run behind a load balancer, a request, that is sent to
+// another instance, gets a 404 and the listener returns ErrResync. To resume
+// after an error, the load balancer has to send the requests of a client to
+// the same instance, for example with sticky sessions.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/applause"
+	"github.com/OpenSlides/openslides-icc-service/internal/notify"
+)
+
+// Path is the basic path of the icc routes.
+const Path = "/system/icc"
+
+// defaultReconnectDelay is the time to wait after an error, if
+// Client.ReconnectDelay is not set.
+const defaultReconnectDelay = time.Second
+
+// Message is a notify message, that can be published.
+type Message = notify.Message
+
+// OutMessage is a received notify message.
+type OutMessage = notify.OutMessage
+
+// Applause is the current applause of a meeting.
+type Applause = applause.MSG
+
+// ErrResync is returned by NotifyListener.Next, when the listener could not
+// resume and messages could be lost. The listener uses a new channel
+// afterwards.
+//
+// This happens after a restart of the service and when a request is sent to
+// another instance, than the one that created the channel.
+var ErrResync = errors.New("notify channel was lost, messages could be missed")
+
+// AuthFunc adds the authentication to a request.
+type AuthFunc func(r *http.Request) error
+
+// HeaderAuth returns an AuthFunc that sets a header, for example the
+// Authentication header with an access token.
+func HeaderAuth(key, value string) AuthFunc {
+	return func(r *http.Request) error {
+		r.Header.Set(key, value)
+		return nil
+	}
+}
+
+// CookieAuth returns an AuthFunc that adds a cookie, for example the refreshId
+// cookie of the auth-service.
+func CookieAuth(name, value string) AuthFunc {
+	return func(r *http.Request) error {
+		r.AddCookie(&http.Cookie{Name: name, Value: value})
+		return nil
+	}
+}
+
+// Error is an error returned by the service.
+type Error struct {
+	Status  int            `json:"-"`
+	Type    string         `json:"error"`
+	Msg     string         `json:"msg"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("icc returned status %d: %s: %s", e.Status, e.Type, e.Msg)
+}
+
+// Client sends requests to the icc-service.
+type Client struct {
+	// HTTPClient is used for all requests. If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Auth is called for each request. If nil, the requests are anonymous.
+	Auth AuthFunc
+
+	// ReconnectDelay is the time to wait after an error before a listener
+	// sends the next request. Defaults to one second.
+	ReconnectDelay time.Duration
+
+	baseURL string
+
+	mu        sync.Mutex
+	channelID string
+}
+
+// New initializes a client for the service at baseURL, for example
+// http://localhost:9007.
+func New(baseURL string) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// NotifyPublish publishes a notify message.
+//
+// If the message has no channel id, a channel of the client is used.
+func (c *Client) NotifyPublish(ctx context.Context, message Message) error {
+	if message.ChannelID.String() == "" {
+		cid, err := c.publishChannel(ctx)
+		if err != nil {
+			return fmt.Errorf("creating channel: %w", err)
+		}
+		message = message.WithChannelID(cid)
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("encoding message: %w", err)
+	}
+
+	if err := c.do(ctx, "POST", "/notify/publish", nil, bytes.NewReader(body), nil); err != nil {
+		return fmt.Errorf("publishing message: %w", err)
+	}
+	return nil
+}
+
+// publishChannel returns a channel id of the user to publish messages.
+func (c *Client) publishChannel(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.channelID != "" {
+		return c.channelID, nil
+	}
+
+	cid, _, err := c.newChannel(ctx, 0)
+	if err != nil {
+		return "", err
+	}
+
+	c.channelID = cid
+	return cid, nil
+}
+
+// newChannel creates a new poll channel.
+func (c *Client) newChannel(ctx context.Context, meetingID int) (string, uint64, error) {
+	query := url.Values{}
+	if meetingID != 0 {
+		query.Set("meeting_id", fmt.Sprint(meetingID))
+	}
+
+	var response struct {
+		ChannelID string `json:"channel_id"`
+		Cursor    uint64 `json:"cursor"`
+	}
+	if err := c.do(ctx, "GET", "/notify/poll", query, nil, &response); err != nil {
+		return "", 0, err
+	}
+	return response.ChannelID, response.Cursor, nil
+}
+
+// NotifyListen creates a notify channel and returns a listener for it.
+//
+// If meetingID is not 0, the listener receives the messages to this meeting.
+func (c *Client) NotifyListen(ctx context.Context, meetingID int) (*NotifyListener, error) {
+	cid, cursor, err := c.newChannel(ctx, meetingID)
+	if err != nil {
+		return nil, fmt.Errorf("creating channel: %w", err)
+	}
+
+	return &NotifyListener{
+		client:    c,
+		meetingID: meetingID,
+		channelID: cid,
+		cursor:    cursor,
+	}, nil
+}
+
+// ApplauseSend sends applause to a meeting.
+func (c *Client) ApplauseSend(ctx context.Context, meetingID int) error {
+	query := url.Values{"meeting_id": {fmt.Sprint(meetingID)}}
+	if err := c.do(ctx, "POST", "/applause/send", query, nil, nil); err != nil {
+		return fmt.Errorf("sending applause: %w", err)
+	}
+	return nil
+}
+
+// ApplauseListen returns a listener for the applause of a meeting.
+func (c *Client) ApplauseListen(meetingID int) *ApplauseListener {
+	return &ApplauseListener{
+		client:    c,
+		meetingID: meetingID,
+	}
+}
+
+// do sends a request. If out is not nil, the response body is decoded into
+// it.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, out any) error {
+	u := c.baseURL + Path + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if c.Auth != nil {
+		if err := c.Auth(req); err != nil {
+			return fmt.Errorf("authenticating request: %w", err)
+		}
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		errResp := Error{Status: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+			errResp.Type = "internal"
+			errResp.Msg = resp.Status
+		}
+		return &errResp
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// wait sleeps for the reconnect delay.
+func (c *Client) wait(ctx context.Context) error {
+	delay := c.ReconnectDelay
+	if delay == 0 {
+		delay = defaultReconnectDelay
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isTemporary returns true, if the request should be sent again.
+func isTemporary(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var errResp *Error
+	if errors.As(err, &errResp) {
+		return errResp.Status >= 500 || errResp.Status == http.StatusTooManyRequests
+	}
+
+	// Network errors.
+	return true
+}
+
+// NotifyListener receives the notify messages of a channel.
+type NotifyListener struct {
+	client    *Client
+	meetingID int
+
+	// next makes sure, that Next is only called once at the same time.
+	next   sync.Mutex
+	cursor uint64
+	buf    []OutMessage
+
+	mu        sync.Mutex
+	channelID string
+}
+
+// ChannelID returns the current channel id of the listener. It changes, when
+// Next returns ErrResync.
+func (l *NotifyListener) ChannelID() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.channelID
+}
+
+// Next blocks until the next notify message is received.
+//
+// Errors of the connection are handled by sending the request again after the
+// reconnect delay. If the channel is lost on the server, a new channel is
+// created and ErrResync is returned.
+func (l *NotifyListener) Next(ctx context.Context) (OutMessage, error) {
+	l.next.Lock()
+	defer l.next.Unlock()
+
+	for len(l.buf) == 0 {
+		if err := l.poll(ctx); err != nil {
+			return OutMessage{}, err
+		}
+	}
+
+	message := l.buf[0]
+	l.buf = l.buf[1:]
+	return message, nil
+}
+
+// poll sends one poll request.
+func (l *NotifyListener) poll(ctx context.Context) error {
+	query := url.Values{
+		"channel_id": {l.ChannelID()},
+		"cursor":     {fmt.Sprint(l.cursor)},
+	}
+
+	var response struct {
+		Cursor   uint64       `json:"cursor"`
+		Messages []OutMessage `json:"messages"`
+	}
+	err := l.client.do(ctx, "GET", "/notify/poll", query, nil, &response)
+
+	var errResp *Error
+	switch {
+	case err == nil:
+		l.cursor = response.Cursor
+		l.buf = response.Messages
+		return nil
+
+	case errors.As(err, &errResp) && errResp.Status == http.StatusNotFound:
+		cid, cursor, err := l.client.newChannel(ctx, l.meetingID)
+		if err != nil {
+			if isTemporary(ctx, err) {
+				return l.client.wait(ctx)
+			}
+			return fmt.Errorf("creating new channel: %w", err)
+		}
+		l.mu.Lock()
+		l.channelID = cid
+		l.mu.Unlock()
+		l.cursor = cursor
+		return ErrResync
+
+	case isTemporary(ctx, err):
+		return l.client.wait(ctx)
+
+	default:
+		return fmt.Errorf("polling messages: %w", err)
+	}
+}
+
+// Publish publishes a message with the channel id of the listener.
+func (l *NotifyListener) Publish(ctx context.Context, message Message) error {
+	return l.client.NotifyPublish(ctx, message.WithChannelID(l.ChannelID()))
+}
+
+// ApplauseListener receives the applause of a meeting.
+type ApplauseListener struct {
+	client    *Client
+	meetingID int
+
+	mu     sync.Mutex
+	cursor uint64
+}
+
+// Next blocks until the applause changes. The first call returns the current
+// applause.
+//
+// Errors of the connection are handled by sending the request again after the
+// reconnect delay.
+func (l *ApplauseListener) Next(ctx context.Context) (Applause, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for {
+		query := url.Values{"meeting_id": {fmt.Sprint(l.meetingID)}}
+		if l.cursor != 0 {
+			query.Set("cursor", fmt.Sprint(l.cursor))
+		}
+
+		var response struct {
+			Cursor   uint64     `json:"cursor"`
+			Messages []Applause `json:"messages"`
+		}
+		if err := l.client.do(ctx, "GET", "/applause/poll", query, nil, &response); err != nil {
+			if !isTemporary(ctx, err) {
+				return Applause{}, fmt.Errorf("polling applause: %w", err)
+			}
+
+			if err := l.client.wait(ctx); err != nil {
+				return Applause{}, err
+			}
+			continue
+		}
+
+		l.cursor = response.Cursor
+		if len(response.Messages) > 0 {
+			return response.Messages[len(response.Messages)-1], nil
+		}
+	}
+}
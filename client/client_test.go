@@ -0,0 +1,170 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/client"
+)
+
+// pollServer is a fake of the notify poll route of one instance. Each new
+// channel gets the next name from channels. Polls of other channels return
+// 404, like on an instance, that did not create the channel.
+type pollServer struct {
+	mu       sync.Mutex
+	channels []string
+	current  string
+	requests []*http.Request
+}
+
+func (s *pollServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r)
+
+	if r.URL.Path != client.Path+"/notify/poll" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("channel_id") == "" {
+		s.current, s.channels = s.channels[0], s.channels[1:]
+		fmt.Fprintf(w, `{"channel_id":"%s","cursor":1}`, s.current)
+		return
+	}
+
+	if r.URL.Query().Get("channel_id") != s.current {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"not-found","msg":"unknown channel"}`)
+		return
+	}
+
+	fmt.Fprintf(w, `{"cursor":2,"messages":[{"sender_user_id":1,"sender_channel_id":"%s","name":"hello","message":null}]}`, s.current)
+}
+
+// forget simulates, that the next request goes to another instance, that does
+// not know the current channel.
+func (s *pollServer) forget() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.current = ""
+}
+
+// requestCount returns the number of requests.
+func (s *pollServer) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.requests)
+}
+
+func TestNotifyListenerResync(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	fake := &pollServer{channels: []string{"instance-a:1:0", "instance-b:1:0"}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	c := client.New(server.URL)
+	listener, err := c.NotifyListen(ctx, 1)
+	if err != nil {
+		t.Fatalf("NotifyListen: %v", err)
+	}
+
+	fake.forget()
+
+	if _, err := listener.Next(ctx); !errors.Is(err, client.ErrResync) {
+		t.Fatalf("Next returned `%v`, expected ErrResync", err)
+	}
+
+	if got := listener.ChannelID(); got != "instance-b:1:0" {
+		t.Errorf("listener uses channel %s, expected instance-b:1:0", got)
+	}
+
+	got, err := listener.Next(ctx)
+	if err != nil {
+		t.Fatalf("Next after resync: %v", err)
+	}
+
+	if got.Name != "hello" || got.SenderChannelID != "instance-b:1:0" {
+		t.Errorf("got message %v, expected hello from instance-b:1:0", got)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, tt := range []struct {
+		name  string
+		auth  client.AuthFunc
+		check func(r *http.Request) bool
+	}{
+		{
+			"Header",
+			client.HeaderAuth("Authentication", "Bearer token"),
+			func(r *http.Request) bool { return r.Header.Get("Authentication") == "Bearer token" },
+		},
+		{
+			"Cookie",
+			client.CookieAuth("refreshId", "secret"),
+			func(r *http.Request) bool {
+				cookie, err := r.Cookie("refreshId")
+				return err == nil && cookie.Value == "secret"
+			},
+		},
+		{
+			"Anonymous",
+			nil,
+			func(r *http.Request) bool { return r.Header.Get("Authentication") == "" && len(r.Cookies()) == 0 },
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &pollServer{channels: []string{"instance-a:1:0"}}
+			server := httptest.NewServer(fake)
+			defer server.Close()
+
+			c := client.New(server.URL)
+			c.Auth = tt.auth
+
+			if _, err := c.NotifyListen(ctx, 1); err != nil {
+				t.Fatalf("NotifyListen: %v", err)
+			}
+
+			if n := fake.requestCount(); n != 1 {
+				t.Fatalf("server got %d requests, expected 1", n)
+			}
+
+			if !tt.check(fake.requests[0]) {
+				t.Errorf("request has wrong authentication: header %v", fake.requests[0].Header)
+			}
+		})
+	}
+
+	t.Run("Error", func(t *testing.T) {
+		fake := &pollServer{channels: []string{"instance-a:1:0"}}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+
+		authErr := errors.New("no token")
+		c := client.New(server.URL)
+		c.Auth = func(r *http.Request) error { return authErr }
+
+		if _, err := c.NotifyListen(ctx, 1); !errors.Is(err, authErr) {
+			t.Errorf("NotifyListen returned `%v`, expected `%v`", err, authErr)
+		}
+
+		if n := fake.requestCount(); n != 0 {
+			t.Errorf("server got %d requests, expected none", n)
+		}
+	})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/client"
+)
+
+type userIDKey struct{}
+
+// headerAuther reads the user id from the header `Authentication: user-5`.
+type headerAuther struct{}
+
+func (headerAuther) Authenticate(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	uid, _ := strconv.Atoi(strings.TrimPrefix(r.Header.Get("Authentication"), "user-"))
+	return context.WithValue(r.Context(), userIDKey{}, uid), nil
+}
+
+func (headerAuther) FromContext(ctx context.Context) int {
+	uid, _ := ctx.Value(userIDKey{}).(int)
+	return uid
+}
+
+// newTestClient returns a client for the user.
+func newTestClient(server *httptest.Server, userID int) *client.Client {
+	c := client.New(server.URL)
+	c.HTTPClient = server.Client()
+	c.ReconnectDelay = time.Millisecond
+	c.Auth = client.HeaderAuth("Authentication", "user-"+strconv.Itoa(userID))
+	return c
+}
+
+// flakyHandler returns 503 for the next fail poll requests with a cursor.
+type flakyHandler struct {
+	handler atomic.Pointer[http.Handler]
+	fail    atomic.Int32
+}
+
+func newFlakyHandler(handler http.Handler) *flakyHandler {
+	var h flakyHandler
+	h.handler.Store(&handler)
+	return &h
+}
+
+func (h *flakyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Has("cursor") && h.fail.Add(-1) >= 0 {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	(*h.handler.Load()).ServeHTTP(w, r)
+}
+
+func newTestServer(t *testing.T) (*httptest.Server, *flakyHandler) {
+	cfg := newTestConfig(t)
+	cfg.auth = headerAuther{}
+
+	handler := newFlakyHandler(cfg.handler())
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server, handler
+}
+
+func TestClientNotify(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.Run("Publish and listen", func(t *testing.T) {
+		server, _ := newTestServer(t)
+
+		listener, err := newTestClient(server, 2).NotifyListen(ctx, 1)
+		if err != nil {
+			t.Fatalf("NotifyListen: %v", err)
+		}
+
+		err = newTestClient(server, 3).NotifyPublish(ctx, client.Message{
+			ToMeeting: 1,
+			Name:      "hello",
+			Message:   []byte(`"world"`),
+		})
+		if err != nil {
+			t.Fatalf("NotifyPublish: %v", err)
+		}
+
+		got, err := listener.Next(ctx)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+
+		if got.Name != "hello" || string(got.Message) != `"world"` || got.SenderUserID != 3 {
+			t.Errorf("got message %v, expected hello world from user 3", got)
+		}
+	})
+
+	t.Run("Publish with listener", func(t *testing.T) {
+		server, _ := newTestServer(t)
+
+		listener, err := newTestClient(server, 2).NotifyListen(ctx, 0)
+		if err != nil {
+			t.Fatalf("NotifyListen: %v", err)
+		}
+
+		if err := listener.Publish(ctx, client.Message{ToUsers: []int{2}, Name: "self"}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+
+		got, err := listener.Next(ctx)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+
+		if got.SenderChannelID != listener.ChannelID() {
+			t.Errorf("got sender channel %s, expected %s", got.SenderChannelID, listener.ChannelID())
+		}
+	})
+
+	t.Run("Resume after errors", func(t *testing.T) {
+		server, flaky := newTestServer(t)
+		publisher := newTestClient(server, 3)
+
+		listener, err := newTestClient(server, 2).NotifyListen(ctx, 1)
+		if err != nil {
+			t.Fatalf("NotifyListen: %v", err)
+		}
+
+		flaky.fail.Store(3)
+		for _, name := range []string{"first", "second"} {
+			if err := publisher.NotifyPublish(ctx, client.Message{ToMeeting: 1, Name: name}); err != nil {
+				t.Fatalf("NotifyPublish: %v", err)
+			}
+		}
+
+		for _, expect := range []string{"first", "second"} {
+			got, err := listener.Next(ctx)
+			if err != nil {
+				t.Fatalf("Next: %v", err)
+			}
+
+			if got.Name != expect {
+				t.Errorf("got message %s, expected %s", got.Name, expect)
+			}
+		}
+	})
+
+	t.Run("Channel lost", func(t *testing.T) {
+		server, flaky := newTestServer(t)
+		publisher := newTestClient(server, 3)
+
+		listener, err := newTestClient(server, 2).NotifyListen(ctx, 1)
+		if err != nil {
+			t.Fatalf("NotifyListen: %v", err)
+		}
+		oldChannel := listener.ChannelID()
+
+		// Simulate a restart of the server.
+		cfg := newTestConfig(t)
+		cfg.auth = headerAuther{}
+		handler := cfg.handler()
+		flaky.handler.Store(&handler)
+
+		if _, err := listener.Next(ctx); !errors.Is(err, client.ErrResync) {
+			t.Fatalf("Next returned `%v`, expected ErrResync", err)
+		}
+
+		if listener.ChannelID() == oldChannel {
+			t.Errorf("listener did not get a new channel")
+		}
+
+		if err := publisher.NotifyPublish(ctx, client.Message{ToMeeting: 1, Name: "after"}); err != nil {
+			t.Fatalf("NotifyPublish: %v", err)
+		}
+
+		got, err := listener.Next(ctx)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+
+		if got.Name != "after" {
+			t.Errorf("got message %s, expected after", got.Name)
+		}
+	})
+
+	t.Run("Anonymous", func(t *testing.T) {
+		server, _ := newTestServer(t)
+
+		_, err := newTestClient(server, 0).NotifyListen(ctx, 1)
+
+		var errResp *client.Error
+		if !errors.As(err, &errResp) {
+			t.Fatalf("NotifyListen returned `%v`, expected a client.Error", err)
+		}
+
+		if errResp.Status != 401 || errResp.Type != "unauthorized" {
+			t.Errorf("got error %v, expected status 401 and type unauthorized", errResp)
+		}
+	})
+}
+
+func TestClientApplause(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	server, flaky := newTestServer(t)
+	c := newTestClient(server, 2)
+	listener := c.ApplauseListen(1)
+
+	got, err := listener.Next(ctx)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+
+	if got != (client.Applause{Level: 0, PresentUsers: 1}) {
+		t.Errorf("got applause %v, expected level 0 with 1 present user", got)
+	}
+
+	flaky.fail.Store(2)
+	if err := c.ApplauseSend(ctx, 1); err != nil {
+		t.Fatalf("ApplauseSend: %v", err)
+	}
+
+	got, err = listener.Next(ctx)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+
+	if got.Level != 1 {
+		t.Errorf("got applause level %d, expected 1", got.Level)
+	}
+}
@@ -0,0 +1,549 @@
+// Package admin lets superadmins inspect and close the streams of all
+// instances.
+//
+// Each instance registers itself in the backend under a unique name and
+// saves its open streams there regularly.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/connection"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icclog"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccstatus"
+	"github.com/OpenSlides/openslides-icc-service/internal/notify"
+	"github.com/OpenSlides/openslides-icc-service/internal/permission"
+	"github.com/peb-adr/openslides-go/datastore/dsfetch"
+	"github.com/peb-adr/openslides-go/datastore/flow"
+)
+
+const (
+	// saveInterval is the time between two saves of the streams of this
+	// instance.
+	saveInterval = 5 * time.Second
+
+	// streamsTTL is the time after the streams of an instance are ignored, if
+	// they were not saved again. For example, when the instance was killed.
+	streamsTTL = 3 * saveInterval
+
+	// registerTries is the number of instance names, that are tried, before
+	// the registration fails.
+	registerTries = 10
+
+	// listenBackoffMin and listenBackoffMax are the limits of the time
+	// between two tries to receive the admin commands, when the backend fails.
+	listenBackoffMin = 500 * time.Millisecond
+	listenBackoffMax = 30 * time.Second
+)
+
+// Backend shares the streams and the admin commands between all instances.
+type Backend interface {
+	// AdminSaveStreams saves the encoded streams of an instance. It replaces
+	// the streams, that were saved before.
+	AdminSaveStreams(instance string, streams []byte) error
+
+	// AdminRegisterStreams saves the encoded streams of an instance, if there
+	// are no streams of the instance. It returns false, if the instance name
+	// is already taken.
+	AdminRegisterStreams(instance string, streams []byte) (bool, error)
+
+	// AdminStreams returns the saved streams of all instances.
+	AdminStreams() (map[string][]byte, error)
+
+	// AdminRemoveStreams removes the saved streams of an instance.
+	AdminRemoveStreams(instance string) error
+
+	// AdminPublish sends a command to all instances.
+	AdminPublish(command []byte) error
+
+	// AdminReceive blocks until there is a new command.
+	//
+	// It is expected, that only one goroutine is calling this function.
+	AdminReceive(ctx context.Context) ([]byte, error)
+}
+
+// Stream is an open stream on an instance.
+type Stream struct {
+	connection.Info
+	Instance string `json:"instance"`
+}
+
+// Instance is a running instance of the service.
+type Instance struct {
+	ID      string    `json:"id"`
+	Streams int       `json:"streams"`
+	Updated time.Time `json:"updated"`
+}
+
+// Command tells all instances to close streams. Only one of the fields can be
+// set.
+type Command struct {
+	StreamID  string `json:"stream_id,omitempty"`
+	ChannelID string `json:"channel_id,omitempty"`
+	UserID    int    `json:"user_id,omitempty"`
+}
+
+func (c Command) validate() error {
+	var set int
+	for _, isSet := range []bool{c.StreamID != "", c.ChannelID != "", c.UserID != 0} {
+		if isSet {
+			set++
+		}
+	}
+
+	if set != 1 {
+		return iccerror.NewMessageError(iccerror.ErrInvalid, "Exactly one of stream_id, channel_id and user_id has to be set.")
+	}
+	return nil
+}
+
+func (c Command) matches(info connection.Info) bool {
+	switch {
+	case c.StreamID != "":
+		return info.ID == c.StreamID
+	case c.ChannelID != "":
+		return info.ChannelID == c.ChannelID
+	default:
+		return info.UserID == c.UserID
+	}
+}
+
+// message is sent through the backend to all instances.
+type message struct {
+	Command
+
+	// Evict is true, if the stream is closed because the user opened too many
+	// streams.
+	Evict bool `json:"evict,omitempty"`
+
+	// Instance is the only instance, that handles the message. If empty, all
+	// instances handle it.
+	Instance string `json:"instance,omitempty"`
+}
+
+// instanceStreams is the format, the streams of an instance are saved in the
+// backend.
+//
+// The saved streams are also the registration of the instance. An instance is
+// alive, as long as it updates its streams.
+type instanceStreams struct {
+	Updated int64             `json:"updated"`
+	Streams []connection.Info `json:"streams"`
+}
+
+// expired returns true, if the streams were not updated for streamsTTL. In
+// this case, the instance is not running anymore.
+func (s instanceStreams) expired() bool {
+	return time.Since(time.Unix(s.Updated, 0)) > streamsTTL
+}
+
+// Admin holds the state of the admin service.
+type Admin struct {
+	backend   Backend
+	datastore flow.Getter
+	streams   *connection.Registry
+
+	// instance is replaced by Register, while the handlers can already read
+	// it.
+	instanceMu sync.Mutex
+	instance   string
+}
+
+// New initializes the admin service.
+//
+// The streams of the registry are shared with the other instances under the
+// given instance name. Register can replace the name, if it is already taken.
+// The returned function starts the background tasks.
+func New(b Backend, db flow.Getter, streams *connection.Registry, instance string) (*Admin, func(context.Context, func(error))) {
+	admin := Admin{
+		backend:   b,
+		datastore: db,
+		streams:   streams,
+		instance:  instance,
+	}
+
+	background := func(ctx context.Context, errHandler func(error)) {
+		go admin.listen(ctx, errHandler)
+		go admin.saveStreams(ctx, errHandler)
+	}
+
+	return &admin, background
+}
+
+// NewInstanceID returns a name for this instance. It contains the hostname
+// and a random part.
+func NewInstanceID() string {
+	const charset = "abcdefghijklmnopqrstuvwxyz0123456789"
+	const length = 6
+
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = charset[rand.Intn(len(charset))]
+	}
+
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return string(b)
+	}
+	return host + "-" + string(b)
+}
+
+// Register claims the instance name in the backend, so no other instance can
+// use it. If the name is taken, a new one is created with NewInstanceID. The
+// saved streams of dead instances are removed first.
+//
+// If the backend fails, Register retries until it works or the context is
+// done. Each failed try is given to errHandler. It has to be called before the
+// background tasks are started. Returns the registered name.
+func (a *Admin) Register(ctx context.Context, errHandler func(error)) (string, error) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	backoff := iccstatus.Backoff{Min: 500 * time.Millisecond, Max: 30 * time.Second}
+	for {
+		instance, err := a.register()
+		if err == nil {
+			a.instanceMu.Lock()
+			a.instance = instance
+			a.instanceMu.Unlock()
+			return instance, nil
+		}
+
+		errHandler(fmt.Errorf("registering instance: %w", err))
+		if err := backoff.Wait(ctx); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (a *Admin) register() (string, error) {
+	if err := a.removeDead(); err != nil {
+		return "", fmt.Errorf("removing dead instances: %w", err)
+	}
+
+	encoded, err := a.encodeStreams()
+	if err != nil {
+		return "", err
+	}
+
+	instance := a.instanceID()
+	for range registerTries {
+		ok, err := a.backend.AdminRegisterStreams(instance, encoded)
+		if err != nil {
+			return "", fmt.Errorf("registering instance %s: %w", instance, err)
+		}
+
+		if ok {
+			return instance, nil
+		}
+		instance = NewInstanceID()
+	}
+	return "", fmt.Errorf("no free instance name after %d tries", registerTries)
+}
+
+// instanceID returns the name of this instance.
+func (a *Admin) instanceID() string {
+	a.instanceMu.Lock()
+	defer a.instanceMu.Unlock()
+
+	return a.instance
+}
+
+// removeDead removes the saved streams of instances, that did not save them
+// for streamsTTL.
+func (a *Admin) removeDead() error {
+	saved, err := a.backend.AdminStreams()
+	if err != nil {
+		return fmt.Errorf("getting streams from backend: %w", err)
+	}
+
+	for instance, encoded := range saved {
+		var data instanceStreams
+		if err := json.Unmarshal(encoded, &data); err == nil && !data.expired() {
+			continue
+		}
+
+		if err := a.backend.AdminRemoveStreams(instance); err != nil {
+			return fmt.Errorf("removing streams of %s: %w", instance, err)
+		}
+	}
+	return nil
+}
+
+// otherInstances returns the saved streams of the other instances, that are
+// alive.
+func (a *Admin) otherInstances() (map[string]instanceStreams, error) {
+	saved, err := a.backend.AdminStreams()
+	if err != nil {
+		return nil, fmt.Errorf("getting streams from backend: %w", err)
+	}
+
+	instances := make(map[string]instanceStreams, len(saved))
+	for instance, encoded := range saved {
+		if instance == a.instanceID() {
+			continue
+		}
+
+		var data instanceStreams
+		if err := json.Unmarshal(encoded, &data); err != nil {
+			return nil, fmt.Errorf("decoding streams of instance %s: %w", instance, err)
+		}
+
+		if data.expired() {
+			continue
+		}
+
+		instances[instance] = data
+	}
+	return instances, nil
+}
+
+// Instances returns the running instances with their number of open streams.
+func (a *Admin) Instances(ctx context.Context, userID int) ([]Instance, error) {
+	if err := a.checkSuperadmin(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	others, err := a.otherInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]Instance, 0, len(others)+1)
+	for instance, data := range others {
+		instances = append(instances, Instance{
+			ID:      instance,
+			Streams: len(data.Streams),
+			Updated: time.Unix(data.Updated, 0).UTC(),
+		})
+	}
+
+	instances = append(instances, Instance{
+		ID:      a.instanceID(),
+		Streams: a.streams.Count(),
+		Updated: time.Now().UTC().Truncate(time.Second),
+	})
+
+	sort.Slice(instances, func(i, j int) bool {
+		return instances[i].ID < instances[j].ID
+	})
+
+	return instances, nil
+}
+
+// Streams returns the open streams of all instances.
+func (a *Admin) Streams(ctx context.Context, userID int) ([]Stream, error) {
+	if err := a.checkSuperadmin(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	others, err := a.otherInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	var streams []Stream
+	for instance, data := range others {
+		for _, info := range data.Streams {
+			streams = append(streams, Stream{Info: info, Instance: instance})
+		}
+	}
+
+	// Use the streams of this instance directly, so they are up to date.
+	for _, info := range a.streams.List() {
+		streams = append(streams, Stream{Info: info, Instance: a.instanceID()})
+	}
+
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].Started.Before(streams[j].Started)
+	})
+
+	return streams, nil
+}
+
+// Close sends the command to all instances. Each instance closes its
+// matching streams.
+//
+// A command for a channel is only handled by the instance, that created the
+// channel. If this instance is not running, an error is returned.
+func (a *Admin) Close(ctx context.Context, userID int, command Command) error {
+	if err := a.checkSuperadmin(ctx, userID); err != nil {
+		return err
+	}
+
+	if err := command.validate(); err != nil {
+		return err
+	}
+
+	m := message{Command: command}
+	if command.ChannelID != "" {
+		instance := notify.ChannelInstance(command.ChannelID)
+
+		running, err := a.isRunning(instance)
+		if err != nil {
+			return fmt.Errorf("checking instance of channel: %w", err)
+		}
+
+		if !running {
+			return iccerror.NewMessageError(iccerror.ErrNotFound, "The channel does not belong to a running instance.")
+		}
+		m.Instance = instance
+	}
+
+	return a.publish(m)
+}
+
+// isRunning returns true, if the instance is this instance or another
+// instance, that is alive.
+func (a *Admin) isRunning(instance string) (bool, error) {
+	if instance == "" {
+		return false, nil
+	}
+
+	if instance == a.instanceID() {
+		return true, nil
+	}
+
+	others, err := a.otherInstances()
+	if err != nil {
+		return false, err
+	}
+
+	_, ok := others[instance]
+	return ok, nil
+}
+
+// Evict closes a stream on any instance, because the user or the meeting has
+// too many streams.
+func (a *Admin) Evict(streamID string) error {
+	return a.publish(message{Command: Command{StreamID: streamID}, Evict: true})
+}
+
+func (a *Admin) publish(m message) error {
+	encoded, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("encoding command: %w", err)
+	}
+
+	if err := a.backend.AdminPublish(encoded); err != nil {
+		return fmt.Errorf("publishing command: %w", err)
+	}
+
+	return nil
+}
+
+func (a *Admin) checkSuperadmin(ctx context.Context, userID int) error {
+	superadmin, err := permission.IsSuperadmin(ctx, dsfetch.New(a.datastore), userID)
+	if err != nil {
+		return fmt.Errorf("checking for superadmin: %w", err)
+	}
+
+	if !superadmin {
+		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "Only superadmins can use the admin api.")
+	}
+	return nil
+}
+
+// listen receives the commands from the backend and closes the matching
+// streams of this instance.
+func (a *Admin) listen(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	closedByAdmin := iccerror.NewMessageError(iccerror.ErrNotAllowed, "The stream was closed by an admin.")
+	evicted := iccerror.NewMessageError(iccerror.ErrLimit, "The stream was closed, because too many streams were opened.")
+
+	backoff := iccstatus.Backoff{Min: listenBackoffMin, Max: listenBackoffMax}
+	for {
+		encoded, err := a.backend.AdminReceive(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+
+			errHandler(fmt.Errorf("receiving admin command from backend: %w", err))
+			if err := backoff.Wait(ctx); err != nil {
+				return
+			}
+			continue
+		}
+		backoff.Reset()
+
+		var m message
+		if err := json.Unmarshal(encoded, &m); err != nil {
+			errHandler(fmt.Errorf("decoding admin command: %w", err))
+			continue
+		}
+
+		if err := m.validate(); err != nil {
+			errHandler(fmt.Errorf("invalid admin command: %w", err))
+			continue
+		}
+
+		if m.Instance != "" && m.Instance != a.instanceID() {
+			continue
+		}
+
+		cause := closedByAdmin
+		if m.Evict {
+			cause = evicted
+		}
+
+		if closed := a.streams.Close(m.matches, cause); closed > 0 {
+			icclog.Info(ctx, "Closed streams by admin command", "streams", closed, "stream_id", m.StreamID, "channel_id", m.ChannelID, "user_id", m.UserID, "evict", m.Evict)
+		}
+	}
+}
+
+// saveStreams saves the streams of this instance to the backend until the
+// context is done.
+func (a *Admin) saveStreams(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	tick := time.NewTicker(saveInterval)
+	defer tick.Stop()
+
+	for {
+		encoded, err := a.encodeStreams()
+		if err != nil {
+			errHandler(err)
+		} else if err := a.backend.AdminSaveStreams(a.instanceID(), encoded); err != nil {
+			errHandler(fmt.Errorf("saving streams: %w", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			if err := a.backend.AdminRemoveStreams(a.instanceID()); err != nil {
+				errHandler(fmt.Errorf("removing streams: %w", err))
+			}
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// encodeStreams returns the streams of this instance in the format, they are
+// saved in the backend.
+func (a *Admin) encodeStreams() ([]byte, error) {
+	encoded, err := json.Marshal(instanceStreams{
+		Updated: time.Now().Unix(),
+		Streams: a.streams.List(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding streams: %w", err)
+	}
+	return encoded, nil
+}
@@ -0,0 +1,344 @@
+package admin_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/admin"
+	"github.com/OpenSlides/openslides-icc-service/internal/connection"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/peb-adr/openslides-go/datastore/dsmock"
+)
+
+const datastoreData = `---
+user/1/organization_management_level: superadmin
+user/2/id: 2
+`
+
+func TestStreams(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Not superadmin", func(t *testing.T) {
+		a, _ := admin.New(newBackendStub(), dsmock.Stub(dsmock.YAMLData(datastoreData)), new(connection.Registry), "me")
+
+		_, err := a.Streams(ctx, 2)
+
+		if !errors.Is(err, iccerror.ErrNotAllowed) {
+			t.Errorf("Got error `%v`, expected `%v`", err, iccerror.ErrNotAllowed)
+		}
+	})
+
+	t.Run("Local and other instance", func(t *testing.T) {
+		backend := newBackendStub()
+		backend.AdminSaveStreams("other", []byte(fmt.Sprintf(
+			`{"updated":%d,"streams":[{"kind":"notify","user_id":5,"meeting_id":1,"channel_id":"abc:5:0","started":"2020-01-01T00:00:00Z"}]}`,
+			time.Now().Unix(),
+		)))
+		backend.AdminSaveStreams("dead", []byte(`{"updated":1,"streams":[{"kind":"notify","user_id":6}]}`))
+
+		streams := new(connection.Registry)
+		_, done, _ := streams.Open(ctx, connection.Info{Kind: connection.KindApplause, UserID: 7, MeetingID: 1})
+		defer done()
+
+		a, _ := admin.New(backend, dsmock.Stub(dsmock.YAMLData(datastoreData)), streams, "me")
+
+		got, err := a.Streams(ctx, 1)
+		if err != nil {
+			t.Fatalf("Streams: %v", err)
+		}
+
+		if len(got) != 2 {
+			t.Fatalf("Got %d streams, expected 2: %v", len(got), got)
+		}
+
+		if got[0].Instance != "other" || got[0].UserID != 5 || got[0].ChannelID != "abc:5:0" {
+			t.Errorf("Got first stream %v, expected the stream of the other instance", got[0])
+		}
+
+		if got[1].Instance != "me" || got[1].UserID != 7 {
+			t.Errorf("Got second stream %v, expected the local stream", got[1])
+		}
+	})
+}
+
+func TestClose(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Not superadmin", func(t *testing.T) {
+		a, _ := admin.New(newBackendStub(), dsmock.Stub(dsmock.YAMLData(datastoreData)), new(connection.Registry), "me")
+
+		err := a.Close(ctx, 2, admin.Command{UserID: 5})
+
+		if !errors.Is(err, iccerror.ErrNotAllowed) {
+			t.Errorf("Got error `%v`, expected `%v`", err, iccerror.ErrNotAllowed)
+		}
+	})
+
+	t.Run("Invalid command", func(t *testing.T) {
+		a, _ := admin.New(newBackendStub(), dsmock.Stub(dsmock.YAMLData(datastoreData)), new(connection.Registry), "me")
+
+		for _, command := range []admin.Command{{}, {UserID: 5, ChannelID: "abc:5:0"}} {
+			err := a.Close(ctx, 1, command)
+
+			if !errors.Is(err, iccerror.ErrInvalid) {
+				t.Errorf("Close(%v) returned `%v`, expected `%v`", command, err, iccerror.ErrInvalid)
+			}
+		}
+	})
+
+	t.Run("Close user", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		streams := new(connection.Registry)
+		userCtx, done1, _ := streams.Open(ctx, connection.Info{Kind: connection.KindNotify, UserID: 5, ChannelID: "abc:5:0"})
+		defer done1()
+		otherCtx, done2, _ := streams.Open(ctx, connection.Info{Kind: connection.KindNotify, UserID: 6, ChannelID: "abc:6:1"})
+		defer done2()
+
+		a, background := admin.New(newBackendStub(), dsmock.Stub(dsmock.YAMLData(datastoreData)), streams, "me")
+		background(ctx, nil)
+
+		if err := a.Close(ctx, 1, admin.Command{UserID: 5}); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		select {
+		case <-userCtx.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("Stream of user 5 was not closed")
+		}
+
+		if !errors.Is(context.Cause(userCtx), iccerror.ErrNotAllowed) {
+			t.Errorf("Stream was closed with `%v`, expected `%v`", context.Cause(userCtx), iccerror.ErrNotAllowed)
+		}
+
+		if otherCtx.Err() != nil {
+			t.Errorf("Stream of user 6 was closed")
+		}
+	})
+
+	t.Run("Close channel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		streams := new(connection.Registry)
+		channelCtx, done1, _ := streams.Open(ctx, connection.Info{Kind: connection.KindNotify, UserID: 5, ChannelID: "me:5:0"})
+		defer done1()
+		otherCtx, done2, _ := streams.Open(ctx, connection.Info{Kind: connection.KindNotify, UserID: 5, ChannelID: "me:5:1"})
+		defer done2()
+
+		a, background := admin.New(newBackendStub(), dsmock.Stub(dsmock.YAMLData(datastoreData)), streams, "me")
+		background(ctx, nil)
+
+		if err := a.Close(ctx, 1, admin.Command{ChannelID: "me:5:0"}); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		select {
+		case <-channelCtx.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("Stream of the channel was not closed")
+		}
+
+		if otherCtx.Err() != nil {
+			t.Errorf("Other stream of the user was closed")
+		}
+	})
+
+	t.Run("Close channel of other instance", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		backend := newBackendStub()
+		backend.AdminSaveStreams("other", []byte(fmt.Sprintf(`{"updated":%d,"streams":[]}`, time.Now().Unix())))
+
+		streams := new(connection.Registry)
+		channelCtx, done, _ := streams.Open(ctx, connection.Info{Kind: connection.KindNotify, UserID: 5, ChannelID: "other:5:0"})
+		defer done()
+
+		a, background := admin.New(backend, dsmock.Stub(dsmock.YAMLData(datastoreData)), streams, "me")
+		background(ctx, nil)
+
+		if err := a.Close(ctx, 1, admin.Command{ChannelID: "other:5:0"}); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		// Give the command time to arrive.
+		time.Sleep(10 * time.Millisecond)
+
+		if channelCtx.Err() != nil {
+			t.Errorf("Stream with the same channel id was closed by the wrong instance")
+		}
+	})
+
+	t.Run("Close channel of unknown instance", func(t *testing.T) {
+		backend := newBackendStub()
+		backend.AdminSaveStreams("dead", []byte(`{"updated":1,"streams":[]}`))
+
+		a, _ := admin.New(backend, dsmock.Stub(dsmock.YAMLData(datastoreData)), new(connection.Registry), "me")
+
+		for _, cid := range []string{"dead:5:0", "unknown:5:0", "invalid"} {
+			err := a.Close(ctx, 1, admin.Command{ChannelID: cid})
+
+			if !errors.Is(err, iccerror.ErrNotFound) {
+				t.Errorf("Close(%s) returned `%v`, expected `%v`", cid, err, iccerror.ErrNotFound)
+			}
+		}
+	})
+
+	t.Run("Evict", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		streams := new(connection.Registry)
+		streamCtx, done, _ := streams.Open(ctx, connection.Info{ID: "stream1", Kind: connection.KindNotify, UserID: 5})
+		defer done()
+
+		a, background := admin.New(newBackendStub(), dsmock.Stub(dsmock.YAMLData(datastoreData)), streams, "me")
+		background(ctx, nil)
+
+		if err := a.Evict("stream1"); err != nil {
+			t.Fatalf("Evict: %v", err)
+		}
+
+		select {
+		case <-streamCtx.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("Stream was not closed")
+		}
+
+		if !errors.Is(context.Cause(streamCtx), iccerror.ErrLimit) {
+			t.Errorf("Stream was closed with `%v`, expected `%v`", context.Cause(streamCtx), iccerror.ErrLimit)
+		}
+	})
+}
+
+func TestRegister(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Free name", func(t *testing.T) {
+		backend := newBackendStub()
+		a, _ := admin.New(backend, dsmock.Stub(dsmock.YAMLData(datastoreData)), new(connection.Registry), "me")
+
+		instance, err := a.Register(ctx, nil)
+		if err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+
+		if instance != "me" {
+			t.Errorf("Register returned %s, expected me", instance)
+		}
+
+		if saved, _ := backend.AdminStreams(); saved["me"] == nil {
+			t.Errorf("instance was not saved in the backend")
+		}
+	})
+
+	t.Run("Name taken", func(t *testing.T) {
+		backend := newBackendStub()
+		backend.AdminSaveStreams("me", []byte(fmt.Sprintf(`{"updated":%d,"streams":[]}`, time.Now().Unix())))
+
+		a, _ := admin.New(backend, dsmock.Stub(dsmock.YAMLData(datastoreData)), new(connection.Registry), "me")
+
+		instance, err := a.Register(ctx, nil)
+		if err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+
+		if instance == "me" || instance == "" {
+			t.Errorf("Register returned %q, expected a new name", instance)
+		}
+	})
+
+	t.Run("Backend error", func(t *testing.T) {
+		backend := newBackendStub()
+		backend.registerErrors = 1
+
+		a, _ := admin.New(backend, dsmock.Stub(dsmock.YAMLData(datastoreData)), new(connection.Registry), "me")
+
+		var errs []error
+		instance, err := a.Register(ctx, func(err error) { errs = append(errs, err) })
+		if err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+
+		if instance != "me" {
+			t.Errorf("Register returned %s, expected me", instance)
+		}
+
+		if len(errs) != 1 {
+			t.Errorf("Got %d errors, expected 1", len(errs))
+		}
+	})
+
+	t.Run("Dead instance is removed", func(t *testing.T) {
+		backend := newBackendStub()
+		backend.AdminSaveStreams("dead", []byte(`{"updated":1,"streams":[]}`))
+		backend.AdminSaveStreams("me", []byte(`{"updated":1,"streams":[]}`))
+
+		a, _ := admin.New(backend, dsmock.Stub(dsmock.YAMLData(datastoreData)), new(connection.Registry), "me")
+
+		instance, err := a.Register(ctx, nil)
+		if err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+
+		if instance != "me" {
+			t.Errorf("Register returned %s, expected me", instance)
+		}
+
+		if saved, _ := backend.AdminStreams(); saved["dead"] != nil {
+			t.Errorf("dead instance was not removed")
+		}
+	})
+}
+
+func TestInstances(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Not superadmin", func(t *testing.T) {
+		a, _ := admin.New(newBackendStub(), dsmock.Stub(dsmock.YAMLData(datastoreData)), new(connection.Registry), "me")
+
+		_, err := a.Instances(ctx, 2)
+
+		if !errors.Is(err, iccerror.ErrNotAllowed) {
+			t.Errorf("Got error `%v`, expected `%v`", err, iccerror.ErrNotAllowed)
+		}
+	})
+
+	t.Run("Running instances", func(t *testing.T) {
+		backend := newBackendStub()
+		backend.AdminSaveStreams("other", []byte(fmt.Sprintf(
+			`{"updated":%d,"streams":[{"kind":"notify","user_id":5},{"kind":"applause","user_id":6}]}`,
+			time.Now().Unix(),
+		)))
+		backend.AdminSaveStreams("dead", []byte(`{"updated":1,"streams":[{"kind":"notify","user_id":6}]}`))
+
+		streams := new(connection.Registry)
+		_, done, _ := streams.Open(ctx, connection.Info{Kind: connection.KindApplause, UserID: 7, MeetingID: 1})
+		defer done()
+
+		a, _ := admin.New(backend, dsmock.Stub(dsmock.YAMLData(datastoreData)), streams, "me")
+
+		got, err := a.Instances(ctx, 1)
+		if err != nil {
+			t.Fatalf("Instances: %v", err)
+		}
+
+		if len(got) != 2 {
+			t.Fatalf("Got %d instances, expected 2: %v", len(got), got)
+		}
+
+		if got[0].ID != "me" || got[0].Streams != 1 {
+			t.Errorf("Got first instance %v, expected this instance with 1 stream", got[0])
+		}
+
+		if got[1].ID != "other" || got[1].Streams != 2 {
+			t.Errorf("Got second instance %v, expected the other instance with 2 streams", got[1])
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+)
+
+// maxCloseSize is the maximum size of the body of a close request.
+const maxCloseSize = 1 << 12
+
+// Lister returns the open streams of all instances.
+type Lister interface {
+	Streams(ctx context.Context, userID int) ([]Stream, error)
+}
+
+// HandleStreams registers the admin/streams route.
+//
+// It returns all open notify and applause streams of all instances.
+func HandleStreams(mux *http.ServeMux, admin Lister, auth icchttp.Authenticater) {
+	url := icchttp.Path + "/admin/streams"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store, max-age=0")
+
+		uid := auth.FromContext(r.Context())
+		if uid == 0 {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrUnauthorized, "Anonymous user can not use the admin api."))
+			return
+		}
+
+		streams, err := admin.Streams(r.Context(), uid)
+		if err != nil {
+			icchttp.Error(w, fmt.Errorf("getting streams: %w", err))
+			return
+		}
+
+		if streams == nil {
+			streams = []Stream{}
+		}
+
+		response := struct {
+			Streams []Stream `json:"streams"`
+		}{streams}
+
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			icchttp.ErrorNoStatus(w, fmt.Errorf("encoding streams: %w", err))
+			return
+		}
+	})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
+
+// InstanceLister returns the running instances.
+type InstanceLister interface {
+	Instances(ctx context.Context, userID int) ([]Instance, error)
+}
+
+// HandleInstances registers the admin/instances route.
+//
+// It returns all running instances with their number of open streams.
+func HandleInstances(mux *http.ServeMux, admin InstanceLister, auth icchttp.Authenticater) {
+	url := icchttp.Path + "/admin/instances"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store, max-age=0")
+
+		uid := auth.FromContext(r.Context())
+		if uid == 0 {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrUnauthorized, "Anonymous user can not use the admin api."))
+			return
+		}
+
+		instances, err := admin.Instances(r.Context(), uid)
+		if err != nil {
+			icchttp.Error(w, fmt.Errorf("getting instances: %w", err))
+			return
+		}
+
+		response := struct {
+			Instances []Instance `json:"instances"`
+		}{instances}
+
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			icchttp.ErrorNoStatus(w, fmt.Errorf("encoding instances: %w", err))
+			return
+		}
+	})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
+
+// Closer closes streams on all instances.
+type Closer interface {
+	Close(ctx context.Context, userID int, command Command) error
+}
+
+// HandleClose registers the admin/close route.
+//
+// The body has to be a json object with one of the fields stream_id,
+// channel_id or user_id. The matching streams are closed on all instances.
+func HandleClose(mux *http.ServeMux, admin Closer, auth icchttp.Authenticater) {
+	url := icchttp.Path + "/admin/close"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		uid := auth.FromContext(r.Context())
+		if uid == 0 {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrUnauthorized, "Anonymous user can not use the admin api."))
+			return
+		}
+
+		var command Command
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCloseSize)).Decode(&command); err != nil {
+			var errTooLarge *http.MaxBytesError
+			if errors.As(err, &errTooLarge) {
+				icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrTooLarge, "Body is bigger then %d bytes.", errTooLarge.Limit))
+				return
+			}
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Body has to be a json object: %v", err))
+			return
+		}
+
+		if err := admin.Close(r.Context(), uid, command); err != nil {
+			icchttp.Error(w, fmt.Errorf("closing streams: %w", err))
+			return
+		}
+	})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
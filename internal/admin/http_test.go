@@ -0,0 +1,158 @@
+package admin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/admin"
+	"github.com/OpenSlides/openslides-icc-service/internal/connection"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icctest"
+)
+
+func TestHandleStreams(t *testing.T) {
+	url := "/system/icc/admin/streams"
+
+	t.Run("Anonymous", func(t *testing.T) {
+		auther := icctest.AutherStub{}
+		mux := http.NewServeMux()
+		admin.HandleStreams(mux, &adminStub{}, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
+
+		if resp.Result().StatusCode != 401 {
+			t.Errorf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+	})
+
+	t.Run("Not allowed", func(t *testing.T) {
+		auther := icctest.AutherStub{UserID: 2}
+		mux := http.NewServeMux()
+		admin.HandleStreams(mux, &adminStub{err: iccerror.ErrNotAllowed}, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
+
+		if resp.Result().StatusCode == 200 {
+			t.Errorf("handler returned status %s", resp.Result().Status)
+		}
+
+		if !strings.Contains(resp.Body.String(), iccerror.ErrNotAllowed.Type()) {
+			t.Errorf("handler returned `%s`, expected to contain `%s`", resp.Body.String(), iccerror.ErrNotAllowed.Type())
+		}
+	})
+
+	t.Run("Streams", func(t *testing.T) {
+		auther := icctest.AutherStub{UserID: 1}
+		stub := adminStub{streams: []admin.Stream{
+			{Info: connection.Info{Kind: connection.KindNotify, UserID: 5, MeetingID: 1, ChannelID: "abc:5:0"}, Instance: "other"},
+		}}
+		mux := http.NewServeMux()
+		admin.HandleStreams(mux, &stub, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if stub.calledUserID != 1 {
+			t.Errorf("admin was called with user %d, expected 1", stub.calledUserID)
+		}
+
+		for _, expect := range []string{`"user_id":5`, `"meeting_id":1`, `"channel_id":"abc:5:0"`, `"instance":"other"`, `"started":`} {
+			if !strings.Contains(resp.Body.String(), expect) {
+				t.Errorf("handler returned `%s`, expected to contain `%s`", resp.Body.String(), expect)
+			}
+		}
+	})
+}
+
+func TestHandleInstances(t *testing.T) {
+	url := "/system/icc/admin/instances"
+
+	t.Run("Anonymous", func(t *testing.T) {
+		auther := icctest.AutherStub{}
+		mux := http.NewServeMux()
+		admin.HandleInstances(mux, &adminStub{}, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
+
+		if resp.Result().StatusCode != 401 {
+			t.Errorf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+	})
+
+	t.Run("Instances", func(t *testing.T) {
+		auther := icctest.AutherStub{UserID: 1}
+		stub := adminStub{instances: []admin.Instance{{ID: "icc-1-abc123", Streams: 5}}}
+		mux := http.NewServeMux()
+		admin.HandleInstances(mux, &stub, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		for _, expect := range []string{`"id":"icc-1-abc123"`, `"streams":5`, `"updated":`} {
+			if !strings.Contains(resp.Body.String(), expect) {
+				t.Errorf("handler returned `%s`, expected to contain `%s`", resp.Body.String(), expect)
+			}
+		}
+	})
+}
+
+func TestHandleClose(t *testing.T) {
+	url := "/system/icc/admin/close"
+
+	t.Run("Anonymous", func(t *testing.T) {
+		auther := icctest.AutherStub{}
+		mux := http.NewServeMux()
+		admin.HandleClose(mux, &adminStub{}, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", url, strings.NewReader(`{"user_id":5}`)))
+
+		if resp.Result().StatusCode != 401 {
+			t.Errorf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+	})
+
+	t.Run("Invalid body", func(t *testing.T) {
+		auther := icctest.AutherStub{UserID: 1}
+		mux := http.NewServeMux()
+		admin.HandleClose(mux, &adminStub{}, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", url, strings.NewReader(`not json`)))
+
+		if resp.Result().StatusCode != 400 {
+			t.Errorf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+	})
+
+	t.Run("Close channel", func(t *testing.T) {
+		auther := icctest.AutherStub{UserID: 1}
+		stub := adminStub{}
+		mux := http.NewServeMux()
+		admin.HandleClose(mux, &stub, &auther)
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", url, strings.NewReader(`{"channel_id":"abc:5:0"}`)))
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if stub.calledCommand.ChannelID != "abc:5:0" {
+			t.Errorf("admin was called with command %v, expected channel abc:5:0", stub.calledCommand)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package admin_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/admin"
+)
+
+type backendStub struct {
+	mu       sync.Mutex
+	streams  map[string][]byte
+	commands chan []byte
+
+	// registerErrors is the number of calls to AdminRegisterStreams, that
+	// fail.
+	registerErrors int
+}
+
+func newBackendStub() *backendStub {
+	return &backendStub{
+		streams:  make(map[string][]byte),
+		commands: make(chan []byte, 10),
+	}
+}
+
+func (b *backendStub) AdminSaveStreams(instance string, streams []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.streams[instance] = streams
+	return nil
+}
+
+func (b *backendStub) AdminRegisterStreams(instance string, streams []byte) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.registerErrors > 0 {
+		b.registerErrors--
+		return false, errors.New("backend is not ready")
+	}
+
+	if _, ok := b.streams[instance]; ok {
+		return false, nil
+	}
+	b.streams[instance] = streams
+	return true, nil
+}
+
+func (b *backendStub) AdminStreams() (map[string][]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := make(map[string][]byte, len(b.streams))
+	for k, v := range b.streams {
+		out[k] = v
+	}
+	return out, nil
+}
+
+func (b *backendStub) AdminRemoveStreams(instance string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.streams, instance)
+	return nil
+}
+
+func (b *backendStub) AdminPublish(command []byte) error {
+	b.commands <- command
+	return nil
+}
+
+func (b *backendStub) AdminReceive(ctx context.Context) ([]byte, error) {
+	select {
+	case command := <-b.commands:
+		return command, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type adminStub struct {
+	streams   []admin.Stream
+	instances []admin.Instance
+	err       error
+
+	calledUserID  int
+	calledCommand admin.Command
+}
+
+func (a *adminStub) Streams(ctx context.Context, userID int) ([]admin.Stream, error) {
+	a.calledUserID = userID
+	return a.streams, a.err
+}
+
+func (a *adminStub) Instances(ctx context.Context, userID int) ([]admin.Instance, error) {
+	a.calledUserID = userID
+	return a.instances, a.err
+}
+
+func (a *adminStub) Close(ctx context.Context, userID int, command admin.Command) error {
+	a.calledUserID = userID
+	a.calledCommand = command
+	return a.err
+}
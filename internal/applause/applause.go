@@ -5,11 +5,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/peb-adr/openslides-go/datastore/dsfetch"
 	"github.com/peb-adr/openslides-go/datastore/flow"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccclock"
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccmetric"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccstatus"
+	"github.com/OpenSlides/openslides-icc-service/internal/permission"
 	"github.com/ostcar/topic"
 )
 
@@ -17,6 +22,11 @@ const (
 	applauseInterval = time.Second
 	countTime        = 5 * time.Second
 	pruneTime        = 10 * time.Minute
+	pruneInterval    = 5 * time.Minute
+
+	// applauseBackoffMax is the maximum time between two fetches, when the
+	// backend fails.
+	applauseBackoffMax = 30 * time.Second
 )
 
 // Backend stores the applause messages.
@@ -37,6 +47,22 @@ type Applause struct {
 	backend   Backend
 	topic     *topic.Topic[string]
 	datastore flow.Getter
+	status    iccstatus.Loop
+	clock     iccclock.Clock
+
+	publishMu sync.Mutex
+	published []publishTime
+}
+
+// publishTime is the time of a message in the topic.
+//
+// The topic saves the real time of each message and Prune expects a real
+// time. The clock can be different, for example in tests. So the time of the
+// clock is saved together with the real time and Prune gets the real time of
+// the first message, that has to be kept.
+type publishTime struct {
+	clock time.Time
+	real  time.Time
 }
 
 // New returns an initialized state of the notify service.
@@ -45,19 +71,34 @@ func New(b Backend, db flow.Getter) (*Applause, func(context.Context, func(error
 		backend:   b,
 		topic:     topic.New[string](),
 		datastore: db,
+		clock:     iccclock.Real{},
 	}
 
 	// Make sure the topic is not empty.
-	notify.topic.Publish("")
+	notify.publish("")
 
 	background := func(ctx context.Context, errHandler func(error)) {
 		go notify.loop(ctx, errHandler)
-		go notify.pruneOldData(ctx)
 	}
 
 	return &notify, background
 }
 
+// SetClock replaces the clock, that is used for the applause times and the
+// background tasks.
+//
+// It has to be called before the background tasks are started. The messages,
+// that are already in the topic, get the current time of the new clock.
+func (a *Applause) SetClock(c iccclock.Clock) {
+	a.publishMu.Lock()
+	defer a.publishMu.Unlock()
+
+	a.clock = c
+	for i := range a.published {
+		a.published[i].clock = c.Now()
+	}
+}
+
 // MSG contians the current applause level and number of present users.
 type MSG struct {
 	Level        int `json:"level"`
@@ -66,6 +107,8 @@ type MSG struct {
 
 // Send registers, that a user applaused in a meeting.
 func (a *Applause) Send(ctx context.Context, meetingID, userID int) error {
+	started := time.Now()
+
 	if userID == 0 {
 		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous is not allowed to applause. Please be quiet.")
 	}
@@ -81,7 +124,7 @@ func (a *Applause) Send(ctx context.Context, meetingID, userID int) error {
 		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "applause is not enabled in meeting %d. Please be quiet.", meetingID)
 	}
 
-	inMeeting, err := isInMeeting(ctx, fetcher, userID, meetingID)
+	inMeeting, err := permission.InMeeting(ctx, fetcher, userID, meetingID)
 	if err != nil {
 		return fmt.Errorf("checking if user is in meeting: %w", err)
 	}
@@ -90,43 +133,12 @@ func (a *Applause) Send(ctx context.Context, meetingID, userID int) error {
 		return iccerror.NewMessageError(iccerror.ErrNotAllowed, "You are not part of meeting %d. Please be quiet.", meetingID)
 	}
 
-	if err := a.backend.ApplausePublish(meetingID, userID, time.Now().Unix()); err != nil {
+	if err := a.backend.ApplausePublish(meetingID, userID, a.clock.Now().Unix()); err != nil {
 		return fmt.Errorf("publish applause in backend: %w", err)
 	}
-	return nil
-}
-
-func isInMeeting(ctx context.Context, fetch *dsfetch.Fetch, userID, meetingID int) (bool, error) {
-	superadmin, err := isSuperadmin(ctx, fetch, userID)
-	if err != nil {
-		return false, fmt.Errorf("checking for superadmin: %w", err)
-	}
 
-	if superadmin {
-		return true, nil
-	}
-
-	meetingUserIDs, err := fetch.User_MeetingUserIDs(userID).Value(ctx)
-	if err != nil {
-		return false, fmt.Errorf("getting meeting user ids: %w", err)
-	}
-
-	meetingIDs := make([]int, len(meetingUserIDs))
-	for i := 0; i < len(meetingUserIDs); i++ {
-		fetch.MeetingUser_MeetingID(meetingUserIDs[i]).Lazy(&meetingIDs[i])
-	}
-
-	if err := fetch.Execute(ctx); err != nil {
-		return false, fmt.Errorf("getting meeting IDs from user %d: %w", userID, err)
-	}
-
-	for _, mid := range meetingIDs {
-		if mid == meetingID {
-			return true, nil
-		}
-	}
-
-	return false, nil
+	iccmetric.Published(iccmetric.KindApplause, started)
+	return nil
 }
 
 // CanReceive returns an error, if the user can not receive applause.
@@ -143,7 +155,7 @@ func (a *Applause) CanReceive(ctx context.Context, meetingID, userID int) error
 		return nil
 	}
 
-	inMeeting, err := isInMeeting(ctx, fetcher, userID, meetingID)
+	inMeeting, err := permission.InMeeting(ctx, fetcher, userID, meetingID)
 	if err != nil {
 		return fmt.Errorf("checking if user is in meeting: %w", err)
 	}
@@ -191,8 +203,22 @@ func (a *Applause) LastID() uint64 {
 	return a.topic.LastID()
 }
 
+// TopicSize returns the number of messages in the topic.
+func (a *Applause) TopicSize() int {
+	a.publishMu.Lock()
+	defer a.publishMu.Unlock()
+
+	return len(a.published)
+}
+
+// Status returns the state of the background loop, that fetches the applause
+// from the backend.
+func (a *Applause) Status() *iccstatus.Loop {
+	return &a.status
+}
+
 // loop fetches the applause from the backend and saves it for the clients to
-// fetch.
+// fetch. It also removes old messages from the topic.
 func (a *Applause) loop(ctx context.Context, errHandler func(error)) {
 	if errHandler == nil {
 		errHandler = func(error) {}
@@ -200,17 +226,32 @@ func (a *Applause) loop(ctx context.Context, errHandler func(error)) {
 
 	lastApplause := make(map[int]int)
 
+	backoff := iccstatus.Backoff{Min: 2 * applauseInterval, Max: applauseBackoffMax}
+	wait := applauseInterval
+	lastPrune := a.clock.Now()
 	for {
-		if err := contextSleep(ctx, applauseInterval); err != nil {
+		if err := iccclock.Sleep(ctx, a.clock, wait); err != nil {
 			return
 		}
 
-		d := time.Now().Add(-countTime)
+		if now := a.clock.Now(); now.Sub(lastPrune) >= pruneInterval {
+			a.pruneOldData(now.Add(-pruneTime))
+			lastPrune = now
+		}
+
+		d := a.clock.Now().Add(-countTime)
 		applause, err := a.backend.ApplauseSince(d.Unix())
 		if err != nil {
-			errHandler(fmt.Errorf("fetching applause: %w", err))
+			err = fmt.Errorf("fetching applause: %w", err)
+			a.status.Failure(err)
+			iccmetric.BackendError(iccmetric.KindApplause)
+			errHandler(err)
+			wait = backoff.Next()
 			continue
 		}
+		a.status.Success()
+		backoff.Reset()
+		wait = applauseInterval
 
 		// Set values that are in lastApplause but not in applause to 0.
 		for k := range lastApplause {
@@ -225,6 +266,7 @@ func (a *Applause) loop(ctx context.Context, errHandler func(error)) {
 				continue
 			}
 			lastApplause[meetingID] = level
+			iccmetric.ApplauseLevel(meetingID, level)
 
 			msg, err := a.toMSG(ctx, meetingID, level)
 			if err != nil {
@@ -244,10 +286,21 @@ func (a *Applause) loop(ctx context.Context, errHandler func(error)) {
 			errHandler(fmt.Errorf("encoding message: %w", err))
 			continue
 		}
-		a.topic.Publish(string(b))
+		a.publish(string(b))
 	}
 }
 
+// publish adds a message to the topic and saves its time.
+func (a *Applause) publish(message string) {
+	a.publishMu.Lock()
+	defer a.publishMu.Unlock()
+
+	// The real time is taken before the topic saves its own time, so it is
+	// not after the time of the message.
+	a.published = append(a.published, publishTime{clock: a.clock.Now(), real: time.Now()})
+	a.topic.Publish(message)
+}
+
 // toMSG converts a int (applause level) to a MSG object.
 func (a *Applause) toMSG(ctx context.Context, meetingID, level int) (MSG, error) {
 	presentUser, err := a.presentUser(ctx, meetingID)
@@ -261,19 +314,22 @@ func (a *Applause) toMSG(ctx context.Context, meetingID, level int) (MSG, error)
 	}, nil
 }
 
-// pruneOldData removes applause data.
-func (a *Applause) pruneOldData(ctx context.Context) {
-	tick := time.NewTicker(5 * time.Minute)
-	defer tick.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-tick.C:
-			a.topic.Prune(time.Now().Add(-pruneTime))
+// pruneOldData removes the messages, that were published before the given
+// time of the clock. Like topic.Prune, the last message is always kept.
+func (a *Applause) pruneOldData(until time.Time) {
+	a.publishMu.Lock()
+	defer a.publishMu.Unlock()
+
+	keep := len(a.published) - 1
+	for i, p := range a.published {
+		if !p.clock.Before(until) {
+			keep = i
+			break
 		}
 	}
+
+	a.topic.Prune(a.published[keep].real)
+	a.published = a.published[keep:]
 }
 
 // presentUser returns the number of users in this meeting.
@@ -288,33 +344,3 @@ func (a *Applause) presentUser(ctx context.Context, meetingID int) (int, error)
 	}
 	return len(ids), nil
 }
-
-// contextSleep is like time.Sleep but also takes a context.
-//
-// It returns either when the time is up.
-//
-// Returns ctx.Err() if the context was canceled.
-func contextSleep(ctx context.Context, d time.Duration) error {
-	timer := time.NewTimer(d)
-	defer timer.Stop()
-
-	select {
-	case <-timer.C:
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
-	}
-}
-
-func isSuperadmin(ctx context.Context, ds *dsfetch.Fetch, userID int) (bool, error) {
-	if userID == 0 {
-		return false, nil
-	}
-
-	oml, err := ds.User_OrganizationManagementLevel(userID).Value(ctx)
-	if err != nil {
-		return false, fmt.Errorf("getting oml of user %d: %w", userID, err)
-	}
-
-	return oml == "superadmin", nil
-}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/peb-adr/openslides-go/datastore/dsmock"
 	"github.com/OpenSlides/openslides-icc-service/internal/applause"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccclock"
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/memory"
+	"github.com/ostcar/topic"
 )
 
 func TestApplauseCanReceiveInMeeting(t *testing.T) {
@@ -106,3 +110,212 @@ func TestApplauseCanReceiveInMeeting(t *testing.T) {
 		}
 	})
 }
+
+func TestApplauseLoop(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Level in the window", func(t *testing.T) {
+		app, clock := startApplause(t)
+		var tid uint64
+
+		if err := app.Send(ctx, 1, 5); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+
+		tick(clock, time.Second)
+		expectLevel(t, app, &tid, 1, 1)
+
+		if err := app.Send(ctx, 1, 6); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+
+		tick(clock, time.Second)
+		expectLevel(t, app, &tid, 1, 2)
+
+		// The first applause is older then five seconds.
+		tick(clock, 4*time.Second)
+		expectLevel(t, app, &tid, 1, 1)
+	})
+
+	t.Run("No message without a change", func(t *testing.T) {
+		app, clock := startApplause(t)
+		var tid uint64
+
+		if err := app.Send(ctx, 1, 5); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+
+		tick(clock, time.Second)
+		expectLevel(t, app, &tid, 1, 1)
+
+		tick(clock, time.Second)
+		expectNoMessage(t, app, tid, 1)
+	})
+
+	t.Run("Level goes back to zero", func(t *testing.T) {
+		app, clock := startApplause(t)
+		var tid uint64
+
+		if err := app.Send(ctx, 1, 5); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+
+		tick(clock, time.Second)
+		expectLevel(t, app, &tid, 1, 1)
+
+		tick(clock, 5*time.Second)
+		expectLevel(t, app, &tid, 1, 0)
+
+		tick(clock, time.Second)
+		expectNoMessage(t, app, tid, 1)
+	})
+
+	t.Run("Meetings are independent", func(t *testing.T) {
+		app, clock := startApplause(t)
+		var tid1, tid2 uint64
+
+		if err := app.Send(ctx, 1, 5); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+
+		tick(clock, time.Second)
+		expectLevel(t, app, &tid1, 1, 1)
+		expectNoMessage(t, app, tid2, 2)
+
+		if err := app.Send(ctx, 2, 5); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+
+		tick(clock, time.Second)
+		expectLevel(t, app, &tid2, 2, 1)
+		expectNoMessage(t, app, tid1, 1)
+	})
+
+	t.Run("Prune old messages", func(t *testing.T) {
+		app, clock := startApplause(t)
+		var tid uint64
+
+		if err := app.Send(ctx, 1, 5); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+
+		tick(clock, time.Second)
+		expectLevel(t, app, &tid, 1, 1)
+		oldTID := tid
+
+		// The first prune runs after five minutes. It does not remove
+		// anything, because no message is older then ten minutes.
+		tick(clock, 6*time.Minute)
+		expectLevel(t, app, &tid, 1, 0)
+		newTID := tid
+
+		if got := app.TopicSize(); got != 3 {
+			t.Errorf("topic has %d messages, expected 3", got)
+		}
+
+		// The second prune removes the messages, that are older then ten
+		// minutes, but keeps the newer one.
+		tick(clock, 5*time.Minute)
+
+		if _, _, err := app.Receive(ctx, oldTID, 1); !errors.As(err, new(topic.UnknownIDError)) {
+			t.Errorf("Receive with an old id returned `%v`, expected an unknown id error", err)
+		}
+
+		if got := app.TopicSize(); got != 1 {
+			t.Errorf("topic has %d messages after prune, expected 1", got)
+		}
+
+		if err := app.Send(ctx, 1, 5); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+
+		tick(clock, time.Second)
+		expectLevel(t, app, &newTID, 1, 1)
+	})
+}
+
+// startApplause starts the applause service with a fake clock and waits until
+// its background tasks are sleeping.
+func startApplause(t *testing.T) (*applause.Applause, *iccclock.Fake) {
+	t.Helper()
+
+	ds := dsmock.Stub(dsmock.YAMLData(`---
+	meeting/1:
+		applause_enable: true
+		present_user_ids: [5, 6]
+	meeting/2:
+		applause_enable: true
+		present_user_ids: [5]
+
+	user/5/meeting_user_ids: [50, 51]
+	user/6/meeting_user_ids: [60]
+	meeting_user/50:
+		meeting_id: 1
+		user_id: 5
+	meeting_user/51:
+		meeting_id: 2
+		user_id: 5
+	meeting_user/60:
+		meeting_id: 1
+		user_id: 6
+	`))
+
+	// The clock does not depend on the real time.
+	clock := iccclock.NewFake(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC))
+	app, background := applause.New(memory.New(), ds)
+	app.SetClock(clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	background(ctx, nil)
+
+	// The loop sleeps with a timer.
+	clock.BlockUntil(1)
+	return app, clock
+}
+
+// tick advances the clock and waits until the background loop is sleeping
+// again.
+func tick(clock *iccclock.Fake, d time.Duration) {
+	clock.Advance(d)
+	clock.BlockUntil(1)
+}
+
+// expectLevel checks, that the last message for the meeting after tid has the
+// given level. tid is set to the id of the message.
+func expectLevel(t *testing.T, app *applause.Applause, tid *uint64, meetingID int, level int) {
+	t.Helper()
+
+	if *tid == 0 {
+		*tid = 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	newTID, msg, err := app.Receive(ctx, *tid, meetingID)
+	if err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	*tid = newTID
+
+	if msg.Level != level {
+		t.Errorf("got level %d for meeting %d, expected %d", msg.Level, meetingID, level)
+	}
+}
+
+// expectNoMessage checks, that there is no message for the meeting after tid.
+func expectNoMessage(t *testing.T, app *applause.Applause, tid uint64, meetingID int) {
+	t.Helper()
+
+	if tid == 0 {
+		tid = 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, msg, err := app.Receive(ctx, tid, meetingID); err == nil {
+		t.Errorf("got message %v for meeting %d, expected none", msg, meetingID)
+	}
+}
@@ -1,22 +1,37 @@
 package applause
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/peb-adr/openslides-go/datastore"
 	"github.com/peb-adr/openslides-go/datastore/cache"
+	"github.com/peb-adr/openslides-go/datastore/dskey"
 	"github.com/peb-adr/openslides-go/datastore/flow"
 	"github.com/peb-adr/openslides-go/environment"
 )
 
+// pingKey is a key, that is fetched to check the connection to postgres.
+var pingKey = dskey.MustKey("organization/1/id")
+
 // Flow initializes a cached connection to postgres.
-func Flow(lookup environment.Environmenter, messageBus flow.Updater) (flow.Flow, error) {
+//
+// The returned ping function checks the connection to postgres. It does not
+// use the cache.
+func Flow(lookup environment.Environmenter, messageBus flow.Updater) (flow.Flow, func(context.Context) error, error) {
 	postgres, err := datastore.NewFlowPostgres(lookup, messageBus)
 	if err != nil {
-		return nil, fmt.Errorf("init postgres: %w", err)
+		return nil, nil, fmt.Errorf("init postgres: %w", err)
 	}
 
 	cache := cache.New(postgres)
 
-	return cache, nil
+	ping := func(ctx context.Context) error {
+		if _, err := postgres.Get(ctx, pingKey); err != nil {
+			return fmt.Errorf("fetching %s: %w", pingKey, err)
+		}
+		return nil
+	}
+
+	return cache, ping, nil
 }
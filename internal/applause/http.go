@@ -3,14 +3,25 @@ package applause
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/OpenSlides/openslides-icc-service/internal/connection"
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
 	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+	"github.com/OpenSlides/openslides-icc-service/internal/icclog"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccmetric"
+	"github.com/ostcar/topic"
 )
 
+// pollTimeout is the maximum time a poll request blocks, if there is no new
+// applause.
+const pollTimeout = 30 * time.Second
+
 // Sender saves the applause.
 type Sender interface {
 	Send(ctx context.Context, meetingID, uid int) error
@@ -24,8 +35,7 @@ func HandleSend(mux *http.ServeMux, applause Sender, auth icchttp.Authenticater)
 
 		uid := auth.FromContext(r.Context())
 		if uid == 0 {
-			w.WriteHeader(401)
-			icchttp.ErrorNoStatus(w, iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous user can not send applause."))
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrUnauthorized, "Anonymous user can not send applause."))
 			return
 		}
 
@@ -55,7 +65,10 @@ type Receive interface {
 }
 
 // HandleReceive registers the icc/applause route.
-func HandleReceive(mux *http.ServeMux, applause Receive, auth icchttp.Authenticater) {
+//
+// Each stream is registered in the given registry. If the registry closes the
+// stream, the client gets the reason as last message.
+func HandleReceive(mux *http.ServeMux, applause Receive, auth icchttp.Authenticater, streams *connection.Registry) {
 	url := icchttp.Path + "/applause"
 	handler := http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
@@ -69,27 +82,149 @@ func HandleReceive(mux *http.ServeMux, applause Receive, auth icchttp.Authentica
 				return
 			}
 
-			if err := applause.CanReceive(r.Context(), meetingID, auth.FromContext(r.Context())); err != nil {
+			uid := auth.FromContext(r.Context())
+			if err := applause.CanReceive(r.Context(), meetingID, uid); err != nil {
 				icchttp.Error(w, err)
 				return
 			}
 
+			ctx, done, err := streams.Open(r.Context(), connection.Info{
+				Kind:      connection.KindApplause,
+				UserID:    uid,
+				MeetingID: meetingID,
+			})
+			if err != nil {
+				icchttp.Error(w, fmt.Errorf("opening stream: %w", err))
+				return
+			}
+			defer done()
+
+			icclog.AddAttrs(r.Context(), "meeting_id", meetingID)
+			icclog.Debug(r.Context(), "Applause stream opened")
+			defer icclog.Debug(r.Context(), "Applause stream closed")
+
+			// Events like a degraded backend are written between the messages.
+			var writeMu sync.Mutex
+			stopEvents := connection.WriteEvents(ctx, &writeMu, func(event icchttp.StreamEvent) error {
+				return icchttp.WriteWithTimeout(w, streams.WriteTimeout(), func() error {
+					return icchttp.WriteEvent(w, event)
+				})
+			})
+			defer stopEvents()
+
 			encoder := json.NewEncoder(w)
 			var tid uint64
 			for {
 				var message MSG
-				tid, message, err = applause.Receive(r.Context(), tid, meetingID)
+				tid, message, err = applause.Receive(ctx, tid, meetingID)
 				if err != nil {
-					icchttp.ErrorNoStatus(w, fmt.Errorf("receive applause data: %w", err))
+					stopEvents()
+					icchttp.StreamClose(ctx, w, fmt.Errorf("receive applause data: %w", err))
 					return
 				}
 
-				if err := encoder.Encode(message); err != nil {
+				writeMu.Lock()
+				err = icchttp.WriteWithTimeout(w, streams.WriteTimeout(), func() error {
+					if err := encoder.Encode(message); err != nil {
+						return err
+					}
+					w.(http.Flusher).Flush()
+					return nil
+				})
+				writeMu.Unlock()
+				if err != nil {
+					if icchttp.IsWriteTimeout(err) {
+						iccmetric.SlowConsumer(iccmetric.KindApplause, iccmetric.SlowConsumerWriteTimeout)
+						icclog.Info(r.Context(), "Closed slow applause stream", "error", err)
+						return
+					}
 					icchttp.ErrorNoStatus(w, fmt.Errorf("writing message: %w", err))
 					return
 				}
-				w.(http.Flusher).Flush()
+				iccmetric.Delivered(iccmetric.KindApplause, 1)
+			}
+		})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
+
+// HandlePoll registers the icc/applause/poll route.
+//
+// It is a long polling fallback for the applause route. A request without a
+// cursor returns the current state and a cursor. Each following request has
+// to send the cursor and blocks until there is new applause or the poll
+// timeout is reached or the registry is drained.
+func HandlePoll(mux *http.ServeMux, applause Receive, auth icchttp.Authenticater, streams *connection.Registry) {
+	url := icchttp.Path + "/applause/poll"
+	handler := http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store, max-age=0")
+
+			query := r.URL.Query()
+
+			meetingID, err := strconv.Atoi(query.Get("meeting_id"))
+			if err != nil {
+				icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query meeting has to be an int."))
+				return
+			}
+
+			var cursor uint64
+			if cursorStr := query.Get("cursor"); cursorStr != "" {
+				cursor, err = strconv.ParseUint(cursorStr, 10, 64)
+				if err != nil {
+					icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "Query cursor has to be an unsigned int."))
+					return
+				}
+			}
+
+			if err := applause.CanReceive(r.Context(), meetingID, auth.FromContext(r.Context())); err != nil {
+				icchttp.Error(w, err)
+				return
+			}
+
+			ctx, cancelDrain := streams.WithDrain(r.Context())
+			defer cancelDrain()
+
+			ctx, cancel := context.WithTimeout(ctx, pollTimeout)
+			defer cancel()
+
+			response := struct {
+				Cursor   uint64 `json:"cursor"`
+				Messages []MSG  `json:"messages"`
+			}{
+				Cursor:   cursor,
+				Messages: []MSG{},
+			}
+
+			newCursor, message, err := applause.Receive(ctx, cursor, meetingID)
+			var errUnknownID topic.UnknownIDError
+			if errors.As(err, &errUnknownID) {
+				// The cursor is to old. Start again with the current state.
+				newCursor, message, err = applause.Receive(ctx, 0, meetingID)
+			}
+
+			switch {
+			case err == nil:
+				response.Cursor = newCursor
+				response.Messages = append(response.Messages, message)
+
+			case r.Context().Err() == nil && (errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)):
+				// Timeout or drain without new applause.
+
+			default:
+				icchttp.Error(w, fmt.Errorf("receive applause data: %w", err))
+				return
+			}
+
+			if err := json.NewEncoder(w).Encode(response); err != nil {
+				icchttp.ErrorNoStatus(w, fmt.Errorf("writing message: %w", err))
+				return
 			}
+			iccmetric.Delivered(iccmetric.KindApplause, len(response.Messages))
 		})
 
 	mux.Handle(
@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/OpenSlides/openslides-icc-service/internal/applause"
+	"github.com/OpenSlides/openslides-icc-service/internal/connection"
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
 	"github.com/OpenSlides/openslides-icc-service/internal/icctest"
 )
@@ -28,8 +29,8 @@ func TestHandleSend(t *testing.T) {
 			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
 		}
 
-		if !strings.Contains(resp.Body.String(), iccerror.ErrNotAllowed.Type()) {
-			t.Errorf("handler returned message `%s`, expected to contain `%s`", resp.Body.String(), iccerror.ErrNotAllowed.Type())
+		if !strings.Contains(resp.Body.String(), iccerror.ErrUnauthorized.Type()) {
+			t.Errorf("handler returned message `%s`, expected to contain `%s`", resp.Body.String(), iccerror.ErrUnauthorized.Type())
 		}
 
 		if applauser.called {
@@ -84,3 +85,47 @@ func TestHandleSend(t *testing.T) {
 		}
 	})
 }
+
+func TestHandlePoll(t *testing.T) {
+	url := "/system/icc/applause/poll"
+
+	t.Run("Invalid meeting", func(t *testing.T) {
+		auther := icctest.AutherStub{UserID: 1}
+		receiver := receiverStub{}
+		mux := http.NewServeMux()
+		applause.HandlePoll(mux, &receiver, &auther, new(connection.Registry))
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url+"?meeting_id=abc", nil))
+
+		if resp.Result().StatusCode != 400 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+	})
+
+	t.Run("Poll with cursor", func(t *testing.T) {
+		auther := icctest.AutherStub{UserID: 1}
+		receiver := receiverStub{
+			tid: 6,
+			msg: applause.MSG{Level: 3, PresentUsers: 10},
+		}
+		mux := http.NewServeMux()
+		applause.HandlePoll(mux, &receiver, &auther, new(connection.Registry))
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url+"?meeting_id=1&cursor=5", nil))
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if receiver.calledTID != 5 {
+			t.Errorf("receiver was called with tid %d, expected 5", receiver.calledTID)
+		}
+
+		expect := `{"cursor":6,"messages":[{"level":3,"present_users":10}]}` + "\n"
+		if resp.Body.String() != expect {
+			t.Errorf("resp body is %q, expected %q", resp.Body.String(), expect)
+		}
+	})
+}
@@ -1,6 +1,10 @@
 package applause_test
 
-import "context"
+import (
+	"context"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/applause"
+)
 
 type applauserStub struct {
 	expectedErr     error
@@ -29,3 +33,20 @@ func (b *backendStub) ApplausePublish(meetingID, userID int, time int64) error {
 func (b backendStub) ApplauseSince(time int64) (map[int]int, error) {
 	return b.ExpectSince, nil
 }
+
+type receiverStub struct {
+	tid uint64
+	msg applause.MSG
+	err error
+
+	calledTID uint64
+}
+
+func (r *receiverStub) Receive(ctx context.Context, tid uint64, meetingID int) (uint64, applause.MSG, error) {
+	r.calledTID = tid
+	return r.tid, r.msg, r.err
+}
+
+func (r *receiverStub) CanReceive(ctx context.Context, meetingID, userID int) error {
+	return nil
+}
@@ -0,0 +1,355 @@
+// Package connection keeps track of the open notify and applause streams of
+// this instance.
+package connection
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccmetric"
+)
+
+// Kinds of streams.
+const (
+	KindNotify   = iccmetric.KindNotify
+	KindApplause = iccmetric.KindApplause
+)
+
+// Info describes an open stream.
+type Info struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	UserID    int       `json:"user_id"`
+	MeetingID int       `json:"meeting_id"`
+	ChannelID string    `json:"channel_id,omitempty"`
+	Started   time.Time `json:"started"`
+}
+
+// stream is an open stream.
+type stream struct {
+	info   Info
+	cancel context.CancelCauseFunc
+	events chan icchttp.StreamEvent
+}
+
+// eventBuffer is the number of broadcast events, that a stream can fall
+// behind. More events are dropped for the stream.
+const eventBuffer = 4
+
+type eventsKey struct{}
+
+// Limiter decides, if a new stream can be opened.
+type Limiter interface {
+	// Acquire is called before a stream is opened. If it returns an error,
+	// the stream is rejected. Otherwise, release is called, when the stream
+	// is closed.
+	Acquire(ctx context.Context, info Info) (release func(), err error)
+}
+
+// Registry holds all open streams.
+//
+// The zero value is ready to use. A nil Registry does not track anything.
+type Registry struct {
+	mu       sync.Mutex
+	streams  map[*stream]struct{}
+	draining bool
+	drained  chan struct{}
+	limiter  Limiter
+
+	writeTimeout time.Duration
+	openEvent    func() (icchttp.StreamEvent, bool)
+}
+
+// SetLimiter sets a limiter, that is asked for each new stream.
+//
+// It has to be called before the first stream is opened.
+func (r *Registry) SetLimiter(l Limiter) {
+	r.limiter = l
+}
+
+// SetWriteTimeout sets the time a client has to read a message. If the
+// client is slower, the stream is closed.
+//
+// It has to be called before the first stream is opened.
+func (r *Registry) SetWriteTimeout(d time.Duration) {
+	r.writeTimeout = d
+}
+
+// SetOpenEvent sets a function, that is called for each new stream. If it
+// returns true, the event is sent to the stream, like a broadcast event, that
+// was sent before the stream was opened.
+//
+// It has to be called before the first stream is opened.
+func (r *Registry) SetOpenEvent(f func() (icchttp.StreamEvent, bool)) {
+	r.openEvent = f
+}
+
+// WriteTimeout returns the time a client has to read a message. 0 means no
+// timeout.
+func (r *Registry) WriteTimeout() time.Duration {
+	if r == nil {
+		return 0
+	}
+	return r.writeTimeout
+}
+
+// Open registers a stream.
+//
+// The returned context is canceled, when the server closes the stream. In
+// this case, context.Cause() returns the reason. The returned function has to
+// be called, when the stream is closed.
+//
+// If the registry is draining, the returned context is already canceled. If
+// the limiter rejects the stream, its error is returned.
+func (r *Registry) Open(ctx context.Context, info Info) (context.Context, func(), error) {
+	if r == nil {
+		return ctx, func() {}, nil
+	}
+
+	if info.ID == "" {
+		info.ID = newID()
+	}
+
+	if info.Started.IsZero() {
+		info.Started = time.Now()
+	}
+
+	release := func() {}
+	if r.limiter != nil {
+		var err error
+		release, err = r.limiter.Acquire(ctx, info)
+		if err != nil {
+			return nil, nil, fmt.Errorf("checking limit: %w", err)
+		}
+	}
+
+	events := make(chan icchttp.StreamEvent, eventBuffer)
+	ctx = context.WithValue(ctx, eventsKey{}, events)
+
+	if r.openEvent != nil {
+		if event, ok := r.openEvent(); ok {
+			events <- event
+		}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	s := &stream{info: info, cancel: cancel, events: events}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.draining {
+		release()
+		cancel(reconnectEvent(0))
+		return ctx, func() {}, nil
+	}
+
+	if r.streams == nil {
+		r.streams = make(map[*stream]struct{})
+	}
+	r.streams[s] = struct{}{}
+
+	metricClosed := iccmetric.StreamOpened(info.Kind, info.MeetingID)
+
+	var once sync.Once
+	done := func() {
+		once.Do(func() {
+			r.mu.Lock()
+			delete(r.streams, s)
+			r.mu.Unlock()
+
+			cancel(nil)
+			metricClosed()
+			release()
+		})
+	}
+
+	return ctx, done, nil
+}
+
+// WithDrain returns a context that is canceled, when the registry gets
+// drained.
+//
+// It is for requests that are not streams but can block for a long time.
+func (r *Registry) WithDrain(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r == nil {
+		return context.WithCancel(ctx)
+	}
+
+	r.mu.Lock()
+	if r.drained == nil {
+		r.drained = make(chan struct{})
+	}
+	drained := r.drained
+	r.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-drained:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Drain closes all open streams. Each stream gets a reconnect event with a
+// random delay up to maxDelay, so the clients do not reconnect at the same
+// time.
+//
+// After Drain was called, all new streams are closed immediately.
+func (r *Registry) Drain(maxDelay time.Duration) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.draining {
+		return
+	}
+	r.draining = true
+
+	if r.drained == nil {
+		r.drained = make(chan struct{})
+	}
+	close(r.drained)
+
+	for s := range r.streams {
+		var delay time.Duration
+		if maxDelay > 0 {
+			delay = time.Duration(rand.Int63n(int64(maxDelay)))
+		}
+		s.cancel(reconnectEvent(delay))
+	}
+}
+
+// List returns the open streams.
+func (r *Registry) List() []Info {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	infos := make([]Info, 0, len(r.streams))
+	for s := range r.streams {
+		infos = append(infos, s.info)
+	}
+	return infos
+}
+
+// Close closes all streams, where match returns true. The streams get cause as
+// reason.
+//
+// Returns the number of closed streams.
+func (r *Registry) Close(match func(Info) bool, cause error) int {
+	if r == nil {
+		return 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var count int
+	for s := range r.streams {
+		if match(s.info) {
+			s.cancel(cause)
+			count++
+		}
+	}
+	return count
+}
+
+// Broadcast sends an event to all open streams. Other then Close, the streams
+// stay open.
+//
+// The event is dropped for streams, that did not write the last events yet.
+func (r *Registry) Broadcast(event icchttp.StreamEvent) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for s := range r.streams {
+		select {
+		case s.events <- event:
+		default:
+		}
+	}
+}
+
+// WriteEvents writes the events, that are broadcast to the stream of ctx, in
+// the background. The context has to be returned from Registry.Open.
+//
+// The events are written while mu is locked, so the stream has to lock mu
+// for its own writes. If write returns an error, the following events are
+// not written.
+//
+// The returned function stops the writing and waits, until the last event is
+// written. It has to be called, before the stream is closed.
+func WriteEvents(ctx context.Context, mu *sync.Mutex, write func(icchttp.StreamEvent) error) (stop func()) {
+	events, _ := ctx.Value(eventsKey{}).(chan icchttp.StreamEvent)
+
+	ctx, cancel := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				mu.Lock()
+				err := write(event)
+				mu.Unlock()
+
+				if err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-stopped
+	}
+}
+
+// Count returns the number of open streams.
+func (r *Registry) Count() int {
+	if r == nil {
+		return 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.streams)
+}
+
+// newID returns a random id for a stream.
+func newID() string {
+	b := make([]byte, 8)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func reconnectEvent(delay time.Duration) icchttp.StreamEvent {
+	return icchttp.StreamEvent{
+		Event:   icchttp.EventReconnect,
+		DelayMS: delay.Milliseconds(),
+	}
+}
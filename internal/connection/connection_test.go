@@ -0,0 +1,203 @@
+package connection_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/connection"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Run("Open and close", func(t *testing.T) {
+		var r connection.Registry
+
+		ctx, done, _ := r.Open(context.Background(), connection.Info{Kind: connection.KindNotify, UserID: 1})
+
+		if got := r.Count(); got != 1 {
+			t.Errorf("Count() = %d, expected 1", got)
+		}
+
+		done()
+
+		if got := r.Count(); got != 0 {
+			t.Errorf("Count() after done = %d, expected 0", got)
+		}
+
+		if ctx.Err() == nil {
+			t.Errorf("context is not canceled after done")
+		}
+	})
+
+	t.Run("Drain", func(t *testing.T) {
+		var r connection.Registry
+
+		ctx, done, _ := r.Open(context.Background(), connection.Info{Kind: connection.KindNotify, UserID: 1})
+		defer done()
+
+		r.Drain(time.Second)
+
+		if ctx.Err() == nil {
+			t.Fatalf("context is not canceled after drain")
+		}
+
+		var event icchttp.StreamEvent
+		if !errors.As(context.Cause(ctx), &event) {
+			t.Fatalf("cause is %v, expected a stream event", context.Cause(ctx))
+		}
+
+		if event.Event != icchttp.EventReconnect {
+			t.Errorf("got event %s, expected %s", event.Event, icchttp.EventReconnect)
+		}
+
+		if event.DelayMS < 0 || event.DelayMS >= 1000 {
+			t.Errorf("got delay %dms, expected between 0 and 1000", event.DelayMS)
+		}
+	})
+
+	t.Run("Open after drain", func(t *testing.T) {
+		var r connection.Registry
+		r.Drain(0)
+
+		ctx, done, _ := r.Open(context.Background(), connection.Info{Kind: connection.KindApplause, UserID: 1})
+		defer done()
+
+		if ctx.Err() == nil {
+			t.Errorf("context is not canceled")
+		}
+
+		if got := r.Count(); got != 0 {
+			t.Errorf("Count() = %d, expected 0", got)
+		}
+	})
+
+	t.Run("WithDrain", func(t *testing.T) {
+		var r connection.Registry
+
+		ctx, cancel := r.WithDrain(context.Background())
+		defer cancel()
+
+		r.Drain(0)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Errorf("context is not canceled after drain")
+		}
+	})
+
+	t.Run("List and close", func(t *testing.T) {
+		var r connection.Registry
+
+		ctx1, done1, _ := r.Open(context.Background(), connection.Info{Kind: connection.KindNotify, UserID: 1, ChannelID: "a"})
+		defer done1()
+		ctx2, done2, _ := r.Open(context.Background(), connection.Info{Kind: connection.KindApplause, UserID: 2})
+		defer done2()
+
+		if got := len(r.List()); got != 2 {
+			t.Fatalf("List() returned %d streams, expected 2", got)
+		}
+
+		myErr := errors.New("my error")
+		closed := r.Close(func(info connection.Info) bool { return info.UserID == 1 }, myErr)
+
+		if closed != 1 {
+			t.Errorf("Close() = %d, expected 1", closed)
+		}
+
+		if !errors.Is(context.Cause(ctx1), myErr) {
+			t.Errorf("cause of closed stream is %v, expected %v", context.Cause(ctx1), myErr)
+		}
+
+		if ctx2.Err() != nil {
+			t.Errorf("other stream was closed")
+		}
+	})
+
+	t.Run("Broadcast", func(t *testing.T) {
+		var r connection.Registry
+
+		ctx, done, _ := r.Open(context.Background(), connection.Info{Kind: connection.KindNotify, UserID: 1})
+		defer done()
+
+		var mu sync.Mutex
+		written := make(chan icchttp.StreamEvent, 1)
+		stop := connection.WriteEvents(ctx, &mu, func(event icchttp.StreamEvent) error {
+			written <- event
+			return nil
+		})
+		defer stop()
+
+		r.Broadcast(icchttp.StreamEvent{Event: icchttp.EventDegraded})
+
+		select {
+		case event := <-written:
+			if event.Event != icchttp.EventDegraded {
+				t.Errorf("got event %s, expected %s", event.Event, icchttp.EventDegraded)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event was not written")
+		}
+
+		if ctx.Err() != nil {
+			t.Errorf("stream was closed by broadcast")
+		}
+	})
+
+	t.Run("Open event", func(t *testing.T) {
+		var r connection.Registry
+		send := true
+		r.SetOpenEvent(func() (icchttp.StreamEvent, bool) {
+			return icchttp.StreamEvent{Event: icchttp.EventDegraded}, send
+		})
+
+		for _, tt := range []struct {
+			name   string
+			send   bool
+			expect bool
+		}{
+			{"Send", true, true},
+			{"Do not send", false, false},
+		} {
+			send = tt.send
+
+			ctx, done, _ := r.Open(context.Background(), connection.Info{Kind: connection.KindNotify, UserID: 1})
+
+			var mu sync.Mutex
+			written := make(chan icchttp.StreamEvent, 1)
+			stop := connection.WriteEvents(ctx, &mu, func(event icchttp.StreamEvent) error {
+				written <- event
+				return nil
+			})
+
+			select {
+			case event := <-written:
+				if !tt.expect {
+					t.Errorf("%s: got event %s, expected none", tt.name, event.Event)
+				}
+			case <-time.After(10 * time.Millisecond):
+				if tt.expect {
+					t.Errorf("%s: open event was not written", tt.name)
+				}
+			}
+
+			stop()
+			done()
+		}
+	})
+
+	t.Run("Nil registry", func(t *testing.T) {
+		var r *connection.Registry
+
+		ctx, done, _ := r.Open(context.Background(), connection.Info{})
+		done()
+		r.Drain(0)
+
+		if ctx.Err() != nil {
+			t.Errorf("nil registry canceled the context")
+		}
+	})
+}
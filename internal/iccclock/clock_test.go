@@ -0,0 +1,158 @@
+package iccclock_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccclock"
+)
+
+var start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestFake(t *testing.T) {
+	t.Run("Now", func(t *testing.T) {
+		clock := iccclock.NewFake(start)
+		clock.Advance(time.Minute)
+
+		if got := clock.Now(); !got.Equal(start.Add(time.Minute)) {
+			t.Errorf("Now() returned %s, expected %s", got, start.Add(time.Minute))
+		}
+	})
+
+	t.Run("Timer fires once", func(t *testing.T) {
+		clock := iccclock.NewFake(start)
+		timer := clock.NewTimer(time.Second)
+
+		clock.Advance(999 * time.Millisecond)
+		if fired(timer.C()) {
+			t.Fatalf("timer fired too early")
+		}
+
+		clock.Advance(time.Millisecond)
+		if !fired(timer.C()) {
+			t.Fatalf("timer did not fire")
+		}
+
+		clock.Advance(time.Hour)
+		if fired(timer.C()) {
+			t.Errorf("timer fired twice")
+		}
+
+		if timer.Stop() {
+			t.Errorf("Stop() returned true for a fired timer")
+		}
+	})
+
+	t.Run("Stopped timer", func(t *testing.T) {
+		clock := iccclock.NewFake(start)
+		timer := clock.NewTimer(time.Second)
+
+		if !timer.Stop() {
+			t.Errorf("Stop() returned false for an active timer")
+		}
+
+		clock.Advance(time.Second)
+		if fired(timer.C()) {
+			t.Errorf("stopped timer fired")
+		}
+
+		if n := clock.Waiters(); n != 0 {
+			t.Errorf("clock has %d waiters, expected 0", n)
+		}
+	})
+
+	t.Run("Ticker", func(t *testing.T) {
+		clock := iccclock.NewFake(start)
+		ticker := clock.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for i := range 3 {
+			clock.Advance(time.Second)
+			if !fired(ticker.C()) {
+				t.Fatalf("ticker did not fire on tick %d", i)
+			}
+		}
+
+		// Like time.Ticker, ticks are dropped, if nobody reads them.
+		clock.Advance(5 * time.Second)
+		if !fired(ticker.C()) {
+			t.Fatalf("ticker did not fire after a long advance")
+		}
+
+		if fired(ticker.C()) {
+			t.Errorf("ticker fired more then once after one advance")
+		}
+	})
+
+	t.Run("BlockUntil", func(t *testing.T) {
+		clock := iccclock.NewFake(start)
+
+		done := make(chan struct{})
+		go func() {
+			clock.BlockUntil(1)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			t.Fatalf("BlockUntil returned without a waiter")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		clock.NewTimer(time.Second)
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Errorf("BlockUntil did not return after a timer was created")
+		}
+	})
+}
+
+func TestSleep(t *testing.T) {
+	t.Run("Time is up", func(t *testing.T) {
+		clock := iccclock.NewFake(start)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- iccclock.Sleep(context.Background(), clock, time.Minute)
+		}()
+
+		clock.BlockUntil(1)
+		clock.Advance(time.Minute)
+
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Sleep returned error: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Sleep did not return")
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		clock := iccclock.NewFake(start)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := iccclock.Sleep(ctx, clock, time.Minute); !errors.Is(err, context.Canceled) {
+			t.Errorf("Sleep returned error `%v`, expected context.Canceled", err)
+		}
+
+		if n := clock.Waiters(); n != 0 {
+			t.Errorf("Sleep did not stop its timer, clock has %d waiters", n)
+		}
+	})
+}
+
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
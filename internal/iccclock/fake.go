@@ -0,0 +1,168 @@
+package iccclock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a clock for tests. Its time only moves with Advance.
+//
+// Has to be created with NewFake.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters map[*fakeWaiter]struct{}
+
+	// changed is closed and replaced, when a waiter is added or removed.
+	changed chan struct{}
+}
+
+// NewFake creates a fake clock, that starts at the given time.
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now:     now,
+		waiters: make(map[*fakeWaiter]struct{}),
+		changed: make(chan struct{}),
+	}
+}
+
+// Now returns the time of the fake clock.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// NewTimer creates a timer, that fires, when the clock is advanced by d.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.addWaiter(d, 0)
+}
+
+// NewTicker creates a ticker, that fires each time, the clock is advanced by
+// d.
+//
+// Like time.Ticker, it drops ticks, if the receiver is too slow.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.addWaiter(d, d)}
+}
+
+// Advance moves the clock forward and fires all timers and tickers, that are
+// due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+
+	removed := false
+	for w := range f.waiters {
+		if w.at.After(f.now) {
+			continue
+		}
+
+		select {
+		case w.c <- f.now:
+		default:
+		}
+
+		if w.period == 0 {
+			delete(f.waiters, w)
+			removed = true
+			continue
+		}
+
+		for !w.at.After(f.now) {
+			w.at = w.at.Add(w.period)
+		}
+	}
+
+	if removed {
+		f.notify()
+	}
+}
+
+// Waiters returns the number of active timers and tickers.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.waiters)
+}
+
+// BlockUntil blocks until there are at least n active timers and tickers.
+//
+// A test can use it to wait until a background loop is sleeping.
+func (f *Fake) BlockUntil(n int) {
+	for {
+		f.mu.Lock()
+		count := len(f.waiters)
+		changed := f.changed
+		f.mu.Unlock()
+
+		if count >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+func (f *Fake) addWaiter(d, period time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &fakeWaiter{
+		clock:  f,
+		at:     f.now.Add(d),
+		period: period,
+		c:      make(chan time.Time, 1),
+	}
+	f.waiters[w] = struct{}{}
+	f.notify()
+	return w
+}
+
+// notify wakes up BlockUntil. Has to be called with the lock.
+func (f *Fake) notify() {
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// fakeWaiter is a timer or ticker of the fake clock.
+type fakeWaiter struct {
+	clock  *Fake
+	at     time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+func (w *fakeWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+// Stop removes the waiter from the clock. Returns false, if the timer
+// already fired or was stopped.
+func (w *fakeWaiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+
+	if _, ok := w.clock.waiters[w]; !ok {
+		return false
+	}
+
+	delete(w.clock.waiters, w)
+	w.clock.notify()
+	return true
+}
+
+// fakeTicker is a fakeWaiter with the Stop method of a ticker.
+type fakeTicker struct {
+	*fakeWaiter
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeWaiter.Stop()
+}
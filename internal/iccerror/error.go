@@ -1,6 +1,9 @@
 package iccerror
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+)
 
 const (
 	// ErrInternal should not happen.
@@ -12,12 +15,36 @@ const (
 	// ErrNotAllowed happens on a vote request, when the request user is
 	// anonymous or is not allowed for the request.
 	ErrNotAllowed
+
+	// ErrLimit happens, when a user or a meeting has too many open streams.
+	ErrLimit
+
+	// ErrUnauthorized happens, when the request is not authenticated or the
+	// user is anonymous.
+	ErrUnauthorized
+
+	// ErrNotFound happens, when a requested object, like a poll channel, does
+	// not exist.
+	ErrNotFound
+
+	// ErrTooLarge happens, when the request body is too big.
+	ErrTooLarge
+
+	// ErrMethodNotAllowed happens, when a route is called with the wrong http
+	// method.
+	ErrMethodNotAllowed
+
+	// ErrUnavailable happens, when the service can not handle the request
+	// yet, for example before the instance is registered in the backend.
+	ErrUnavailable
 )
 
 // TypeError is an error that can happend in this API.
 type TypeError int
 
 // Type returns a name for the error.
+//
+// The names are stable. Clients can use them to handle the errors.
 func (err TypeError) Type() string {
 	switch err {
 	case ErrInvalid:
@@ -26,31 +53,101 @@ func (err TypeError) Type() string {
 	case ErrNotAllowed:
 		return "not-allowed"
 
+	case ErrLimit:
+		return "limit"
+
+	case ErrUnauthorized:
+		return "unauthorized"
+
+	case ErrNotFound:
+		return "not-found"
+
+	case ErrTooLarge:
+		return "too-large"
+
+	case ErrMethodNotAllowed:
+		return "method-not-allowed"
+
+	case ErrUnavailable:
+		return "unavailable"
+
 	default:
 		return "internal"
 	}
 }
 
-func (err TypeError) Error() string {
-	var msg string
+// Status returns the http status code for the error.
+func (err TypeError) Status() int {
 	switch err {
 	case ErrInvalid:
-		msg = "The input data is invalid."
+		return 400
+
+	case ErrUnauthorized:
+		return 401
 
 	case ErrNotAllowed:
-		msg = "You are not allowed to do this."
+		return 403
+
+	case ErrNotFound:
+		return 404
+
+	case ErrMethodNotAllowed:
+		return 405
+
+	case ErrTooLarge:
+		return 413
+
+	case ErrLimit:
+		return 429
+
+	case ErrUnavailable:
+		return 503
 
 	default:
-		msg = "Ups, something went wrong!"
+		return 500
+	}
+}
 
+func (err TypeError) message() string {
+	switch err {
+	case ErrInvalid:
+		return "The input data is invalid."
+
+	case ErrNotAllowed:
+		return "You are not allowed to do this."
+
+	case ErrLimit:
+		return "There are too many open connections."
+
+	case ErrUnauthorized:
+		return "You have to be logged in."
+
+	case ErrNotFound:
+		return "The requested object does not exist."
+
+	case ErrTooLarge:
+		return "The request is too large."
+
+	case ErrMethodNotAllowed:
+		return "The http method is not allowed."
+
+	case ErrUnavailable:
+		return "The service is not ready yet."
+
+	default:
+		return "Ups, something went wrong!"
 	}
-	return fmt.Sprintf(`{"error":"%s","msg":"%s"}`, err.Type(), msg)
+}
+
+func (err TypeError) Error() string {
+	return encode(err, err.message(), nil)
 }
 
 // MessageError is a TypeError with an individuel error message.
 type MessageError struct {
-	t   TypeError
-	msg string
+	t       TypeError
+	msg     string
+	details map[string]any
 }
 
 // NewMessageError creates an error of a specific type with a different message.
@@ -58,15 +155,53 @@ type MessageError struct {
 // This are messages, that should be send to the client.
 func NewMessageError(t TypeError, format string, a ...interface{}) error {
 	return MessageError{
-		t,
-		fmt.Sprintf(format, a...),
+		t:   t,
+		msg: fmt.Sprintf(format, a...),
+	}
+}
+
+// NewDetailsError is like NewMessageError but with additional details for the
+// client. The details have to be encodable to json.
+func NewDetailsError(t TypeError, details map[string]any, format string, a ...interface{}) error {
+	return MessageError{
+		t:       t,
+		msg:     fmt.Sprintf(format, a...),
+		details: details,
 	}
 }
 
 func (err MessageError) Error() string {
-	return fmt.Sprintf(`{"error":"%s","msg":"%s"}`, err.t.Type(), err.msg)
+	return encode(err.t, err.msg, err.details)
+}
+
+// Type returns the name of the error type.
+func (err MessageError) Type() string {
+	return err.t.Type()
+}
+
+// Status returns the http status code of the error type.
+func (err MessageError) Status() int {
+	return err.t.Status()
 }
 
 func (err MessageError) Unwrap() error {
 	return err.t
 }
+
+// encode returns the json representation of an error.
+func encode(t TypeError, msg string, details map[string]any) string {
+	b, err := json.Marshal(struct {
+		Error   string         `json:"error"`
+		Msg     string         `json:"msg"`
+		Details map[string]any `json:"details,omitempty"`
+	}{
+		t.Type(),
+		msg,
+		details,
+	})
+	if err != nil {
+		// Can only happen with invalid details.
+		return encode(t, msg, nil)
+	}
+	return string(b)
+}
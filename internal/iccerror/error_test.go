@@ -0,0 +1,81 @@
+package iccerror_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+)
+
+func TestMessageError(t *testing.T) {
+	t.Run("Message with quotes", func(t *testing.T) {
+		err := iccerror.NewMessageError(iccerror.ErrInvalid, `invalid channel id "%s"`, `a"b`)
+
+		var got map[string]any
+		if err := json.Unmarshal([]byte(err.Error()), &got); err != nil {
+			t.Fatalf("Error() is not valid json: %v: %s", err, err.Error())
+		}
+
+		if got["error"] != "invalid" {
+			t.Errorf("got error type %v, expected invalid", got["error"])
+		}
+
+		if got["msg"] != `invalid channel id "a"b"` {
+			t.Errorf("got msg %v, expected `invalid channel id \"a\"b\"`", got["msg"])
+		}
+
+		if _, ok := got["details"]; ok {
+			t.Errorf("got details without setting them: %s", err.Error())
+		}
+	})
+
+	t.Run("Details", func(t *testing.T) {
+		err := iccerror.NewDetailsError(iccerror.ErrTooLarge, map[string]any{"limit": 10}, "too large")
+
+		expect := `{"error":"too-large","msg":"too large","details":{"limit":10}}`
+		if got := err.Error(); got != expect {
+			t.Errorf("got `%s`, expected `%s`", got, expect)
+		}
+	})
+
+	t.Run("Unwrap", func(t *testing.T) {
+		err := iccerror.NewMessageError(iccerror.ErrLimit, "too many")
+
+		if !errors.Is(err, iccerror.ErrLimit) {
+			t.Errorf("errors.Is(err, ErrLimit) returned false")
+		}
+	})
+}
+
+func TestStatus(t *testing.T) {
+	for _, tt := range []struct {
+		err    iccerror.TypeError
+		name   string
+		status int
+	}{
+		{iccerror.ErrInternal, "internal", 500},
+		{iccerror.ErrInvalid, "invalid", 400},
+		{iccerror.ErrUnauthorized, "unauthorized", 401},
+		{iccerror.ErrNotAllowed, "not-allowed", 403},
+		{iccerror.ErrNotFound, "not-found", 404},
+		{iccerror.ErrMethodNotAllowed, "method-not-allowed", 405},
+		{iccerror.ErrTooLarge, "too-large", 413},
+		{iccerror.ErrUnavailable, "unavailable", 503},
+		{iccerror.ErrLimit, "limit", 429},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Type(); got != tt.name {
+				t.Errorf("Type() returned %s, expected %s", got, tt.name)
+			}
+
+			if got := tt.err.Status(); got != tt.status {
+				t.Errorf("Status() returned %d, expected %d", got, tt.status)
+			}
+
+			if !json.Valid([]byte(tt.err.Error())) {
+				t.Errorf("Error() is not valid json: %s", tt.err.Error())
+			}
+		})
+	}
+}
@@ -5,71 +5,202 @@ package icchttp
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
 	"github.com/OpenSlides/openslides-icc-service/internal/icclog"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccstatus"
 )
 
 const (
 	// Path is the basic path for all handlers of this service.
 	Path = "/system/icc"
+
+	// requestIDHeader is the header that contains the id of a request.
+	requestIDHeader = "X-Request-ID"
 )
 
+// errLoggedOut is the reason, why a request is canceled, when the session of
+// the user is revoked.
+var errLoggedOut = iccerror.NewMessageError(iccerror.ErrUnauthorized, "The session was logged out.")
+
 // Authenticater knowns how to authenticate a request.
 type Authenticater interface {
 	Authenticate(http.ResponseWriter, *http.Request) (context.Context, error)
 	FromContext(context.Context) int
 }
 
+// typedError is an error, that can be sent to the client.
+type typedError interface {
+	error
+	Type() string
+}
+
 // ErrorNoStatus is like Error(), but does not write a status message.
+//
+// It is also used to send an error as last message of a stream.
 func ErrorNoStatus(w io.Writer, err error) {
 	if isConnectionClose(err) {
 		return
 	}
 
-	msg := err.Error()
-
-	var errTyped interface {
-		error
-		Type() string
-	}
+	var errTyped typedError
 	if !errors.As(err, &errTyped) {
 		// Unknown error. Handle as 500er.
-		msg = iccerror.ErrInternal.Error()
-		icclog.Info("Error: %v", err)
+		icclog.Error(writerContext(w), "Internal error", "error", err)
+		errTyped = iccerror.ErrInternal
 	}
 
-	fmt.Fprint(w, msg)
+	fmt.Fprint(w, errTyped.Error())
 }
 
 // Error sends an error message to the client as json-message.
 //
-// If the error does not have a Type() string message, it is handled as 500er.
-// In other case, it is handled as 400er.
+// The status code is taken from the Status() method of the error. Other errors
+// with a Type() method are handled as 400er and all other errors as 500er.
 func Error(w http.ResponseWriter, err error) {
 	if isConnectionClose(err) {
 		return
 	}
 
-	var errTyped interface {
+	status := errorStatus(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	icclog.Debug(writerContext(w), "Returning error", "status", status)
+	ErrorNoStatus(w, err)
+}
+
+func errorStatus(err error) int {
+	var errStatus interface {
 		error
-		Type() string
+		Status() int
 	}
-	status := 500
+	if errors.As(err, &errStatus) {
+		return errStatus.Status()
+	}
+
+	var errTyped typedError
 	if errors.As(err, &errTyped) {
-		if errTyped.Type() != iccerror.ErrInternal.Error() {
-			status = 400
+		return 400
+	}
+	return 500
+}
+
+// writerContext returns the request context of a writer that was wrapped by
+// the AuthMiddleware.
+func writerContext(w io.Writer) context.Context {
+	if rw, ok := w.(*responseWriter); ok {
+		return rw.ctx
+	}
+	return context.Background()
+}
+
+// Events that can be sent in a stream.
+const (
+	// EventReconnect tells the client to reconnect after the given delay.
+	EventReconnect = "reconnect"
+
+	// EventResync tells the client, that it missed messages. It has to
+	// reconnect and load its state again.
+	EventResync = "resync"
+
+	// EventDegraded tells the client, that the backend is not reachable. The
+	// stream stays open, but messages can be delayed or lost.
+	EventDegraded = "degraded"
+
+	// EventRecovered tells the client, that the backend is reachable again
+	// after a degraded event.
+	EventRecovered = "recovered"
+)
+
+// StreamEvent is a message in a stream that is not a notify or applause
+// message.
+//
+// It implements the error interface, so it can be used as cause for a
+// canceled context.
+type StreamEvent struct {
+	Event   string `json:"event"`
+	DelayMS int64  `json:"delay_ms,omitempty"`
+}
+
+func (e StreamEvent) Error() string {
+	return fmt.Sprintf("stream event %s", e.Event)
+}
+
+// WriteEvent writes an event as json line to a stream and flushes it.
+func WriteEvent(w io.Writer, event StreamEvent) error {
+	if err := json.NewEncoder(w).Encode(event); err != nil {
+		return fmt.Errorf("writing event %s: %w", event.Event, err)
+	}
+
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// WriteWithTimeout calls write with a write deadline. If the client does not
+// read the data in time, write returns an error. A timeout of 0 means no
+// deadline.
+//
+// The deadline is removed afterwards, so the stream is not closed while it
+// waits for the next message.
+func WriteWithTimeout(w http.ResponseWriter, timeout time.Duration, write func() error) error {
+	if timeout <= 0 {
+		return write()
+	}
+
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		if errors.Is(err, http.ErrNotSupported) {
+			return write()
 		}
+		return fmt.Errorf("setting write deadline: %w", err)
+	}
+
+	if err := write(); err != nil {
+		return err
+	}
+
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		return fmt.Errorf("removing write deadline: %w", err)
+	}
+	return nil
+}
+
+// IsWriteTimeout returns true, if the error is from a write deadline.
+func IsWriteTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// StreamClose writes the reason, why a stream was closed.
+//
+// If ctx was canceled with a cause, the cause is sent instead of err. A
+// StreamEvent is sent as event, all other errors like in ErrorNoStatus.
+func StreamClose(ctx context.Context, w io.Writer, err error) {
+	if cause := context.Cause(ctx); cause != nil && !isConnectionClose(cause) {
+		err = cause
+	}
+
+	var event StreamEvent
+	if errors.As(err, &event) {
+		if err := WriteEvent(w, event); err != nil {
+			icclog.Debug(writerContext(w), "Sending stream event", "error", err)
+		}
+		return
 	}
 
-	w.WriteHeader(status)
-	icclog.Debug("HTTP: Returning status %d", status)
 	ErrorNoStatus(w, err)
 }
 
@@ -78,19 +209,103 @@ func isConnectionClose(err error) bool {
 }
 
 // AuthMiddleware checks the user id of the request.
+//
+// It also attaches the request id, the path and the user id to the request
+// context, so they are added to each log entry.
 func AuthMiddleware(next http.Handler, auth Authenticater) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx, err := auth.Authenticate(w, r)
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		rw := &responseWriter{
+			ResponseWriter: w,
+			ctx:            icclog.WithAttrs(r.Context(), "request_id", requestID, "path", r.URL.Path),
+		}
+
+		started := time.Now()
+		defer func() {
+			icclog.Debug(rw.ctx, "Request finished", "status", rw.status, "duration", time.Since(started))
+		}()
+
+		ctx, err := auth.Authenticate(rw, r.WithContext(rw.ctx))
 		if err != nil {
-			w.WriteHeader(401)
-			ErrorNoStatus(w, iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous user can not receive icc messages."))
+			icclog.Debug(rw.ctx, "Authentication failed", "error", err)
+			Error(rw, iccerror.NewMessageError(iccerror.ErrUnauthorized, "The request could not be authenticated."))
 			return
 		}
+
+		icclog.AddAttrs(rw.ctx, "user_id", auth.FromContext(ctx))
+
+		ctx, stop := withLogoutCause(r.Context(), ctx)
+		defer stop()
+
 		r = r.WithContext(ctx)
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(rw, r)
 	})
 }
 
+// withLogoutCause returns a context, that is canceled with errLoggedOut, if
+// the authenticated context is canceled while the request is still open. This
+// happens, when the session of the user is revoked.
+func withLogoutCause(requestCtx, authCtx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(authCtx))
+	stop := context.AfterFunc(authCtx, func() {
+		if requestCtx.Err() == nil {
+			cancel(errLoggedOut)
+			return
+		}
+		cancel(context.Cause(requestCtx))
+	})
+
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// responseWriter wraps a http.ResponseWriter. It remembers the request context
+// for logging and the returned status code.
+type responseWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	status int
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = 200
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements the http.Flusher interface.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap is used by the http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 // HandleHealth returns 200 (if the service is running).
 func HandleHealth(mux *http.ServeMux) {
 	mux.HandleFunc(
@@ -102,17 +317,48 @@ func HandleHealth(mux *http.ServeMux) {
 	)
 }
 
+// HandleReady returns 200, if the service and all its dependencies are ready.
+// Returns 503 otherwise.
+//
+// The body contains the result of each check and the state of the background
+// loops.
+func HandleReady(mux *http.ServeMux, status *iccstatus.Status) {
+	mux.HandleFunc(
+		Path+"/ready",
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store, max-age=0")
+
+			report := status.Report(r.Context())
+			if !report.Ready {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+
+			if err := json.NewEncoder(w).Encode(report); err != nil {
+				icclog.Debug(r.Context(), "Sending ready report", "error", err)
+			}
+		},
+	)
+}
+
 // HealthClient sends a http request to a server to fetch the health status.
-func HealthClient(ctx context.Context, useHTTPS bool, host, port string, insecure bool) error {
+//
+// If ready is true, the readiness of the server is fetched instead.
+func HealthClient(ctx context.Context, useHTTPS bool, host, port string, insecure bool, ready bool) error {
 	proto := "http"
 	if useHTTPS {
 		proto = "https"
 	}
 
+	route := "health"
+	if ready {
+		route = "ready"
+	}
+
 	req, err := http.NewRequestWithContext(
 		ctx,
 		"GET",
-		fmt.Sprintf("%s://%s:%s/system/icc/health", proto, host, port),
+		fmt.Sprintf("%s://%s:%s%s/%s", proto, host, port, Path, route),
 		nil,
 	)
 	if err != nil {
@@ -129,6 +375,10 @@ func HealthClient(ctx context.Context, useHTTPS bool, host, port string, insecur
 	}
 	defer resp.Body.Close()
 
+	if ready {
+		return readyResponse(resp)
+	}
+
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("health returned status %s", resp.Status)
 	}
@@ -147,3 +397,34 @@ func HealthClient(ctx context.Context, useHTTPS bool, host, port string, insecur
 
 	return nil
 }
+
+// readyResponse parses the response of the ready route.
+func readyResponse(resp *http.Response) error {
+	if resp.StatusCode != 200 && resp.StatusCode != http.StatusServiceUnavailable {
+		return fmt.Errorf("ready returned status %s", resp.Status)
+	}
+
+	var report iccstatus.Report
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return fmt.Errorf("reading and parsing response body: %w", err)
+	}
+
+	if report.Ready {
+		return nil
+	}
+
+	var problems []string
+	for name, check := range report.Checks {
+		if !check.OK {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, check.Error))
+		}
+	}
+	for name, loop := range report.Loops {
+		if loop.Error != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, loop.Error))
+		}
+	}
+	sort.Strings(problems)
+
+	return fmt.Errorf("Server is not ready: %s", strings.Join(problems, ", "))
+}
@@ -1,48 +1,147 @@
+// Package icclog implements structured logging for the icc service.
+//
+// All functions take a context. Attributes that are attached to the context
+// with WithAttrs or AddAttrs are added to each log entry.
 package icclog
 
-import "log"
-
-var (
-	debugLogger *log.Logger
-	infoLogger  *log.Logger
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
 )
 
-// SetDebugLogger sets the debug logger. The default is no log at all.
+var logger = slog.New(contextHandler{slog.NewJSONHandler(os.Stderr, nil)})
+
+// Setup configures the logger. The default is to write json to stderr with
+// the level info.
 //
-// This function should only be started at the beginnen of the program before
-// the Debug was called for the frist time.
-func SetDebugLogger(l *log.Logger) {
-	debugLogger = l
+// This function should only be called at the beginning of the program
+// before any log function was called.
+func Setup(w io.Writer, level slog.Level, format string) error {
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch format {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format `%s`", format)
+	}
+
+	logger = slog.New(contextHandler{handler})
+	return nil
 }
 
-// SetInfoLogger sets the Logger for info messages. The default is log.Default()
-//
-// This function should only be started at the beginnen of the program before
-// the Debug was called for the frist time.
-func SetInfoLogger(l *log.Logger) {
-	infoLogger = l
+// ParseLevel parses a log level like `debug` or `info`.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(s))); err != nil {
+		return 0, fmt.Errorf("invalid log level `%s`: %w", s, err)
+	}
+	return level, nil
 }
 
-// Info prints output that is important for the user.
-func Info(format string, a ...interface{}) {
-	if infoLogger == nil {
-		return
+// Debug logs output that is important for development and debugging.
+func Debug(ctx context.Context, msg string, args ...any) {
+	logger.DebugContext(ctx, msg, args...)
+}
+
+// Info logs output that is important for the user.
+func Info(ctx context.Context, msg string, args ...any) {
+	logger.InfoContext(ctx, msg, args...)
+}
+
+// Warn logs problems, that the service can handle.
+func Warn(ctx context.Context, msg string, args ...any) {
+	logger.WarnContext(ctx, msg, args...)
+}
+
+// Error logs errors.
+func Error(ctx context.Context, msg string, args ...any) {
+	logger.ErrorContext(ctx, msg, args...)
+}
+
+// IsDebug returns if debug output is enabled.
+func IsDebug() bool {
+	return logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+type attrsKey struct{}
+
+// attrBag holds the log attributes of a context. It is a pointer, so
+// attributes can be added after the context was created.
+type attrBag struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// WithAttrs returns a new context with log attributes. The attributes of the
+// parent context are kept.
+//
+// Attributes added later with AddAttrs to the returned context or any derived
+// context are also used.
+func WithAttrs(ctx context.Context, args ...any) context.Context {
+	bag := new(attrBag)
+	if parent, ok := ctx.Value(attrsKey{}).(*attrBag); ok {
+		parent.mu.Lock()
+		bag.attrs = append(bag.attrs, parent.attrs...)
+		parent.mu.Unlock()
 	}
-	infoLogger.Printf(format, a...)
+	bag.attrs = append(bag.attrs, argsToAttrs(args)...)
+
+	return context.WithValue(ctx, attrsKey{}, bag)
 }
 
-// Debug prints output that is important for development and debugging.
+// AddAttrs adds log attributes to a context created with WithAttrs.
 //
-// If EnableDebug() was not called, this function is a noop.
-func Debug(format string, a ...interface{}) {
-	if debugLogger == nil {
+// Does nothing, if the context was not created by WithAttrs.
+func AddAttrs(ctx context.Context, args ...any) {
+	bag, ok := ctx.Value(attrsKey{}).(*attrBag)
+	if !ok {
 		return
 	}
 
-	debugLogger.Printf(format, a...)
+	bag.mu.Lock()
+	bag.attrs = append(bag.attrs, argsToAttrs(args)...)
+	bag.mu.Unlock()
 }
 
-// IsDebug returns if debug output is enabled.
-func IsDebug() bool {
-	return debugLogger != nil
+func argsToAttrs(args []any) []slog.Attr {
+	var r slog.Record
+	r.Add(args...)
+
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	return attrs
+}
+
+// contextHandler adds the attributes from the context to each log record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if bag, ok := ctx.Value(attrsKey{}).(*attrBag); ok {
+		bag.mu.Lock()
+		r.AddAttrs(bag.attrs...)
+		bag.mu.Unlock()
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
 }
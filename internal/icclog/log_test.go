@@ -0,0 +1,76 @@
+package icclog_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/icclog"
+)
+
+func TestContextAttrs(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := icclog.Setup(buf, slog.LevelDebug, "json"); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+
+	ctx := icclog.WithAttrs(context.Background(), "request_id", "abc")
+	derived, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Attributes added to a derived context are also visible in the parent.
+	icclog.AddAttrs(derived, "user_id", 5)
+
+	icclog.Info(ctx, "hello", "key", "value")
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("decoding log output %q: %v", buf.String(), err)
+	}
+
+	for key, expect := range map[string]any{
+		"msg":        "hello",
+		"level":      "INFO",
+		"key":        "value",
+		"request_id": "abc",
+		"user_id":    float64(5),
+	} {
+		if got[key] != expect {
+			t.Errorf("log entry has %s=%v, expected %v", key, got[key], expect)
+		}
+	}
+}
+
+func TestLevel(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := icclog.Setup(buf, slog.LevelInfo, "text"); err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+
+	icclog.Debug(context.Background(), "hidden")
+
+	if buf.Len() != 0 {
+		t.Errorf("debug message was logged with level info: %s", buf.String())
+	}
+
+	if icclog.IsDebug() {
+		t.Errorf("IsDebug() returned true with level info")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := icclog.ParseLevel("warn")
+	if err != nil {
+		t.Fatalf("ParseLevel: %v", err)
+	}
+
+	if level != slog.LevelWarn {
+		t.Errorf("ParseLevel returned %v, expected %v", level, slog.LevelWarn)
+	}
+
+	if _, err := icclog.ParseLevel("loud"); err == nil {
+		t.Errorf("ParseLevel returned no error for an invalid level")
+	}
+}
@@ -0,0 +1,262 @@
+// Package iccmetric collects metrics of the icc service in the prometheus
+// format.
+//
+// The metrics are always collected. They are only exposed, if HandleMetrics
+// is called.
+package iccmetric
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "icc"
+
+// Kinds of streams and messages.
+const (
+	KindNotify   = "notify"
+	KindApplause = "applause"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	streams = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "streams",
+		Help:      "Number of open streams.",
+	}, []string{"kind"})
+
+	meetingStreams = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "meeting_streams",
+		Help:      "Number of open streams per meeting.",
+	}, []string{"kind", "meeting_id"})
+
+	published = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_published_total",
+		Help:      "Number of published messages.",
+	}, []string{"kind"})
+
+	delivered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_delivered_total",
+		Help:      "Number of messages sent to clients.",
+	}, []string{"kind"})
+
+	publishLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "publish_duration_seconds",
+		Help:      "Time to validate and save a published message.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"kind"})
+
+	backendErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_errors_total",
+		Help:      "Number of errors from the backend in the background loops.",
+	}, []string{"subsystem"})
+
+	slowConsumers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "slow_consumers_total",
+		Help:      "Number of streams, that were closed because the client did not read fast enough.",
+	}, []string{"kind", "reason"})
+
+	applauseLevel = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "applause_level",
+		Help:      "Current applause level per meeting.",
+	}, []string{"meeting_id"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		streams,
+		meetingStreams,
+		published,
+		delivered,
+		publishLatency,
+		backendErrors,
+		slowConsumers,
+		applauseLevel,
+		funcs,
+	)
+}
+
+var (
+	meetingStreamsMu    sync.Mutex
+	meetingStreamsCount = make(map[[2]string]int)
+)
+
+// StreamOpened counts an open stream. The returned function has to be called,
+// when the stream is closed.
+//
+// A meetingID of 0 means, that the stream does not belong to a meeting.
+func StreamOpened(kind string, meetingID int) (closed func()) {
+	streams.WithLabelValues(kind).Inc()
+	if meetingID == 0 {
+		return func() { streams.WithLabelValues(kind).Dec() }
+	}
+
+	key := [2]string{kind, strconv.Itoa(meetingID)}
+
+	meetingStreamsMu.Lock()
+	meetingStreamsCount[key]++
+	meetingStreams.WithLabelValues(key[0], key[1]).Set(float64(meetingStreamsCount[key]))
+	meetingStreamsMu.Unlock()
+
+	return func() {
+		streams.WithLabelValues(kind).Dec()
+
+		meetingStreamsMu.Lock()
+		defer meetingStreamsMu.Unlock()
+
+		meetingStreamsCount[key]--
+		if meetingStreamsCount[key] <= 0 {
+			// Remove the series, so closed meetings do not stay forever.
+			delete(meetingStreamsCount, key)
+			meetingStreams.DeleteLabelValues(key[0], key[1])
+			return
+		}
+		meetingStreams.WithLabelValues(key[0], key[1]).Set(float64(meetingStreamsCount[key]))
+	}
+}
+
+// Published counts a published message and its latency.
+func Published(kind string, started time.Time) {
+	published.WithLabelValues(kind).Inc()
+	publishLatency.WithLabelValues(kind).Observe(time.Since(started).Seconds())
+}
+
+// Delivered counts messages that where sent to a client.
+func Delivered(kind string, count int) {
+	delivered.WithLabelValues(kind).Add(float64(count))
+}
+
+// BackendError counts an error from the backend in a background loop.
+func BackendError(subsystem string) {
+	backendErrors.WithLabelValues(subsystem).Inc()
+}
+
+// Reasons, why a slow consumer was disconnected.
+const (
+	SlowConsumerLag          = "lag"
+	SlowConsumerWriteTimeout = "write_timeout"
+)
+
+// SlowConsumer counts a stream, that was closed, because the client did not
+// read fast enough.
+func SlowConsumer(kind, reason string) {
+	slowConsumers.WithLabelValues(kind, reason).Inc()
+}
+
+// ApplauseLevel sets the current applause level of a meeting.
+func ApplauseLevel(meetingID, level int) {
+	if level == 0 {
+		applauseLevel.DeleteLabelValues(strconv.Itoa(meetingID))
+		return
+	}
+	applauseLevel.WithLabelValues(strconv.Itoa(meetingID)).Set(float64(level))
+}
+
+// funcs contains the functions, that are called on each scrape. They are set
+// with TopicSize and BackendState.
+//
+// The collector is registered only once, so the functions can be set again,
+// when the service is initialized a second time in the same process.
+var funcs = &funcCollector{topics: make(map[string]func() int)}
+
+// TopicSize sets a function, that returns the number of messages in a topic.
+// It replaces an earlier function with the same name.
+//
+// The function has to be cheap, because it is called on each scrape.
+func TopicSize(name string, size func() int) {
+	funcs.mu.Lock()
+	defer funcs.mu.Unlock()
+
+	funcs.topics[name] = size
+}
+
+// BackendState sets a function, that returns the current state of the
+// backend. Each state in states gets a series, that is 1 for the current state
+// and 0 otherwise. It replaces an earlier function.
+func BackendState(states []string, state func() string) {
+	funcs.mu.Lock()
+	defer funcs.mu.Unlock()
+
+	funcs.states = states
+	funcs.state = state
+}
+
+var (
+	topicSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "topic_size"),
+		"Number of messages in a topic.",
+		[]string{"topic"},
+		nil,
+	)
+
+	backendStateDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "backend_state"),
+		"State of the backend.",
+		[]string{"state"},
+		nil,
+	)
+)
+
+// funcCollector collects the metrics from the functions set with TopicSize and
+// BackendState.
+type funcCollector struct {
+	mu     sync.Mutex
+	topics map[string]func() int
+	states []string
+	state  func() string
+}
+
+// Describe implements prometheus.Collector.
+func (c *funcCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- topicSizeDesc
+	ch <- backendStateDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *funcCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, size := range c.topics {
+		ch <- prometheus.MustNewConstMetric(topicSizeDesc, prometheus.GaugeValue, float64(size()), name)
+	}
+
+	if c.state == nil {
+		return
+	}
+
+	current := c.state()
+	for _, s := range c.states {
+		value := 0.0
+		if s == current {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(backendStateDesc, prometheus.GaugeValue, value, s)
+	}
+}
+
+// HandleMetrics registers the metrics route.
+func HandleMetrics(mux *http.ServeMux) {
+	mux.Handle(
+		icchttp.Path+"/metrics",
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
+	)
+}
@@ -0,0 +1,87 @@
+package iccmetric_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccmetric"
+)
+
+func fetchMetrics(t *testing.T) string {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	iccmetric.HandleMetrics(mux)
+	resp := httptest.NewRecorder()
+
+	mux.ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/metrics", nil))
+
+	if resp.Result().StatusCode != 200 {
+		t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+	}
+	return resp.Body.String()
+}
+
+func TestStreamOpened(t *testing.T) {
+	closed1 := iccmetric.StreamOpened(iccmetric.KindNotify, 7)
+	closed2 := iccmetric.StreamOpened(iccmetric.KindNotify, 7)
+
+	expect := `icc_meeting_streams{kind="notify",meeting_id="7"} 2`
+	if got := fetchMetrics(t); !strings.Contains(got, expect) {
+		t.Errorf("metrics do not contain `%s`:\n%s", expect, got)
+	}
+
+	closed1()
+	closed2()
+
+	if got := fetchMetrics(t); strings.Contains(got, `meeting_id="7"`) {
+		t.Errorf("metrics contain meeting 7 after all streams where closed:\n%s", got)
+	}
+}
+
+func TestTopicSize(t *testing.T) {
+	iccmetric.TopicSize("test", func() int { return 42 })
+
+	expect := `icc_topic_size{topic="test"} 42`
+	if got := fetchMetrics(t); !strings.Contains(got, expect) {
+		t.Errorf("metrics do not contain `%s`:\n%s", expect, got)
+	}
+
+	t.Run("Set again", func(t *testing.T) {
+		iccmetric.TopicSize("test", func() int { return 43 })
+
+		expect := `icc_topic_size{topic="test"} 43`
+		if got := fetchMetrics(t); !strings.Contains(got, expect) {
+			t.Errorf("metrics do not contain `%s`:\n%s", expect, got)
+		}
+	})
+}
+
+func TestBackendState(t *testing.T) {
+	// The function can be set many times, for example when the service is
+	// initialized twice.
+	for range 2 {
+		iccmetric.BackendState([]string{"up", "down"}, func() string { return "down" })
+	}
+
+	got := fetchMetrics(t)
+	for _, expect := range []string{
+		`icc_backend_state{state="down"} 1`,
+		`icc_backend_state{state="up"} 0`,
+	} {
+		if !strings.Contains(got, expect) {
+			t.Errorf("metrics do not contain `%s`:\n%s", expect, got)
+		}
+	}
+}
+
+func TestSlowConsumer(t *testing.T) {
+	iccmetric.SlowConsumer(iccmetric.KindNotify, iccmetric.SlowConsumerLag)
+
+	expect := `icc_slow_consumers_total{kind="notify",reason="lag"} 1`
+	if got := fetchMetrics(t); !strings.Contains(got, expect) {
+		t.Errorf("metrics do not contain `%s`:\n%s", expect, got)
+	}
+}
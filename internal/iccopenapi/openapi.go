@@ -0,0 +1,121 @@
+// Package iccopenapi contains the openapi document of the service.
+//
+// The document is served by the service and used to validate the requests.
+package iccopenapi
+
+import (
+	"context"
+	_ "embed" // Needed for the openapi document.
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+)
+
+//go:embed openapi.yml
+var document []byte
+
+// Spec is the parsed openapi document.
+type Spec struct {
+	doc    *openapi3.T
+	router routers.Router
+}
+
+// Load parses and validates the openapi document.
+func Load() (*Spec, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(document)
+	if err != nil {
+		return nil, fmt.Errorf("parsing openapi document: %w", err)
+	}
+
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("validating openapi document: %w", err)
+	}
+
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("creating router: %w", err)
+	}
+
+	return &Spec{doc: doc, router: router}, nil
+}
+
+// Doc returns the parsed document.
+func (s *Spec) Doc() *openapi3.T {
+	return s.doc
+}
+
+// FindRoute returns the operation of a request.
+func (s *Spec) FindRoute(r *http.Request) (*routers.Route, map[string]string, error) {
+	return s.router.FindRoute(r)
+}
+
+// Middleware checks the method and the parameters of each request against the
+// document.
+//
+// The request body is not read. The handlers decode it themselves with a size
+// limit. Requests to paths, that are not in the document, are passed to next
+// without a check.
+func (s *Spec) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := s.router.FindRoute(r)
+		if err != nil {
+			var errRoute *routers.RouteError
+			if errors.As(err, &errRoute) && errRoute.Reason == routers.ErrMethodNotAllowed.Error() {
+				icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrMethodNotAllowed, "Method %s is not allowed.", r.Method))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				ExcludeRequestBody:  true,
+				SkipSettingDefaults: true,
+			},
+		}
+
+		if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+			icchttp.Error(w, requestError(err))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestError converts a validation error to an error for the client.
+func requestError(err error) error {
+	var errRequest *openapi3filter.RequestError
+	if errors.As(err, &errRequest) && errRequest.Parameter != nil {
+		return iccerror.NewDetailsError(
+			iccerror.ErrInvalid,
+			map[string]any{"parameter": errRequest.Parameter.Name, "in": errRequest.Parameter.In},
+			"%s", errRequest.Error(),
+		)
+	}
+
+	return iccerror.NewMessageError(iccerror.ErrInvalid, "%s", err.Error())
+}
+
+// Handle registers the route that returns the openapi document.
+func Handle(mux *http.ServeMux) {
+	mux.HandleFunc(
+		icchttp.Path+"/openapi.yml",
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/yaml")
+			w.Write(document)
+		},
+	)
+}
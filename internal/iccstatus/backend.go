@@ -0,0 +1,82 @@
+package iccstatus
+
+import (
+	"context"
+	"time"
+)
+
+// State is the connection state of the backend.
+type State string
+
+// States of the backend.
+const (
+	// StateConnected means, that the last run of each loop succeeded.
+	StateConnected State = "connected"
+
+	// StateDegraded means, that a loop fails, but not for long.
+	StateDegraded State = "degraded"
+
+	// StateDown means, that a loop fails for a longer time.
+	StateDown State = "down"
+)
+
+// States are all states of the backend.
+var States = []State{StateConnected, StateDegraded, StateDown}
+
+// Backend derives the state of the backend from the loops, that use it.
+type Backend struct {
+	downAfter time.Duration
+	loops     []*Loop
+}
+
+// NewBackend initializes a Backend.
+//
+// The backend is down, if one of the loops fails for longer then downAfter.
+func NewBackend(downAfter time.Duration, loops ...*Loop) *Backend {
+	return &Backend{
+		downAfter: downAfter,
+		loops:     loops,
+	}
+}
+
+// State returns the current state of the backend.
+func (b *Backend) State() State {
+	state := StateConnected
+	for _, l := range b.loops {
+		since := l.FailingSince()
+		if since.IsZero() {
+			continue
+		}
+
+		if time.Since(since) >= b.downAfter {
+			return StateDown
+		}
+		state = StateDegraded
+	}
+	return state
+}
+
+// Watch checks the state in the given interval and calls changed, when it is
+// different from the last check. The first state is compared to
+// StateConnected.
+//
+// Blocks until the context is done.
+func (b *Backend) Watch(ctx context.Context, interval time.Duration, changed func(old, new State)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := StateConnected
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		state := b.State()
+		if state != last {
+			changed(last, state)
+			last = state
+		}
+	}
+}
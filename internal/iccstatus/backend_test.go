@@ -0,0 +1,100 @@
+package iccstatus_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccstatus"
+)
+
+func TestBackend(t *testing.T) {
+	t.Run("Connected", func(t *testing.T) {
+		loop := new(iccstatus.Loop)
+		loop.Success()
+		backend := iccstatus.NewBackend(time.Hour, loop, new(iccstatus.Loop))
+
+		if got := backend.State(); got != iccstatus.StateConnected {
+			t.Errorf("State() = %s, expected %s", got, iccstatus.StateConnected)
+		}
+	})
+
+	t.Run("Degraded", func(t *testing.T) {
+		ok := new(iccstatus.Loop)
+		ok.Success()
+		failing := new(iccstatus.Loop)
+		failing.Failure(errors.New("redis down"))
+		backend := iccstatus.NewBackend(time.Hour, ok, failing)
+
+		if got := backend.State(); got != iccstatus.StateDegraded {
+			t.Errorf("State() = %s, expected %s", got, iccstatus.StateDegraded)
+		}
+	})
+
+	t.Run("Down", func(t *testing.T) {
+		loop := new(iccstatus.Loop)
+		loop.Failure(errors.New("redis down"))
+		backend := iccstatus.NewBackend(time.Millisecond, loop)
+		time.Sleep(2 * time.Millisecond)
+
+		if got := backend.State(); got != iccstatus.StateDown {
+			t.Errorf("State() = %s, expected %s", got, iccstatus.StateDown)
+		}
+	})
+
+	t.Run("Down keeps the first failure", func(t *testing.T) {
+		loop := new(iccstatus.Loop)
+		loop.Failure(errors.New("redis down"))
+		backend := iccstatus.NewBackend(time.Millisecond, loop)
+		time.Sleep(2 * time.Millisecond)
+		loop.Failure(errors.New("still down"))
+
+		if got := backend.State(); got != iccstatus.StateDown {
+			t.Errorf("State() = %s, expected %s", got, iccstatus.StateDown)
+		}
+	})
+
+	t.Run("Recovered", func(t *testing.T) {
+		loop := new(iccstatus.Loop)
+		loop.Failure(errors.New("redis down"))
+		loop.Success()
+		backend := iccstatus.NewBackend(time.Hour, loop)
+
+		if got := backend.State(); got != iccstatus.StateConnected {
+			t.Errorf("State() = %s, expected %s", got, iccstatus.StateConnected)
+		}
+	})
+
+	t.Run("Watch", func(t *testing.T) {
+		loop := new(iccstatus.Loop)
+		backend := iccstatus.NewBackend(time.Hour, loop)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		changes := make(chan [2]iccstatus.State, 2)
+		go backend.Watch(ctx, time.Millisecond, func(old, new iccstatus.State) {
+			changes <- [2]iccstatus.State{old, new}
+		})
+
+		loop.Failure(errors.New("redis down"))
+		expectChange(t, changes, iccstatus.StateConnected, iccstatus.StateDegraded)
+
+		loop.Success()
+		expectChange(t, changes, iccstatus.StateDegraded, iccstatus.StateConnected)
+	})
+}
+
+func expectChange(t *testing.T, changes <-chan [2]iccstatus.State, old, new iccstatus.State) {
+	t.Helper()
+
+	select {
+	case got := <-changes:
+		if got != [2]iccstatus.State{old, new} {
+			t.Errorf("got change %s -> %s, expected %s -> %s", got[0], got[1], old, new)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("no change from %s to %s", old, new)
+	}
+}
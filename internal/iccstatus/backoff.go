@@ -0,0 +1,57 @@
+package iccstatus
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Backoff returns the time to wait before a failed loop runs again.
+//
+// The time doubles with each failure from Min up to Max. A random jitter of
+// up to half of the time is subtracted, so many instances do not retry at the
+// same time.
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+
+	attempt int
+}
+
+// Next returns the time to wait after a failure.
+func (b *Backoff) Next() time.Duration {
+	d := b.Min
+	for i := 0; i < b.attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d >= b.Max {
+		d = b.Max
+	} else {
+		b.attempt++
+	}
+
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return d - time.Duration(rand.Int63n(half+1))
+}
+
+// Reset starts with Min again. It is called after a successful run.
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+// Wait sleeps the time from Next. It returns early with the error of the
+// context, if it is done.
+func (b *Backoff) Wait(ctx context.Context) error {
+	timer := time.NewTimer(b.Next())
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
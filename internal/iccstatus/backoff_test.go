@@ -0,0 +1,53 @@
+package iccstatus_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccstatus"
+)
+
+func TestBackoff(t *testing.T) {
+	t.Run("Doubles up to max", func(t *testing.T) {
+		b := iccstatus.Backoff{Min: time.Second, Max: 5 * time.Second}
+
+		for i, expect := range []time.Duration{
+			time.Second,
+			2 * time.Second,
+			4 * time.Second,
+			5 * time.Second,
+			5 * time.Second,
+		} {
+			got := b.Next()
+			if got > expect || got < expect/2 {
+				t.Errorf("wait %d is %s, expected between %s and %s", i, got, expect/2, expect)
+			}
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		b := iccstatus.Backoff{Min: time.Second, Max: time.Minute}
+		for range 5 {
+			b.Next()
+		}
+
+		b.Reset()
+
+		if got := b.Next(); got > time.Second {
+			t.Errorf("wait after reset is %s, expected at most 1s", got)
+		}
+	})
+
+	t.Run("Wait with canceled context", func(t *testing.T) {
+		b := iccstatus.Backoff{Min: time.Hour, Max: time.Hour}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := b.Wait(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("Wait returned %v, expected context.Canceled", err)
+		}
+	})
+}
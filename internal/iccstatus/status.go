@@ -0,0 +1,176 @@
+// Package iccstatus tracks the state of the background loops and the
+// dependencies of the service.
+package iccstatus
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// checkTimeout is the maximum time for one check.
+const checkTimeout = 2 * time.Second
+
+// Loop tracks the state of a background loop.
+//
+// The zero value is a loop, that never succeeded.
+type Loop struct {
+	mu           sync.Mutex
+	lastSuccess  time.Time
+	lastErr      error
+	failingSince time.Time
+}
+
+// Success marks a successful run of the loop.
+func (l *Loop) Success() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastSuccess = time.Now()
+	l.lastErr = nil
+	l.failingSince = time.Time{}
+}
+
+// Failure marks a failed run of the loop.
+func (l *Loop) Failure(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastErr = err
+	if l.failingSince.IsZero() {
+		l.failingSince = time.Now()
+	}
+}
+
+// State returns the time of the last successful run and the error of the last
+// run, if it failed.
+func (l *Loop) State() (lastSuccess time.Time, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.lastSuccess, l.lastErr
+}
+
+// FailingSince returns the time of the first failure after the last success.
+// It is zero, if the last run did not fail.
+func (l *Loop) FailingSince() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.failingSince
+}
+
+// Status collects the checks for the dependencies and the background loops.
+type Status struct {
+	checks  []namedCheck
+	loops   []namedLoop
+	backend *Backend
+}
+
+type namedCheck struct {
+	name  string
+	check func(context.Context) error
+}
+
+type namedLoop struct {
+	name string
+	loop *Loop
+}
+
+// AddCheck adds a function that checks a dependency.
+func (s *Status) AddCheck(name string, check func(context.Context) error) {
+	s.checks = append(s.checks, namedCheck{name, check})
+}
+
+// AddLoop adds a background loop.
+func (s *Status) AddLoop(name string, loop *Loop) {
+	s.loops = append(s.loops, namedLoop{name, loop})
+}
+
+// SetBackend sets the state of the backend, that is added to the report.
+func (s *Status) SetBackend(b *Backend) {
+	s.backend = b
+}
+
+// CheckResult is the result of one check.
+type CheckResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// LoopResult is the state of one background loop.
+type LoopResult struct {
+	// LastSuccessSeconds is the number of seconds since the last successful
+	// run. It is nil, if the loop never succeeded.
+	LastSuccessSeconds *float64 `json:"last_success_seconds"`
+	Error              string   `json:"error,omitempty"`
+}
+
+// Report is the state of all checks and loops.
+type Report struct {
+	Ready   bool                   `json:"ready"`
+	Checks  map[string]CheckResult `json:"checks"`
+	Loops   map[string]LoopResult  `json:"loops"`
+	Backend State                  `json:"backend,omitempty"`
+}
+
+// Report runs all checks in parallel and returns the result.
+//
+// The service is ready, if all checks succeed and the last run of each loop
+// did not fail.
+func (s *Status) Report(ctx context.Context) Report {
+	report := Report{
+		Ready:  true,
+		Checks: make(map[string]CheckResult, len(s.checks)),
+		Loops:  make(map[string]LoopResult, len(s.loops)),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	results := make([]CheckResult, len(s.checks))
+	var wg sync.WaitGroup
+	for i, c := range s.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := c.check(ctx); err != nil {
+				results[i] = CheckResult{Error: err.Error()}
+				return
+			}
+			results[i] = CheckResult{OK: true}
+		}()
+	}
+	wg.Wait()
+
+	for i, c := range s.checks {
+		report.Checks[c.name] = results[i]
+		if !results[i].OK {
+			report.Ready = false
+		}
+	}
+
+	for _, l := range s.loops {
+		lastSuccess, err := l.loop.State()
+
+		var result LoopResult
+		if !lastSuccess.IsZero() {
+			seconds := time.Since(lastSuccess).Seconds()
+			result.LastSuccessSeconds = &seconds
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+			report.Ready = false
+		}
+
+		report.Loops[l.name] = result
+	}
+
+	if s.backend != nil {
+		report.Backend = s.backend.State()
+	}
+
+	return report
+}
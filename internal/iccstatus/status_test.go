@@ -0,0 +1,99 @@
+package iccstatus_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/iccstatus"
+)
+
+func TestReport(t *testing.T) {
+	okCheck := func(context.Context) error { return nil }
+	failCheck := func(context.Context) error { return errors.New("connection refused") }
+
+	t.Run("All ready", func(t *testing.T) {
+		var status iccstatus.Status
+		loop := new(iccstatus.Loop)
+		loop.Success()
+		status.AddCheck("redis", okCheck)
+		status.AddLoop("notify", loop)
+
+		report := status.Report(context.Background())
+
+		if !report.Ready {
+			t.Errorf("report is not ready: %v", report)
+		}
+
+		if report.Loops["notify"].LastSuccessSeconds == nil {
+			t.Errorf("loop has no last success")
+		}
+	})
+
+	t.Run("Failing check", func(t *testing.T) {
+		var status iccstatus.Status
+		status.AddCheck("redis", okCheck)
+		status.AddCheck("datastore", failCheck)
+
+		report := status.Report(context.Background())
+
+		if report.Ready {
+			t.Errorf("report is ready with a failing check")
+		}
+
+		if got := report.Checks["datastore"]; got.OK || got.Error != "connection refused" {
+			t.Errorf("datastore check is %v, expected an error", got)
+		}
+
+		if !report.Checks["redis"].OK {
+			t.Errorf("redis check failed")
+		}
+	})
+
+	t.Run("Failing loop", func(t *testing.T) {
+		var status iccstatus.Status
+		loop := new(iccstatus.Loop)
+		loop.Success()
+		loop.Failure(errors.New("redis down"))
+		status.AddLoop("notify", loop)
+
+		report := status.Report(context.Background())
+
+		if report.Ready {
+			t.Errorf("report is ready with a failing loop")
+		}
+
+		if got := report.Loops["notify"]; got.Error != "redis down" || got.LastSuccessSeconds == nil {
+			t.Errorf("notify loop is %v, expected an error and a last success", got)
+		}
+	})
+
+	t.Run("Loop never succeeded", func(t *testing.T) {
+		var status iccstatus.Status
+		status.AddLoop("notify", new(iccstatus.Loop))
+
+		report := status.Report(context.Background())
+
+		if !report.Ready {
+			t.Errorf("report is not ready")
+		}
+
+		if report.Loops["notify"].LastSuccessSeconds != nil {
+			t.Errorf("loop has a last success")
+		}
+	})
+	t.Run("Backend state", func(t *testing.T) {
+		var status iccstatus.Status
+		loop := new(iccstatus.Loop)
+		loop.Failure(errors.New("redis down"))
+		status.AddLoop("notify", loop)
+		status.SetBackend(iccstatus.NewBackend(time.Hour, loop))
+
+		report := status.Report(context.Background())
+
+		if report.Backend != iccstatus.StateDegraded {
+			t.Errorf("backend is %s, expected %s", report.Backend, iccstatus.StateDegraded)
+		}
+	})
+}
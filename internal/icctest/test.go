@@ -20,6 +20,10 @@ func (authError) Type() string {
 type AutherStub struct {
 	UserID  int
 	AuthErr bool
+
+	// Logout can be closed to cancel the returned contexts like a revoked
+	// session.
+	Logout chan struct{}
 }
 
 // Authenticate does nothing. The Stub uses the userID that it was initialized
@@ -28,7 +32,20 @@ func (a *AutherStub) Authenticate(w http.ResponseWriter, r *http.Request) (conte
 	if a.AuthErr {
 		return nil, authError{}
 	}
-	return r.Context(), nil
+
+	if a.Logout == nil {
+		return r.Context(), nil
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	go func() {
+		select {
+		case <-a.Logout:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, nil
 }
 
 // FromContext returns the user id the stub was initializes with.
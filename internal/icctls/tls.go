@@ -0,0 +1,124 @@
+// Package icctls loads the tls certificate for the http server.
+package icctls
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/icclog"
+)
+
+// checkInterval is the minimum time between two checks of the files.
+const checkInterval = time.Second
+
+// Certificate holds a tls certificate from a cert and a key file.
+//
+// The files are reloaded, when they are changed on disk. So a renewed
+// certificate is used without a restart.
+type Certificate struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewCertificate loads the certificate from the files.
+func NewCertificate(certFile, keyFile string) (*Certificate, error) {
+	c := Certificate{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+// Config returns a tls config that uses the certificate.
+func (c *Certificate) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}
+}
+
+// GetCertificate returns the certificate. It can be used as
+// tls.Config.GetCertificate.
+//
+// If a file has changed, it is loaded again. If this fails, the old
+// certificate is used.
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.lastCheck) < checkInterval {
+		return c.cert, nil
+	}
+	c.lastCheck = time.Now()
+
+	modTime, err := c.latestModTime()
+	if err != nil {
+		icclog.Warn(context.Background(), "Can not check tls files", "error", err)
+		return c.cert, nil
+	}
+
+	if modTime.Equal(c.modTime) {
+		return c.cert, nil
+	}
+
+	if err := c.loadLocked(); err != nil {
+		icclog.Warn(context.Background(), "Can not reload tls certificate", "error", err)
+		return c.cert, nil
+	}
+
+	icclog.Info(context.Background(), "Reloaded tls certificate", "cert_file", c.certFile)
+	return c.cert, nil
+}
+
+func (c *Certificate) load() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.loadLocked()
+}
+
+func (c *Certificate) loadLocked() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading tls certificate: %w", err)
+	}
+
+	c.cert = &cert
+	c.modTime = modTime
+	c.lastCheck = time.Now()
+	return nil
+}
+
+// latestModTime returns the modification time of the newer file.
+func (c *Certificate) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("checking %s: %w", file, err)
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,133 @@
+package icctls_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/icctls"
+)
+
+func TestCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	writeCert(t, certFile, keyFile, "first")
+
+	cert, err := icctls.NewCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertificate: %v", err)
+	}
+
+	t.Run("load", func(t *testing.T) {
+		got, err := cert.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("GetCertificate: %v", err)
+		}
+
+		if name := commonName(t, got.Certificate[0]); name != "first" {
+			t.Errorf("got certificate %s, expected first", name)
+		}
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		writeCert(t, certFile, keyFile, "second")
+		future := time.Now().Add(time.Minute)
+		for _, file := range []string{certFile, keyFile} {
+			if err := os.Chtimes(file, future, future); err != nil {
+				t.Fatalf("Chtimes: %v", err)
+			}
+		}
+
+		time.Sleep(1100 * time.Millisecond)
+
+		got, err := cert.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("GetCertificate: %v", err)
+		}
+
+		if name := commonName(t, got.Certificate[0]); name != "second" {
+			t.Errorf("got certificate %s, expected second", name)
+		}
+	})
+
+	t.Run("broken file keeps old certificate", func(t *testing.T) {
+		if err := os.WriteFile(certFile, []byte("broken"), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		future := time.Now().Add(2 * time.Minute)
+		if err := os.Chtimes(certFile, future, future); err != nil {
+			t.Fatalf("Chtimes: %v", err)
+		}
+
+		time.Sleep(1100 * time.Millisecond)
+
+		got, err := cert.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("GetCertificate: %v", err)
+		}
+
+		if name := commonName(t, got.Certificate[0]); name != "second" {
+			t.Errorf("got certificate %s, expected second", name)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := icctls.NewCertificate(filepath.Join(dir, "missing"), keyFile); err == nil {
+			t.Errorf("NewCertificate with missing file did not return an error")
+		}
+	})
+}
+
+func writeCert(t *testing.T, certFile, keyFile, name string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+}
+
+func commonName(t *testing.T, der []byte) string {
+	t.Helper()
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return cert.Subject.CommonName
+}
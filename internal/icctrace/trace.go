@@ -0,0 +1,127 @@
+// Package icctrace configures the opentelemetry tracing of the icc service.
+//
+// If Setup is not called, all spans are noops.
+package icctrace
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	serviceName = "openslides-icc-service"
+	tracerName  = "github.com/OpenSlides/openslides-icc-service"
+)
+
+// Exporters that can be used with Setup.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	ExporterOTLP   = "otlp"
+)
+
+var propagator = propagation.TraceContext{}
+
+// Setup initializes the tracing with the given exporter.
+//
+// The file argument is only used with the file exporter. The otlp exporter is
+// configured with the OTEL_EXPORTER_OTLP_* environment variables.
+//
+// Returns a function that flushes all spans and stops the exporter.
+func Setup(ctx context.Context, exporter string, file string) (shutdown func(context.Context) error, err error) {
+	var spanExporter sdktrace.SpanExporter
+	var closer io.Closer
+
+	switch exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+
+	case ExporterFile:
+		f, ferr := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if ferr != nil {
+			return nil, fmt.Errorf("open trace file: %w", ferr)
+		}
+		closer = f
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+
+	default:
+		return nil, fmt.Errorf("unknown exporter `%s`", exporter)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("creating %s exporter: %w", exporter, err)
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(attribute.String("service.name", serviceName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+
+	shutdown = func(ctx context.Context) error {
+		if err := provider.Shutdown(ctx); err != nil {
+			return fmt.Errorf("shutdown tracer provider: %w", err)
+		}
+
+		if closer != nil {
+			return closer.Close()
+		}
+		return nil
+	}
+
+	return shutdown, nil
+}
+
+// Start creates a span.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Inject returns the trace context of ctx, so it can be saved in a message.
+//
+// Returns nil, if ctx does not contain a span.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract returns a context that contains the trace context from a map
+// created by Inject.
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(carrier))
+}
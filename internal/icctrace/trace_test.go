@@ -0,0 +1,67 @@
+package icctrace_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/icctrace"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInjectExtract(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	t.Run("Without span", func(t *testing.T) {
+		if got := icctrace.Inject(context.Background()); got != nil {
+			t.Errorf("Inject returned %v, expected nil", got)
+		}
+	})
+
+	t.Run("Child of injected span", func(t *testing.T) {
+		ctx, publishSpan := icctrace.Start(context.Background(), "publish")
+		carrier := icctrace.Inject(ctx)
+		publishSpan.End()
+
+		if carrier == nil {
+			t.Fatalf("Inject returned nil")
+		}
+
+		_, deliverSpan := icctrace.Start(icctrace.Extract(context.Background(), carrier), "deliver")
+		deliverSpan.End()
+
+		spans := recorder.Ended()
+		if len(spans) != 2 {
+			t.Fatalf("got %d spans, expected 2", len(spans))
+		}
+
+		if spans[1].Parent().SpanID() != spans[0].SpanContext().SpanID() {
+			t.Errorf("deliver span has parent %s, expected %s", spans[1].Parent().SpanID(), spans[0].SpanContext().SpanID())
+		}
+
+		if spans[1].SpanContext().TraceID() != spans[0].SpanContext().TraceID() {
+			t.Errorf("deliver span is in another trace")
+		}
+	})
+}
+
+func TestSetup(t *testing.T) {
+	t.Run("Unknown exporter", func(t *testing.T) {
+		if _, err := icctrace.Setup(context.Background(), "unknown", ""); err == nil {
+			t.Errorf("Setup returned no error")
+		}
+	})
+
+	t.Run("File exporter", func(t *testing.T) {
+		shutdown, err := icctrace.Setup(context.Background(), icctrace.ExporterFile, t.TempDir()+"/trace.json")
+		if err != nil {
+			t.Fatalf("Setup returned: %v", err)
+		}
+
+		if err := shutdown(context.Background()); err != nil {
+			t.Errorf("shutdown returned: %v", err)
+		}
+	})
+}
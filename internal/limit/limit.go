@@ -0,0 +1,277 @@
+// Package limit restricts the number of open streams per user and per
+// meeting over all instances.
+package limit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/connection"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icclog"
+)
+
+const (
+	// refreshInterval is the time between two refreshs of the open streams
+	// in the backend.
+	refreshInterval = 10 * time.Second
+
+	// streamTTL is the time after a stream is removed from the backend, if it
+	// is not refreshed. For example, when the instance was killed.
+	streamTTL = 3 * refreshInterval
+)
+
+// Modes, what happens, when a limit is reached.
+const (
+	// ModeReject rejects the new stream.
+	ModeReject = "reject"
+
+	// ModeEvict closes the oldest stream of the user.
+	ModeEvict = "evict"
+)
+
+// Backend counts the streams of all instances.
+type Backend interface {
+	// LimitAdd adds a stream to each of the keys. The stream is removed
+	// after it expires, if it is not added again.
+	LimitAdd(keys []string, stream string, expires time.Time) error
+
+	// LimitStreams returns the streams of a key, that are not expired.
+	LimitStreams(key string) ([]string, error)
+
+	// LimitRemove removes a stream from the keys.
+	LimitRemove(keys []string, stream string) error
+}
+
+// Evicter closes a stream on any instance.
+type Evicter interface {
+	Evict(streamID string) error
+}
+
+// Limiter implements connection.Limiter.
+type Limiter struct {
+	backend    Backend
+	evicter    Evicter
+	perUser    int
+	perMeeting int
+	evict      bool
+
+	mu   sync.Mutex
+	open map[string][]string
+}
+
+// New initializes a Limiter.
+//
+// perUser and perMeeting are the maximum number of streams. 0 means no limit.
+// The mode is ModeReject or ModeEvict.
+func New(backend Backend, evicter Evicter, perUser, perMeeting int, mode string) (*Limiter, func(context.Context, func(error)), error) {
+	if mode != ModeReject && mode != ModeEvict {
+		return nil, nil, fmt.Errorf("unknown mode `%s`", mode)
+	}
+
+	l := Limiter{
+		backend:    backend,
+		evicter:    evicter,
+		perUser:    perUser,
+		perMeeting: perMeeting,
+		evict:      mode == ModeEvict,
+		open:       make(map[string][]string),
+	}
+
+	background := func(ctx context.Context, errHandler func(error)) {
+		go l.refresh(ctx, errHandler)
+	}
+
+	return &l, background, nil
+}
+
+// Acquire registers the stream in the backend. It returns an error of the
+// type iccerror.ErrLimit, if a limit is reached and no stream can be evicted.
+func (l *Limiter) Acquire(ctx context.Context, info connection.Info) (func(), error) {
+	type limitedKey struct {
+		key   string
+		limit int
+	}
+
+	var limited []limitedKey
+	if info.UserID != 0 && l.perUser > 0 {
+		limited = append(limited, limitedKey{fmt.Sprintf("user:%d", info.UserID), l.perUser})
+	}
+	if info.MeetingID != 0 && l.perMeeting > 0 {
+		limited = append(limited, limitedKey{fmt.Sprintf("meeting:%d", info.MeetingID), l.perMeeting})
+	}
+
+	if len(limited) == 0 {
+		return func() {}, nil
+	}
+
+	keys := make([]string, len(limited))
+	for i, lk := range limited {
+		keys[i] = lk.key
+	}
+
+	// The stream is added before the streams are counted. So two instances
+	// can not open the last free stream at the same time.
+	self := encodeStream(info)
+	if err := l.backend.LimitAdd(keys, self, time.Now().Add(streamTTL)); err != nil {
+		return nil, fmt.Errorf("adding stream: %w", err)
+	}
+
+	for _, lk := range limited {
+		if err := l.check(ctx, lk.key, lk.limit, self, info.UserID); err != nil {
+			if err := l.backend.LimitRemove(keys, self); err != nil {
+				icclog.Warn(ctx, "Can not remove rejected stream", "error", err)
+			}
+			return nil, err
+		}
+	}
+
+	l.mu.Lock()
+	l.open[self] = keys
+	l.mu.Unlock()
+
+	release := func() {
+		l.mu.Lock()
+		delete(l.open, self)
+		l.mu.Unlock()
+
+		if err := l.backend.LimitRemove(keys, self); err != nil {
+			icclog.Warn(context.Background(), "Can not remove closed stream", "error", err)
+		}
+	}
+
+	return release, nil
+}
+
+// check returns an error, if there are more streams for the key then the
+// limit. In evict mode, the oldest streams of the user are evicted instead.
+func (l *Limiter) check(ctx context.Context, key string, limit int, self string, userID int) error {
+	encoded, err := l.backend.LimitStreams(key)
+	if err != nil {
+		return fmt.Errorf("getting streams: %w", err)
+	}
+
+	over := len(encoded) - limit
+	if over <= 0 {
+		return nil
+	}
+
+	errLimit := iccerror.NewMessageError(iccerror.ErrLimit, "There are too many open streams for %s.", strings.ReplaceAll(key, ":", " "))
+	if !l.evict || userID == 0 {
+		return errLimit
+	}
+
+	var candidates []stream
+	for _, e := range encoded {
+		s, err := decodeStream(e)
+		if err != nil {
+			icclog.Warn(ctx, "Invalid stream in backend", "stream", e, "error", err)
+			continue
+		}
+
+		if e != self && s.userID == userID {
+			candidates = append(candidates, s)
+		}
+	}
+
+	if len(candidates) < over {
+		return errLimit
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].started < candidates[j].started
+	})
+
+	for _, s := range candidates[:over] {
+		if err := l.evicter.Evict(s.id); err != nil {
+			return fmt.Errorf("evicting stream %s: %w", s.id, err)
+		}
+
+		// Remove the stream now, so it is not counted again, before its
+		// instance closes it.
+		if err := l.backend.LimitRemove([]string{key}, s.encoded); err != nil {
+			return fmt.Errorf("removing evicted stream: %w", err)
+		}
+
+		icclog.Info(ctx, "Evicted stream", "stream_id", s.id, "key", key)
+	}
+
+	return nil
+}
+
+// refresh adds the open streams of this instance again, so they do not
+// expire.
+func (l *Limiter) refresh(ctx context.Context, errHandler func(error)) {
+	if errHandler == nil {
+		errHandler = func(error) {}
+	}
+
+	tick := time.NewTicker(refreshInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+
+		l.mu.Lock()
+		open := make(map[string][]string, len(l.open))
+		for s, keys := range l.open {
+			open[s] = keys
+		}
+		l.mu.Unlock()
+
+		expires := time.Now().Add(streamTTL)
+		for s, keys := range open {
+			if err := l.backend.LimitAdd(keys, s, expires); err != nil {
+				errHandler(fmt.Errorf("refreshing stream: %w", err))
+				break
+			}
+		}
+	}
+}
+
+// stream is a stream, how it is saved in the backend.
+type stream struct {
+	encoded string
+	started int64
+	userID  int
+	id      string
+}
+
+// encodeStream encodes the values of a stream, that are needed to find the
+// oldest stream of a user.
+func encodeStream(info connection.Info) string {
+	return fmt.Sprintf("%d/%d/%s", info.Started.UnixNano(), info.UserID, info.ID)
+}
+
+func decodeStream(encoded string) (stream, error) {
+	parts := strings.SplitN(encoded, "/", 3)
+	if len(parts) != 3 {
+		return stream{}, fmt.Errorf("expected three parts")
+	}
+
+	started, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return stream{}, fmt.Errorf("invalid start time: %w", err)
+	}
+
+	userID, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return stream{}, fmt.Errorf("invalid user id: %w", err)
+	}
+
+	return stream{
+		encoded: encoded,
+		started: started,
+		userID:  userID,
+		id:      parts[2],
+	}, nil
+}
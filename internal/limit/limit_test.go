@@ -0,0 +1,137 @@
+package limit_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/connection"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/limit"
+)
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	started := time.Now()
+
+	stream := func(id string, userID, meetingID int, age time.Duration) connection.Info {
+		return connection.Info{
+			ID:        id,
+			UserID:    userID,
+			MeetingID: meetingID,
+			Started:   started.Add(-age),
+		}
+	}
+
+	t.Run("Invalid mode", func(t *testing.T) {
+		if _, _, err := limit.New(newBackendStub(), new(evicterStub), 1, 1, "unknown"); err == nil {
+			t.Errorf("New with unknown mode did not return an error")
+		}
+	})
+
+	t.Run("Reject user", func(t *testing.T) {
+		l, _, _ := limit.New(newBackendStub(), new(evicterStub), 2, 0, limit.ModeReject)
+
+		for _, id := range []string{"a", "b"} {
+			if _, err := l.Acquire(ctx, stream(id, 1, 0, 0)); err != nil {
+				t.Fatalf("Acquire stream %s: %v", id, err)
+			}
+		}
+
+		_, err := l.Acquire(ctx, stream("c", 1, 0, 0))
+		if !errors.Is(err, iccerror.ErrLimit) {
+			t.Errorf("Got error `%v`, expected `%v`", err, iccerror.ErrLimit)
+		}
+
+		if _, err := l.Acquire(ctx, stream("d", 2, 0, 0)); err != nil {
+			t.Errorf("Acquire stream of other user: %v", err)
+		}
+	})
+
+	t.Run("Release", func(t *testing.T) {
+		l, _, _ := limit.New(newBackendStub(), new(evicterStub), 1, 0, limit.ModeReject)
+
+		release, err := l.Acquire(ctx, stream("a", 1, 0, 0))
+		if err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+		release()
+
+		if _, err := l.Acquire(ctx, stream("b", 1, 0, 0)); err != nil {
+			t.Errorf("Acquire after release: %v", err)
+		}
+	})
+
+	t.Run("Reject meeting", func(t *testing.T) {
+		l, _, _ := limit.New(newBackendStub(), new(evicterStub), 0, 2, limit.ModeReject)
+
+		for i, id := range []string{"a", "b"} {
+			if _, err := l.Acquire(ctx, stream(id, i+1, 7, 0)); err != nil {
+				t.Fatalf("Acquire stream %s: %v", id, err)
+			}
+		}
+
+		_, err := l.Acquire(ctx, stream("c", 3, 7, 0))
+		if !errors.Is(err, iccerror.ErrLimit) {
+			t.Errorf("Got error `%v`, expected `%v`", err, iccerror.ErrLimit)
+		}
+
+		if _, err := l.Acquire(ctx, stream("d", 3, 8, 0)); err != nil {
+			t.Errorf("Acquire stream in other meeting: %v", err)
+		}
+	})
+
+	t.Run("Evict oldest stream of user", func(t *testing.T) {
+		evicter := new(evicterStub)
+		l, _, _ := limit.New(newBackendStub(), evicter, 2, 0, limit.ModeEvict)
+
+		l.Acquire(ctx, stream("new", 1, 0, time.Minute))
+		l.Acquire(ctx, stream("old", 1, 0, time.Hour))
+
+		if _, err := l.Acquire(ctx, stream("newest", 1, 0, 0)); err != nil {
+			t.Fatalf("Acquire: %v", err)
+		}
+
+		if len(evicter.evicted) != 1 || evicter.evicted[0] != "old" {
+			t.Errorf("Evicted %v, expected [old]", evicter.evicted)
+		}
+	})
+
+	t.Run("Evict in meeting without own stream", func(t *testing.T) {
+		evicter := new(evicterStub)
+		l, _, _ := limit.New(newBackendStub(), evicter, 0, 1, limit.ModeEvict)
+
+		l.Acquire(ctx, stream("a", 1, 7, time.Minute))
+
+		_, err := l.Acquire(ctx, stream("b", 2, 7, 0))
+		if !errors.Is(err, iccerror.ErrLimit) {
+			t.Errorf("Got error `%v`, expected `%v`", err, iccerror.ErrLimit)
+		}
+
+		if len(evicter.evicted) != 0 {
+			t.Errorf("Evicted %v, expected nothing", evicter.evicted)
+		}
+	})
+
+	t.Run("Registry", func(t *testing.T) {
+		l, _, _ := limit.New(newBackendStub(), new(evicterStub), 1, 0, limit.ModeReject)
+		streams := new(connection.Registry)
+		streams.SetLimiter(l)
+
+		_, done, err := streams.Open(ctx, connection.Info{Kind: connection.KindNotify, UserID: 1})
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+
+		if _, _, err := streams.Open(ctx, connection.Info{Kind: connection.KindNotify, UserID: 1}); !errors.Is(err, iccerror.ErrLimit) {
+			t.Errorf("Second Open returned `%v`, expected `%v`", err, iccerror.ErrLimit)
+		}
+
+		done()
+
+		if _, _, err := streams.Open(ctx, connection.Info{Kind: connection.KindNotify, UserID: 1}); err != nil {
+			t.Errorf("Open after the first stream was closed: %v", err)
+		}
+	})
+}
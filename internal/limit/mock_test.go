@@ -0,0 +1,60 @@
+package limit_test
+
+import (
+	"sync"
+	"time"
+)
+
+type backendStub struct {
+	mu      sync.Mutex
+	streams map[string]map[string]time.Time
+}
+
+func newBackendStub() *backendStub {
+	return &backendStub{streams: make(map[string]map[string]time.Time)}
+}
+
+func (b *backendStub) LimitAdd(keys []string, stream string, expires time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, key := range keys {
+		if b.streams[key] == nil {
+			b.streams[key] = make(map[string]time.Time)
+		}
+		b.streams[key][stream] = expires
+	}
+	return nil
+}
+
+func (b *backendStub) LimitStreams(key string) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var out []string
+	for stream, expires := range b.streams[key] {
+		if expires.After(time.Now()) {
+			out = append(out, stream)
+		}
+	}
+	return out, nil
+}
+
+func (b *backendStub) LimitRemove(keys []string, stream string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, key := range keys {
+		delete(b.streams[key], stream)
+	}
+	return nil
+}
+
+type evicterStub struct {
+	evicted []string
+}
+
+func (e *evicterStub) Evict(streamID string) error {
+	e.evicted = append(e.evicted, streamID)
+	return nil
+}
@@ -0,0 +1,268 @@
+// Package memory implements the icc backend by keeping the data in memory.
+//
+// It can be used instead of redis, when only one instance of the service is
+// running.
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory implements the icc backend by keeping the data in memory.
+//
+// Has to be created with memory.New().
+type Memory struct {
+	notify *queue
+	admin  *queue
+
+	mu           sync.Mutex
+	applause     map[meetingUser]int64
+	adminStreams map[string][]byte
+	limits       map[string]map[string]time.Time
+}
+
+// meetingUser is the key of the applause.
+type meetingUser struct {
+	meetingID int
+	userID    int
+}
+
+// New creates a new initialized memory instance.
+func New() *Memory {
+	return &Memory{
+		notify:       newQueue(),
+		admin:        newQueue(),
+		applause:     make(map[meetingUser]int64),
+		adminStreams: make(map[string][]byte),
+		limits:       make(map[string]map[string]time.Time),
+	}
+}
+
+// NotifyPublish saves a valid notify message.
+func (m *Memory) NotifyPublish(message []byte) error {
+	m.notify.publish(message)
+	return nil
+}
+
+// NotifyReceive is a blocking function that receives the messages.
+//
+// The first call returnes all notify messages, that were published after New,
+// the next call the messages after them an so on. If there are no more
+// messages to read, the function blocks until there is or the context ist
+// canceled.
+//
+// It is expected, that only one goroutine is calling this function.
+func (m *Memory) NotifyReceive(ctx context.Context) ([][]byte, error) {
+	return m.notify.receive(ctx, 0)
+}
+
+// ApplausePublish saves an applause for the user at a given time as unix time
+// stamp.
+func (m *Memory) ApplausePublish(meetingID, userID int, time int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.applause[meetingUser{meetingID, userID}] = time
+	return nil
+}
+
+// ApplauseSince returned all applause since a given time as unix time stamp.
+func (m *Memory) ApplauseSince(time int64) (map[int]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[int]int)
+	for key, t := range m.applause {
+		if t >= time {
+			out[key.meetingID]++
+		}
+	}
+	return out, nil
+}
+
+// ApplauseCleanOld removes applause that is older then a given time.
+func (m *Memory) ApplauseCleanOld(olderThen int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, t := range m.applause {
+		if t < olderThen {
+			delete(m.applause, key)
+		}
+	}
+	return nil
+}
+
+// AdminSaveStreams saves the encoded streams of an instance.
+func (m *Memory) AdminSaveStreams(instance string, streams []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.adminStreams[instance] = streams
+	return nil
+}
+
+// AdminRegisterStreams saves the encoded streams of an instance, if the
+// instance is not saved yet.
+func (m *Memory) AdminRegisterStreams(instance string, streams []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.adminStreams[instance]; ok {
+		return false, nil
+	}
+	m.adminStreams[instance] = streams
+	return true, nil
+}
+
+// AdminStreams returns the saved streams of all instances.
+func (m *Memory) AdminStreams() (map[string][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[string][]byte, len(m.adminStreams))
+	for instance, streams := range m.adminStreams {
+		out[instance] = streams
+	}
+	return out, nil
+}
+
+// AdminRemoveStreams removes the saved streams of an instance.
+func (m *Memory) AdminRemoveStreams(instance string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.adminStreams, instance)
+	return nil
+}
+
+// AdminPublish saves an admin command.
+func (m *Memory) AdminPublish(command []byte) error {
+	m.admin.publish(command)
+	return nil
+}
+
+// AdminReceive is a blocking function that receives the admin commands, that
+// were published after New.
+//
+// It is expected, that only one goroutine is calling this function.
+func (m *Memory) AdminReceive(ctx context.Context) ([]byte, error) {
+	commands, err := m.admin.receive(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	return commands[0], nil
+}
+
+// LimitAdd adds a stream to each key with its expire time.
+func (m *Memory) LimitAdd(keys []string, stream string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		if m.limits[key] == nil {
+			m.limits[key] = make(map[string]time.Time)
+		}
+		m.limits[key][stream] = expires
+	}
+	return nil
+}
+
+// LimitStreams removes the expired streams of the key and returns the others
+// ordered by their expire time.
+func (m *Memory) LimitStreams(key string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	streams := make([]string, 0, len(m.limits[key]))
+	for stream, expires := range m.limits[key] {
+		if !expires.After(now) {
+			delete(m.limits[key], stream)
+			continue
+		}
+		streams = append(streams, stream)
+	}
+
+	if len(m.limits[key]) == 0 {
+		delete(m.limits, key)
+	}
+
+	sort.Slice(streams, func(i, j int) bool {
+		ei, ej := m.limits[key][streams[i]], m.limits[key][streams[j]]
+		if !ei.Equal(ej) {
+			return ei.Before(ej)
+		}
+		return streams[i] < streams[j]
+	})
+	return streams, nil
+}
+
+// LimitRemove removes a stream from the keys.
+func (m *Memory) LimitRemove(keys []string, stream string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.limits[key], stream)
+		if len(m.limits[key]) == 0 {
+			delete(m.limits, key)
+		}
+	}
+	return nil
+}
+
+// queue is a list of messages with one reader.
+//
+// A read message is removed from the queue.
+type queue struct {
+	mu       sync.Mutex
+	messages [][]byte
+
+	// changed is closed and replaced, when a message is added.
+	changed chan struct{}
+}
+
+func newQueue() *queue {
+	return &queue{changed: make(chan struct{})}
+}
+
+func (q *queue) publish(message []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.messages = append(q.messages, message)
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// receive returns the next messages, but not more then limit. If limit is 0,
+// all messages are returned. It blocks until there is a message or the
+// context is canceled.
+func (q *queue) receive(ctx context.Context, limit int) ([][]byte, error) {
+	for {
+		q.mu.Lock()
+		if len(q.messages) > 0 {
+			n := len(q.messages)
+			if limit > 0 && limit < n {
+				n = limit
+			}
+
+			messages := q.messages[:n:n]
+			q.messages = q.messages[n:]
+			q.mu.Unlock()
+			return messages, nil
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
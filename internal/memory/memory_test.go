@@ -0,0 +1,202 @@
+package memory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/backendtest"
+	"github.com/OpenSlides/openslides-icc-service/internal/memory"
+)
+
+func TestNotify(t *testing.T) {
+	backendtest.Notify(t, func(t *testing.T) backendtest.NotifyBackend {
+		return memory.New()
+	})
+}
+
+func TestApplause(t *testing.T) {
+	backendtest.Applause(t, func(t *testing.T) backendtest.ApplauseBackend {
+		return memory.New()
+	})
+}
+
+func TestICC(t *testing.T) {
+	backend := memory.New()
+
+	t.Run("Delete applause", func(t *testing.T) {
+		if err := backend.ApplausePublish(1, 1, 10); err != nil {
+			t.Fatalf("sending applause: %v", err)
+		}
+
+		if err := backend.ApplauseCleanOld(100); err != nil {
+			t.Fatalf("deleting old applause: %v", err)
+		}
+
+		applause, err := backend.ApplauseSince(10)
+
+		if err != nil {
+			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+		}
+
+		if len(applause) != 0 {
+			t.Errorf("receiveApplause returned %d, expected 0", applause)
+		}
+	})
+
+	t.Run("Delete not new applause", func(t *testing.T) {
+		defer backend.ApplauseCleanOld(1000)
+
+		if err := backend.ApplausePublish(1, 1, 10); err != nil {
+			t.Fatalf("sending applause: %v", err)
+		}
+
+		if err := backend.ApplauseCleanOld(10); err != nil {
+			t.Fatalf("deleting old applause: %v", err)
+		}
+
+		applause, err := backend.ApplauseSince(10)
+
+		if err != nil {
+			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+		}
+
+		if len(applause) != 1 {
+			t.Errorf("receiveApplause returned %d, expected 1", applause)
+		}
+	})
+
+	t.Run("Save and remove admin streams", func(t *testing.T) {
+		if err := backend.AdminSaveStreams("instance1", []byte("streams")); err != nil {
+			t.Fatalf("saving streams: %v", err)
+		}
+
+		streams, err := backend.AdminStreams()
+		if err != nil {
+			t.Fatalf("getting streams: %v", err)
+		}
+
+		if string(streams["instance1"]) != "streams" {
+			t.Errorf("got streams %q, expected `streams`", streams["instance1"])
+		}
+
+		if err := backend.AdminRemoveStreams("instance1"); err != nil {
+			t.Fatalf("removing streams: %v", err)
+		}
+
+		streams, err = backend.AdminStreams()
+		if err != nil {
+			t.Fatalf("getting streams: %v", err)
+		}
+
+		if _, ok := streams["instance1"]; ok {
+			t.Errorf("streams of instance1 were not removed")
+		}
+	})
+
+	t.Run("Register admin streams", func(t *testing.T) {
+		created, err := backend.AdminRegisterStreams("instance2", []byte("first"))
+		if err != nil {
+			t.Fatalf("registering streams: %v", err)
+		}
+
+		if !created {
+			t.Errorf("first registration returned false")
+		}
+
+		created, err = backend.AdminRegisterStreams("instance2", []byte("second"))
+		if err != nil {
+			t.Fatalf("registering streams again: %v", err)
+		}
+
+		if created {
+			t.Errorf("second registration returned true")
+		}
+
+		streams, err := backend.AdminStreams()
+		if err != nil {
+			t.Fatalf("getting streams: %v", err)
+		}
+
+		if string(streams["instance2"]) != "first" {
+			t.Errorf("got streams %q, expected `first`", streams["instance2"])
+		}
+
+		if err := backend.AdminRemoveStreams("instance2"); err != nil {
+			t.Fatalf("removing streams: %v", err)
+		}
+	})
+
+	t.Run("Receive admin command", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		type receiveReturn struct {
+			command []byte
+			err     error
+		}
+
+		done := make(chan receiveReturn)
+		go func() {
+			command, err := backend.AdminReceive(ctx)
+			done <- receiveReturn{command, err}
+		}()
+
+		// Wait for AdminReceive to be called.
+		time.Sleep(10 * time.Millisecond)
+
+		if err := backend.AdminPublish([]byte("my command")); err != nil {
+			t.Fatalf("publish command: %v", err)
+		}
+
+		timer := time.NewTimer(50 * time.Millisecond)
+		defer timer.Stop()
+
+		select {
+		case data := <-done:
+			if err := data.err; err != nil {
+				t.Errorf("AdminReceive returned unexpected error: %v", err)
+			}
+
+			if string(data.command) != "my command" {
+				t.Errorf("AdminReceive returned command `%s`, expected `my command`", data.command)
+			}
+
+		case <-timer.C:
+			t.Errorf("AdminReceive did not unblock after command was send.")
+		}
+	})
+
+	t.Run("Limit streams", func(t *testing.T) {
+		keys := []string{"user:1", "meeting:1"}
+		if err := backend.LimitAdd(keys, "stream1", time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("adding stream1: %v", err)
+		}
+
+		if err := backend.LimitAdd(keys, "expired", time.Now().Add(-time.Minute)); err != nil {
+			t.Fatalf("adding expired stream: %v", err)
+		}
+
+		streams, err := backend.LimitStreams("meeting:1")
+		if err != nil {
+			t.Fatalf("getting streams: %v", err)
+		}
+
+		if len(streams) != 1 || streams[0] != "stream1" {
+			t.Errorf("got streams %v, expected [stream1]", streams)
+		}
+
+		if err := backend.LimitRemove(keys, "stream1"); err != nil {
+			t.Fatalf("removing stream: %v", err)
+		}
+
+		streams, err = backend.LimitStreams("user:1")
+		if err != nil {
+			t.Fatalf("getting streams: %v", err)
+		}
+
+		if len(streams) != 0 {
+			t.Errorf("got streams %v, expected none", streams)
+		}
+	})
+}
@@ -9,22 +9,76 @@ import (
 	"time"
 )
 
+// maxChannelIDSize is the maximum length of a channel id. Longer ids are
+// invalid.
+const maxChannelIDSize = 512
+
 // channelID is an id for a notify channel.
+//
+// It has the form instance:uid:count. The channel ids come from the clients,
+// so they have to be parsed with care.
 type channelID string
 
+// parse returns the parts of the channel id. ok is false, if the channel id is
+// invalid.
+//
+// The instance must not be empty. The uid and the count have to be decimal
+// numbers without a sign.
+func (c channelID) parse() (instance string, uid int, ok bool) {
+	if len(c) > maxChannelIDSize {
+		return "", 0, false
+	}
+
+	instance, rest, found := strings.Cut(string(c), ":")
+	if !found || instance == "" {
+		return "", 0, false
+	}
+
+	uidStr, count, found := strings.Cut(rest, ":")
+	if !found || !isDigits(uidStr) || !isDigits(count) {
+		return "", 0, false
+	}
+
+	uid, err := strconv.Atoi(uidStr)
+	if err != nil {
+		return "", 0, false
+	}
+	return instance, uid, true
+}
+
 // uid returnes the user id that was used to create the channel id. Returns 0
 // for an invalid channel id.
 func (c channelID) uid() int {
-	parts := strings.Split(string(c), ":")
-	if len(parts) != 3 {
-		return 0
+	_, uid, _ := c.parse()
+	return uid
+}
+
+// instance returns the instance, that created the channel id. Returns an
+// empty string for an invalid channel id.
+func (c channelID) instance() string {
+	instance, _, _ := c.parse()
+	return instance
+}
+
+// isDigits returns true, if s is not empty and only contains the digits 0 to
+// 9.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
 	}
 
-	uid, err := strconv.Atoi(parts[1])
-	if err != nil {
-		return 0
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
 	}
-	return uid
+	return true
+}
+
+// ChannelInstance returns the instance, that created a channel id. Returns an
+// empty string for an invalid channel id.
+func ChannelInstance(cid string) string {
+	return channelID(cid).instance()
 }
 
 func (c channelID) String() string {
@@ -32,25 +86,41 @@ func (c channelID) String() string {
 }
 
 type cIDGen struct {
-	host    string
-	hostGen sync.Once
-
 	mu    sync.Mutex
+	host  string
 	count uint64
 }
 
 func (c *cIDGen) generate(uid int) channelID {
-	c.hostGen.Do(c.buildHostID)
-
 	c.mu.Lock()
+	if c.host == "" {
+		c.buildHostID()
+	}
+	host := c.host
 	count := c.count
 	c.count++
 	c.mu.Unlock()
 
-	cid := fmt.Sprintf("%s:%d:%d", c.host, uid, count)
+	cid := fmt.Sprintf("%s:%d:%d", host, uid, count)
 	return channelID(cid)
 }
 
+// setHost sets the first part of the channel ids. It has to be called before
+// the first channel id is generated. Without it, a random host is used.
+//
+// Returns an error, if the host is already fixed.
+func (c *cIDGen) setHost(host string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.host != "" {
+		return fmt.Errorf("host of the channel ids is already %s", c.host)
+	}
+
+	c.host = host
+	return nil
+}
+
 func (c *cIDGen) buildHostID() {
 	rand.Seed(time.Now().UnixNano())
 
@@ -1,6 +1,9 @@
 package notify
 
-import "testing"
+import (
+	"strings"
+	"testing"
+)
 
 func TestChannelID(t *testing.T) {
 	t.Run("Two cids are different", func(t *testing.T) {
@@ -30,12 +33,41 @@ func TestChannelID(t *testing.T) {
 		}
 	})
 
+	t.Run("cid with host", func(t *testing.T) {
+		cidgen := new(cIDGen)
+		if err := cidgen.setHost("icc-1-abc123"); err != nil {
+			t.Fatalf("setHost: %v", err)
+		}
+		cid := cidgen.generate(1)
+
+		if got := cid.instance(); got != "icc-1-abc123" {
+			t.Errorf("cid.instance() returned %q, expected icc-1-abc123", got)
+		}
+
+		if got := cid.uid(); got != 1 {
+			t.Errorf("cid.uid() returned %d, expected 1", got)
+		}
+	})
+
+	t.Run("set host after generate", func(t *testing.T) {
+		cidgen := new(cIDGen)
+		cidgen.generate(1)
+
+		if err := cidgen.setHost("icc-1-abc123"); err == nil {
+			t.Errorf("setHost returned no error")
+		}
+	})
+
 	t.Run("invalid cid no :", func(t *testing.T) {
 		cid := channelID("foobar")
 
 		if got := cid.uid(); got != 0 {
 			t.Errorf("cid.uid() returned %d, expected 0", got)
 		}
+
+		if got := cid.instance(); got != "" {
+			t.Errorf("cid.instance() returned %q, expected an empty string", got)
+		}
 	})
 
 	t.Run("invalid cid uid not a number", func(t *testing.T) {
@@ -46,4 +78,61 @@ func TestChannelID(t *testing.T) {
 		}
 	})
 
+	for _, tt := range []struct {
+		cid      string
+		instance string
+		uid      int
+		ok       bool
+	}{
+		{"icc:1:2", "icc", 1, true},
+		{"icc:0:0", "icc", 0, true},
+		{"icc:-1:2", "", 0, false},
+		{"icc:+1:2", "", 0, false},
+		{"icc:1:-2", "", 0, false},
+		{"icc:1:", "", 0, false},
+		{"icc::2", "", 0, false},
+		{":1:2", "", 0, false},
+		{"icc:1:2:3", "", 0, false},
+		{"icc:1", "", 0, false},
+		{"icc:99999999999999999999:1", "", 0, false},
+		{"icc:1:2\n", "", 0, false},
+	} {
+		t.Run("parse "+tt.cid, func(t *testing.T) {
+			instance, uid, ok := channelID(tt.cid).parse()
+
+			if instance != tt.instance || uid != tt.uid || ok != tt.ok {
+				t.Errorf("parse() returned (%q, %d, %t), expected (%q, %d, %t)", instance, uid, ok, tt.instance, tt.uid, tt.ok)
+			}
+		})
+	}
+}
+
+func FuzzChannelID(f *testing.F) {
+	f.Add("icc:1:2")
+	f.Add("icc-1-abc123:5:0")
+	f.Add("foo:bar:blub")
+	f.Add("::")
+	f.Add("a:1:2:3")
+
+	f.Fuzz(func(t *testing.T, cid string) {
+		instance, uid, ok := channelID(cid).parse()
+		if !ok {
+			if instance != "" || uid != 0 {
+				t.Errorf("invalid cid %q returned (%q, %d)", cid, instance, uid)
+			}
+			return
+		}
+
+		if instance == "" || strings.Contains(instance, ":") {
+			t.Errorf("cid %q returned invalid instance %q", cid, instance)
+		}
+
+		if uid < 0 {
+			t.Errorf("cid %q returned negative uid %d", cid, uid)
+		}
+
+		if !strings.HasPrefix(cid, instance+":") {
+			t.Errorf("cid %q does not start with the instance %q", cid, instance)
+		}
+	})
 }
@@ -1,17 +1,31 @@
 package notify
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/OpenSlides/openslides-icc-service/internal/connection"
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
 	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
 	"github.com/OpenSlides/openslides-icc-service/internal/icclog"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccmetric"
 )
 
+// pollTimeout is the maximum time a poll request blocks, if there are no
+// messages.
+const pollTimeout = 30 * time.Second
+
+// maxPublishSize is the maximum size of a notify message in bytes.
+const maxPublishSize = 1 << 20
+
 // Receiver is a type with the function Receive(). It is a blocking function
 // that writes the notify-messages to the writer as soon as they occur.
 type Receiver interface {
@@ -19,7 +33,10 @@ type Receiver interface {
 }
 
 // HandleReceive registers the notify route.
-func HandleReceive(mux *http.ServeMux, notify Receiver, auth icchttp.Authenticater) {
+//
+// Each stream is registered in the given registry. If the registry closes the
+// stream, the client gets the reason as last message.
+func HandleReceive(mux *http.ServeMux, notify Receiver, auth icchttp.Authenticater, streams *connection.Registry) {
 	url := icchttp.Path + "/notify"
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/octet-stream")
@@ -27,8 +44,7 @@ func HandleReceive(mux *http.ServeMux, notify Receiver, auth icchttp.Authenticat
 
 		uid := auth.FromContext(r.Context())
 		if uid == 0 {
-			w.WriteHeader(401)
-			icchttp.ErrorNoStatus(w, iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous user can not receive notify messages."))
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrUnauthorized, "Anonymous user can not receive notify messages."))
 			return
 		}
 
@@ -45,8 +61,21 @@ func HandleReceive(mux *http.ServeMux, notify Receiver, auth icchttp.Authenticat
 
 		cid, next := notify.Receive(meetingID, uid)
 
-		icclog.Debug("HTTP Recieve from user %d, channel id: %s", uid, cid)
-		defer icclog.Debug("Closed HTTP Recieve from user %d, channel id: %s", uid, cid)
+		ctx, done, err := streams.Open(r.Context(), connection.Info{
+			Kind:      connection.KindNotify,
+			UserID:    uid,
+			MeetingID: meetingID,
+			ChannelID: cid,
+		})
+		if err != nil {
+			icchttp.Error(w, fmt.Errorf("opening stream: %w", err))
+			return
+		}
+		defer done()
+
+		icclog.AddAttrs(r.Context(), "meeting_id", meetingID, "channel_id", cid)
+		icclog.Debug(r.Context(), "Notify stream opened")
+		defer icclog.Debug(r.Context(), "Notify stream closed")
 
 		// Send channel id.
 		if _, err := fmt.Fprintf(w, `{"channel_id": "%s"}`+"\n", cid); err != nil {
@@ -55,21 +84,44 @@ func HandleReceive(mux *http.ServeMux, notify Receiver, auth icchttp.Authenticat
 		}
 		w.(http.Flusher).Flush()
 
+		// Events like a degraded backend are written between the messages.
+		var writeMu sync.Mutex
+		stopEvents := connection.WriteEvents(ctx, &writeMu, func(event icchttp.StreamEvent) error {
+			return icchttp.WriteWithTimeout(w, streams.WriteTimeout(), func() error {
+				return icchttp.WriteEvent(w, event)
+			})
+		})
+		defer stopEvents()
+
 		encoder := json.NewEncoder(w)
 
 		for {
-			message, err := next(r.Context())
+			message, err := next(ctx)
 			if err != nil {
-				icchttp.ErrorNoStatus(w, fmt.Errorf("receiving message: %w", err))
+				stopEvents()
+				icchttp.StreamClose(ctx, w, fmt.Errorf("receiving message: %w", err))
 				return
 			}
 
-			if err := encoder.Encode(message); err != nil {
+			writeMu.Lock()
+			err = icchttp.WriteWithTimeout(w, streams.WriteTimeout(), func() error {
+				if err := encoder.Encode(message); err != nil {
+					return err
+				}
+				w.(http.Flusher).Flush()
+				return nil
+			})
+			writeMu.Unlock()
+			if err != nil {
+				if icchttp.IsWriteTimeout(err) {
+					iccmetric.SlowConsumer(iccmetric.KindNotify, iccmetric.SlowConsumerWriteTimeout)
+					icclog.Info(r.Context(), "Closed slow notify stream", "error", err)
+					return
+				}
 				icchttp.ErrorNoStatus(w, fmt.Errorf("sending message: %w", err))
 				return
 			}
-
-			w.(http.Flusher).Flush()
+			iccmetric.Delivered(iccmetric.KindNotify, 1)
 		}
 	})
 
@@ -81,7 +133,7 @@ func HandleReceive(mux *http.ServeMux, notify Receiver, auth icchttp.Authenticat
 
 // Publisher saves a notify message.
 type Publisher interface {
-	Publish(io.Reader, int) error
+	Publish(context.Context, io.Reader, int) error
 }
 
 // HandlePublish registers the notify/publish route.
@@ -92,12 +144,26 @@ func HandlePublish(mux *http.ServeMux, notify Publisher, auth icchttp.Authentica
 
 		uid := auth.FromContext(r.Context())
 		if uid == 0 {
-			w.WriteHeader(401)
-			icchttp.ErrorNoStatus(w, iccerror.NewMessageError(iccerror.ErrNotAllowed, "Anonymous user can not publish notify messages."))
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrUnauthorized, "Anonymous user can not publish notify messages."))
 			return
 		}
 
-		if err := notify.Publish(r.Body, uid); err != nil {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPublishSize))
+		if err != nil {
+			var errTooLarge *http.MaxBytesError
+			if errors.As(err, &errTooLarge) {
+				icchttp.Error(w, iccerror.NewDetailsError(
+					iccerror.ErrTooLarge,
+					map[string]any{"limit": errTooLarge.Limit},
+					"notify message is bigger then %d bytes", errTooLarge.Limit,
+				))
+				return
+			}
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "can not read body: %v", err))
+			return
+		}
+
+		if err := notify.Publish(r.Context(), bytes.NewReader(body), uid); err != nil {
 			icchttp.Error(w, fmt.Errorf("publish notify message: %w", err))
 			return
 		}
@@ -108,3 +174,115 @@ func HandlePublish(mux *http.ServeMux, notify Publisher, auth icchttp.Authentica
 		icchttp.AuthMiddleware(handler, auth),
 	)
 }
+
+// Poller returns notify messages for clients that can not use a stream.
+type Poller interface {
+	PollChannel(meetingID, uid int) (cid string, cursor uint64)
+	Poll(ctx context.Context, cid string, cursor uint64, uid int) (uint64, []OutMessage, error)
+}
+
+// HandlePoll registers the notify/poll route.
+//
+// It is a long polling fallback for the notify route. The first request
+// without a channel_id returns a new channel id and a cursor. Each following
+// request has to send both values and returns the messages since the cursor
+// and the cursor for the next request. If there are no messages, the request
+// blocks until there is one, the poll timeout is reached or the registry is
+// drained.
+func HandlePoll(mux *http.ServeMux, notify Poller, auth icchttp.Authenticater, streams *connection.Registry) {
+	url := icchttp.Path + "/notify/poll"
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store, max-age=0")
+
+		uid := auth.FromContext(r.Context())
+		if uid == 0 {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrUnauthorized, "Anonymous user can not receive notify messages."))
+			return
+		}
+
+		query := r.URL.Query()
+
+		response := struct {
+			ChannelID string       `json:"channel_id"`
+			Cursor    uint64       `json:"cursor"`
+			Messages  []OutMessage `json:"messages"`
+		}{
+			ChannelID: query.Get("channel_id"),
+			Messages:  []OutMessage{},
+		}
+
+		if response.ChannelID == "" {
+			meetingID := 0
+			if meetingStr := query.Get("meeting_id"); meetingStr != "" {
+				var err error
+				meetingID, err = strconv.Atoi(meetingStr)
+				if err != nil {
+					icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "url query meeting_id has to be an int"))
+					return
+				}
+			}
+
+			// A poll channel is not a stream, so it is not counted by the
+			// limiter of the streams.
+			response.ChannelID, response.Cursor = notify.PollChannel(meetingID, uid)
+			icclog.AddAttrs(r.Context(), "meeting_id", meetingID, "channel_id", response.ChannelID)
+			icclog.Debug(r.Context(), "Poll channel created")
+
+			if err := json.NewEncoder(w).Encode(response); err != nil {
+				icchttp.ErrorNoStatus(w, fmt.Errorf("sending response: %w", err))
+			}
+			return
+		}
+
+		icclog.AddAttrs(r.Context(), "channel_id", response.ChannelID)
+
+		cursor, err := strconv.ParseUint(query.Get("cursor"), 10, 64)
+		if err != nil {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrInvalid, "url query cursor has to be an unsigned int"))
+			return
+		}
+
+		ctx, cancelDrain := streams.WithDrain(r.Context())
+		defer cancelDrain()
+
+		ctx, cancel := context.WithTimeout(ctx, pollTimeout)
+		defer cancel()
+
+		newCursor, messages, err := notify.Poll(ctx, response.ChannelID, cursor, uid)
+		if err != nil {
+			if !isPollTimeout(r.Context(), err) {
+				icchttp.Error(w, fmt.Errorf("polling messages: %w", err))
+				return
+			}
+
+			// Timeout or drain without a message.
+			newCursor = cursor
+		}
+
+		response.Cursor = newCursor
+		if messages != nil {
+			response.Messages = messages
+		}
+
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			icchttp.ErrorNoStatus(w, fmt.Errorf("sending response: %w", err))
+			return
+		}
+		iccmetric.Delivered(iccmetric.KindNotify, len(messages))
+	})
+
+	mux.Handle(
+		url,
+		icchttp.AuthMiddleware(handler, auth),
+	)
+}
+
+// isPollTimeout returns true, if a poll request was stopped by the server and
+// not by the client.
+func isPollTimeout(requestCtx context.Context, err error) bool {
+	if requestCtx.Err() != nil {
+		return false
+	}
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
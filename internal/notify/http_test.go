@@ -3,22 +3,21 @@ package notify_test
 import (
 	"context"
 	"errors"
-	"log"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/OpenSlides/openslides-icc-service/internal/connection"
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
-	"github.com/OpenSlides/openslides-icc-service/internal/icclog"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
 	"github.com/OpenSlides/openslides-icc-service/internal/icctest"
 	"github.com/OpenSlides/openslides-icc-service/internal/notify"
 )
 
 func TestHandleReceive(t *testing.T) {
 	url := "/system/icc/notify"
-	icclog.SetInfoLogger(log.Default())
 
 	mp := newMessageProviderStub()
 
@@ -26,7 +25,7 @@ func TestHandleReceive(t *testing.T) {
 		auther := icctest.AutherStub{}
 		receiver := receiverStub{}
 		mux := http.NewServeMux()
-		notify.HandleReceive(mux, &receiver, &auther)
+		notify.HandleReceive(mux, &receiver, &auther, new(connection.Registry))
 		resp := httptest.NewRecorder()
 
 		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
@@ -35,8 +34,8 @@ func TestHandleReceive(t *testing.T) {
 			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
 		}
 
-		if !strings.Contains(resp.Body.String(), iccerror.ErrNotAllowed.Type()) {
-			t.Errorf("handler returned message `%s`, expected to contain `%s`", resp.Body.String(), iccerror.ErrNotAllowed.Type())
+		if !strings.Contains(resp.Body.String(), iccerror.ErrUnauthorized.Type()) {
+			t.Errorf("handler returned message `%s`, expected to contain `%s`", resp.Body.String(), iccerror.ErrUnauthorized.Type())
 		}
 
 		if receiver.called {
@@ -53,7 +52,7 @@ func TestHandleReceive(t *testing.T) {
 			UserID: 1,
 		}
 		mux := http.NewServeMux()
-		notify.HandleReceive(mux, &receiver, &auther)
+		notify.HandleReceive(mux, &receiver, &auther, new(connection.Registry))
 		resp := httptest.NewRecorder()
 
 		ctx, cancel := context.WithCancel(context.Background())
@@ -80,6 +79,39 @@ func TestHandleReceive(t *testing.T) {
 		}
 	})
 
+	t.Run("Broadcast event", func(t *testing.T) {
+		receiver := receiverStub{
+			cid: "mycid",
+			nm:  mp.Next,
+		}
+		auther := icctest.AutherStub{
+			UserID: 1,
+		}
+		streams := new(connection.Registry)
+		mux := http.NewServeMux()
+		notify.HandleReceive(mux, &receiver, &auther, streams)
+		resp := httptest.NewRecorder()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go func() {
+			for streams.Count() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			streams.Broadcast(icchttp.StreamEvent{Event: icchttp.EventDegraded})
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+		}()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil).WithContext(ctx))
+
+		expect := `{"channel_id": "mycid"}` + "\n" + `{"event":"degraded"}` + "\n"
+		if resp.Body.String() != expect {
+			t.Errorf("resp body is %q, expected %q", resp.Body.String(), expect)
+		}
+	})
+
 	t.Run("Receiver is called with meetingID", func(t *testing.T) {
 		receiver := receiverStub{
 			cid: "mycid",
@@ -89,7 +121,7 @@ func TestHandleReceive(t *testing.T) {
 			UserID: 1,
 		}
 		mux := http.NewServeMux()
-		notify.HandleReceive(mux, &receiver, &auther)
+		notify.HandleReceive(mux, &receiver, &auther, new(connection.Registry))
 		resp := httptest.NewRecorder()
 
 		ctx, cancel := context.WithCancel(context.Background())
@@ -130,7 +162,7 @@ func TestHandleReceive(t *testing.T) {
 			UserID: 1,
 		}
 		mux := http.NewServeMux()
-		notify.HandleReceive(mux, &receiver, &auther)
+		notify.HandleReceive(mux, &receiver, &auther, new(connection.Registry))
 		resp := httptest.NewRecorder()
 
 		ctx, cancel := context.WithCancel(context.Background())
@@ -167,7 +199,7 @@ func TestHandleReceive(t *testing.T) {
 			UserID: 1,
 		}
 		mux := http.NewServeMux()
-		notify.HandleReceive(mux, &receiver, &auther)
+		notify.HandleReceive(mux, &receiver, &auther, new(connection.Registry))
 		resp := httptest.NewRecorder()
 
 		ctx, cancel := context.WithCancel(context.Background())
@@ -199,7 +231,7 @@ func TestHandleReceive(t *testing.T) {
 			UserID: 1,
 		}
 		mux := http.NewServeMux()
-		notify.HandleReceive(mux, &receiver, &auther)
+		notify.HandleReceive(mux, &receiver, &auther, new(connection.Registry))
 		resp := httptest.NewRecorder()
 
 		ctx, cancel := context.WithCancel(context.Background())
@@ -221,6 +253,57 @@ func TestHandleReceive(t *testing.T) {
 			t.Errorf("handler did not return message: %s", resp.Body.String())
 		}
 	})
+	t.Run("Logout", func(t *testing.T) {
+		receiver := receiverStub{
+			cid: "mycid",
+			nm:  newMessageProviderStub().Next,
+		}
+		auther := icctest.AutherStub{
+			UserID: 1,
+			Logout: make(chan struct{}),
+		}
+		mux := http.NewServeMux()
+		notify.HandleReceive(mux, &receiver, &auther, new(connection.Registry))
+		resp := httptest.NewRecorder()
+
+		go func() {
+			time.Sleep(time.Millisecond)
+			close(auther.Logout)
+		}()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
+
+		if !strings.Contains(resp.Body.String(), iccerror.ErrUnauthorized.Type()) {
+			t.Errorf("handler did not send an error after logout: %s", resp.Body.String())
+		}
+	})
+
+	t.Run("Drain", func(t *testing.T) {
+		receiver := receiverStub{
+			cid: "mycid",
+			nm:  newMessageProviderStub().Next,
+		}
+		auther := icctest.AutherStub{
+			UserID: 1,
+		}
+		streams := new(connection.Registry)
+		mux := http.NewServeMux()
+		notify.HandleReceive(mux, &receiver, &auther, streams)
+		resp := httptest.NewRecorder()
+
+		go func() {
+			for streams.Count() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			streams.Drain(0)
+		}()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
+
+		if !strings.Contains(resp.Body.String(), `{"event":"reconnect"}`) {
+			t.Errorf("handler did not send a reconnect event: %s", resp.Body.String())
+		}
+	})
 }
 
 func TestHandleSend(t *testing.T) {
@@ -239,8 +322,8 @@ func TestHandleSend(t *testing.T) {
 			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
 		}
 
-		if !strings.Contains(resp.Body.String(), iccerror.ErrNotAllowed.Type()) {
-			t.Errorf("handler returned message `%s`, expected to contain `%s`", resp.Body.String(), iccerror.ErrNotAllowed.Type())
+		if !strings.Contains(resp.Body.String(), iccerror.ErrUnauthorized.Type()) {
+			t.Errorf("handler returned message `%s`, expected to contain `%s`", resp.Body.String(), iccerror.ErrUnauthorized.Type())
 		}
 
 		if sender.called {
@@ -294,4 +377,105 @@ func TestHandleSend(t *testing.T) {
 			t.Errorf("handler returned the error message: %s", resp.Body.String())
 		}
 	})
+
+	t.Run("Too large", func(t *testing.T) {
+		n, _ := notify.New(newBackendStrub(), 0)
+		auther := icctest.AutherStub{
+			UserID: 1,
+		}
+		mux := http.NewServeMux()
+		notify.HandlePublish(mux, n, &auther)
+		resp := httptest.NewRecorder()
+
+		body := `{"channel_id":"server:1:0","name":"big","message":"` + strings.Repeat("x", 2<<20) + `"}`
+		mux.ServeHTTP(resp, httptest.NewRequest("POST", url, strings.NewReader(body)))
+
+		if resp.Result().StatusCode != 413 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if !strings.Contains(resp.Body.String(), `"error":"too-large"`) {
+			t.Errorf("handler returned message `%s`, expected type too-large", resp.Body.String())
+		}
+	})
+}
+
+func TestHandlePoll(t *testing.T) {
+	url := "/system/icc/notify/poll"
+
+	t.Run("Anonymous", func(t *testing.T) {
+		auther := icctest.AutherStub{}
+		poller := pollerStub{}
+		mux := http.NewServeMux()
+		notify.HandlePoll(mux, &poller, &auther, new(connection.Registry))
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
+
+		if resp.Result().StatusCode != 401 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+	})
+
+	t.Run("New channel", func(t *testing.T) {
+		auther := icctest.AutherStub{UserID: 1}
+		poller := pollerStub{cid: "mycid", cursor: 5}
+		mux := http.NewServeMux()
+		notify.HandlePoll(mux, &poller, &auther, new(connection.Registry))
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url+"?meeting_id=7", nil))
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if poller.calledMeetingID != 7 {
+			t.Errorf("poller was called with meetingID %d, expected 7", poller.calledMeetingID)
+		}
+
+		expect := `{"channel_id":"mycid","cursor":5,"messages":[]}` + "\n"
+		if resp.Body.String() != expect {
+			t.Errorf("resp body is %q, expected %q", resp.Body.String(), expect)
+		}
+	})
+
+	t.Run("Poll messages", func(t *testing.T) {
+		auther := icctest.AutherStub{UserID: 1}
+		poller := pollerStub{
+			cursor:   6,
+			messages: []notify.OutMessage{{Name: "myname"}},
+		}
+		mux := http.NewServeMux()
+		notify.HandlePoll(mux, &poller, &auther, new(connection.Registry))
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url+"?channel_id=mycid&cursor=5", nil))
+
+		if resp.Result().StatusCode != 200 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+
+		if poller.calledCID != "mycid" || poller.calledCursor != 5 {
+			t.Errorf("poller was called with cid %q and cursor %d, expected mycid and 5", poller.calledCID, poller.calledCursor)
+		}
+
+		if !strings.Contains(resp.Body.String(), `"cursor":6`) || !strings.Contains(resp.Body.String(), "myname") {
+			t.Errorf("handler returned %s, expected cursor 6 and message myname", resp.Body.String())
+		}
+	})
+
+	t.Run("Invalid cursor", func(t *testing.T) {
+		auther := icctest.AutherStub{UserID: 1}
+		poller := pollerStub{}
+		mux := http.NewServeMux()
+		notify.HandlePoll(mux, &poller, &auther, new(connection.Registry))
+		resp := httptest.NewRecorder()
+
+		mux.ServeHTTP(resp, httptest.NewRequest("GET", url+"?channel_id=mycid&cursor=abc", nil))
+
+		if resp.Result().StatusCode != 400 {
+			t.Fatalf("handler returned status %s: %s", resp.Result().Status, resp.Body.String())
+		}
+	})
 }
@@ -2,6 +2,7 @@ package notify_test
 
 import (
 	"context"
+	"errors"
 	"io"
 
 	"github.com/OpenSlides/openslides-icc-service/internal/notify"
@@ -59,7 +60,7 @@ type publisherStub struct {
 	calledUserID int
 }
 
-func (s *publisherStub) Publish(r io.Reader, uid int) error {
+func (s *publisherStub) Publish(ctx context.Context, r io.Reader, uid int) error {
 	s.called = true
 	s.calledUserID = uid
 	return s.expectedErr
@@ -93,12 +94,57 @@ func (b *backendStub) NotifyPublish(bs []byte) error {
 	return nil
 }
 
-func (b *backendStub) NotifyReceive(ctx context.Context) (message []byte, err error) {
+func (b *backendStub) NotifyReceive(ctx context.Context) (messages [][]byte, err error) {
 	select {
 	case m := <-b.messages:
-		return m, nil
+		return [][]byte{m}, nil
 
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	}
 }
+
+type pollerStub struct {
+	cid      string
+	cursor   uint64
+	messages []notify.OutMessage
+	err      error
+
+	calledMeetingID int
+	calledCID       string
+	calledCursor    uint64
+}
+
+func (p *pollerStub) PollChannel(meetingID, uid int) (string, uint64) {
+	p.calledMeetingID = meetingID
+	return p.cid, p.cursor
+}
+
+func (p *pollerStub) Poll(ctx context.Context, cid string, cursor uint64, uid int) (uint64, []notify.OutMessage, error) {
+	p.calledCID = cid
+	p.calledCursor = cursor
+	return p.cursor, p.messages, p.err
+}
+
+// reconnectBackendStub fails on the first NotifyReceive. The next call
+// connects again and then blocks, because there are no messages.
+type reconnectBackendStub struct {
+	backendStub
+	calls     int
+	connected func()
+}
+
+func (b *reconnectBackendStub) SetNotifyConnected(f func()) {
+	b.connected = f
+}
+
+func (b *reconnectBackendStub) NotifyReceive(ctx context.Context) (messages [][]byte, err error) {
+	b.calls++
+	if b.calls == 1 {
+		return nil, errors.New("connection lost")
+	}
+
+	b.connected()
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
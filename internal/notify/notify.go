@@ -6,11 +6,18 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
 	"github.com/OpenSlides/openslides-icc-service/internal/icclog"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccmetric"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccstatus"
+	"github.com/OpenSlides/openslides-icc-service/internal/icctrace"
 	"github.com/ostcar/topic"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 // Backend stores the notify messages.
@@ -20,34 +27,78 @@ type Backend interface {
 
 	// NotifyReceive is a blocking function that receives the messages.
 	//
-	// The first call returnes the first notify message, the next call the
-	// second an so on. If there are no more messages to read, the function
-	// blocks until there is or the context ist canceled.
+	// The first call returnes the first notify messages, the next call the
+	// messages after them an so on. If there are no more messages to read,
+	// the function blocks until there is or the context ist canceled.
+	//
+	// The messages are returned in the order they were published. If the
+	// error is nil, there is at least one message.
 	//
 	// It is expected, that only one goroutine is calling this function. The
 	// Backend keeps track what the last send message was.
-	NotifyReceive(ctx context.Context) (message []byte, err error)
+	NotifyReceive(ctx context.Context) (messages [][]byte, err error)
+}
+
+// ConnectReporter is an optional interface of a Backend.
+//
+// NotifyReceive blocks until there is a message. So after an error, the
+// service would only see with the next message, that the backend works
+// again. A backend, that implements this interface, calls f each time
+// NotifyReceive connected to the backend.
+type ConnectReporter interface {
+	SetNotifyConnected(f func())
 }
 
+// pollChannelTimeout is the time after that a poll channel is removed, if it
+// was not polled.
+const pollChannelTimeout = 2 * time.Minute
+
+// The time to wait, before the backend is read again after an error. It is
+// doubled with each error up to the maximum.
+const (
+	listenBackoffMin = 500 * time.Millisecond
+	listenBackoffMax = 30 * time.Second
+)
+
+// errResync is returned by a message provider, that is too far behind the
+// topic.
+var errResync = icchttp.StreamEvent{Event: icchttp.EventResync}
+
 // Notify holds the state of the service.
 type Notify struct {
 	backend Backend
 	cIDGen  cIDGen
 	topic   *topic.Topic[string]
+	status  iccstatus.Loop
+	maxLag  uint64
+
+	pollMu       sync.Mutex
+	pollChannels map[channelID]*pollChannel
 }
 
 // New returns an initialized state of the notify service.
 //
+// maxLag is the number of messages a stream can be behind the topic. If a
+// stream falls further behind, it is closed with a resync event. 0 means no
+// limit.
+//
 // The New function is not blocking. The context is used to stop a goroutine
 // that is started by this function.
-func New(b Backend) (*Notify, func(context.Context, func(error))) {
+func New(b Backend, maxLag int) (*Notify, func(context.Context, func(error))) {
 	notify := Notify{
-		backend: b,
-		topic:   topic.New[string](),
+		backend:      b,
+		topic:        topic.New[string](),
+		maxLag:       uint64(maxLag),
+		pollChannels: make(map[channelID]*pollChannel),
+	}
+
+	if reporter, ok := b.(ConnectReporter); ok {
+		reporter.SetNotifyConnected(notify.status.Success)
 	}
 
 	background := func(ctx context.Context, errHandler func(error)) {
 		go notify.listen(ctx, errHandler)
+		go notify.prunePollChannels(ctx)
 	}
 
 	return &notify, background
@@ -60,21 +111,61 @@ func (n *Notify) listen(ctx context.Context, errhandler func(error)) {
 		errhandler = func(error) {}
 	}
 
+	backoff := iccstatus.Backoff{Min: listenBackoffMin, Max: listenBackoffMax}
 	for {
-		m, err := n.backend.NotifyReceive(ctx)
+		messages, err := n.backend.NotifyReceive(ctx)
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
 				return
 			}
 
-			errhandler(fmt.Errorf("receicing data from backend: %w", err))
-			time.Sleep(5 * time.Second)
+			err = fmt.Errorf("receicing data from backend: %w", err)
+			n.status.Failure(err)
+			iccmetric.BackendError(iccmetric.KindNotify)
+			errhandler(err)
+			if err := backoff.Wait(ctx); err != nil {
+				return
+			}
 			continue
 		}
+		n.status.Success()
+		backoff.Reset()
 
-		icclog.Debug("Found notify message: `%s`", m)
-		n.topic.Publish(string(m))
+		for _, m := range messages {
+			icclog.Debug(ctx, "Found notify message", "message", string(m))
+			n.traceListen(ctx, m)
+			n.topic.Publish(string(m))
+		}
+	}
+}
+
+// traceListen records a span for a message, that was received from the
+// backend.
+func (n *Notify) traceListen(ctx context.Context, m []byte) {
+	var message struct {
+		Trace map[string]string `json:"trace"`
+	}
+	if err := json.Unmarshal(m, &message); err != nil || message.Trace == nil {
+		return
 	}
+
+	_, span := icctrace.Start(icctrace.Extract(ctx, message.Trace), "notify.listen")
+	span.End()
+}
+
+// SetInstance sets the registered id of this instance. It is the first part of
+// each channel id, so other instances can see, where a channel was created.
+//
+// It has to be called before the first channel is created. Returns an error,
+// if a channel id was already created.
+func (n *Notify) SetInstance(instance string) error {
+	return n.cIDGen.setHost(instance)
+}
+
+// Status returns the state of the background loop, that receives the messages
+// from the backend.
+func (n *Notify) Status() *iccstatus.Loop {
+	return &n.status
 }
 
 // NextMessage is a function that can be called to get the next message.
@@ -91,13 +182,27 @@ func (n *Notify) Receive(meetingID, uid int) (cid string, nm NextMessage) {
 		meetingID: meetingID,
 		channelID: channelID,
 		topic:     n.topic,
+		maxLag:    n.maxLag,
 	}
 
 	return channelID.String(), mp.Next
 }
 
 // Publish reads and saves the notify event from the given reader.
-func (n *Notify) Publish(r io.Reader, uid int) error {
+//
+// The trace context from ctx is saved in the message, so the delivery can be
+// traced back to the publish call.
+func (n *Notify) Publish(ctx context.Context, r io.Reader, uid int) (err error) {
+	started := time.Now()
+
+	ctx, span := icctrace.Start(ctx, "notify.publish", attribute.Int("icc.user_id", uid))
+	defer func() {
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
 	var message Message
 	if err := json.NewDecoder(r).Decode(&message); err != nil {
 		return iccerror.NewMessageError(iccerror.ErrInvalid, "invalid json: %v", err)
@@ -107,21 +212,40 @@ func (n *Notify) Publish(r io.Reader, uid int) error {
 		return fmt.Errorf("validate message: %w", err)
 	}
 
+	span.SetAttributes(
+		attribute.String("icc.channel_id", message.ChannelID.String()),
+		attribute.String("icc.message_name", message.Name),
+	)
+
+	message.Trace = icctrace.Inject(ctx)
+
 	bs, err := json.Marshal(message)
 	if err != nil {
 		return fmt.Errorf("can not marshal notify message: %v", err)
 	}
 
-	icclog.Debug("Saving notify message: `%s`", bs)
-	if err := n.backend.NotifyPublish(bs); err != nil {
+	icclog.Debug(ctx, "Saving notify message", "message", string(bs))
+
+	_, backendSpan := icctrace.Start(ctx, "notify.backend.publish")
+	err = n.backend.NotifyPublish(bs)
+	backendSpan.End()
+	if err != nil {
 		return fmt.Errorf("saving message in backend: %w", err)
 	}
 
+	iccmetric.Published(iccmetric.KindNotify, started)
 	return nil
 }
 
+// TopicSize returns the number of messages in the topic.
+//
+// The topic is never pruned, so it is the id of the newest message.
+func (n *Notify) TopicSize() int {
+	return int(n.topic.LastID())
+}
+
 func validateMessage(message Message, userID int) error {
-	if message.ChannelID.uid() != userID {
+	if _, uid, ok := message.ChannelID.parse(); !ok || uid != userID {
 		return iccerror.NewMessageError(iccerror.ErrInvalid, "invalid channel id `%s`", message.ChannelID)
 	}
 
@@ -140,6 +264,19 @@ type Message struct {
 	ToChannels []string        `json:"to_channels,omitempty"`
 	Name       string          `json:"name"`
 	Message    json.RawMessage `json:"message"`
+
+	// Trace is the trace context of the publish call. It is set by the
+	// server.
+	Trace map[string]string `json:"trace,omitempty"`
+}
+
+// WithChannelID returns a copy of the message with the given channel id of
+// the sender.
+//
+// It is used by clients, that get the channel id from the server.
+func (m Message) WithChannelID(cid string) Message {
+	m.ChannelID = channelID(cid)
+	return m
 }
 
 func (m Message) forMe(meetingID, uid int, cID channelID) bool {
@@ -178,14 +315,30 @@ type messageProvider struct {
 
 	topic      *topic.Topic[string]
 	messageBuf []string
+	maxLag     uint64
+}
+
+// lag returns the number of messages in the topic, that the provider has not
+// fetched yet.
+//
+// The messages in messageBuf are not counted. They are already fetched and
+// most of them are for other receivers.
+func (mp *messageProvider) lag() uint64 {
+	return mp.topic.LastID() - mp.tid
 }
 
 // Next returns the next message. Can be called many times.
+//
+// If the provider has to fetch new messages and is more then maxLag messages
+// behind the topic, a resync event is returned as error.
 func (mp *messageProvider) Next(ctx context.Context) (OutMessage, error) {
-	var message Message
-
 	for {
 		if len(mp.messageBuf) == 0 {
+			if mp.maxLag > 0 && mp.lag() > mp.maxLag {
+				iccmetric.SlowConsumer(iccmetric.KindNotify, iccmetric.SlowConsumerLag)
+				return OutMessage{}, errResync
+			}
+
 			tid, messages, err := mp.topic.Receive(ctx, mp.tid)
 			if err != nil {
 				return OutMessage{}, fmt.Errorf("fetching message from topic: %w", err)
@@ -198,14 +351,40 @@ func (mp *messageProvider) Next(ctx context.Context) (OutMessage, error) {
 		m := mp.messageBuf[0]
 		mp.messageBuf = mp.messageBuf[1:]
 
-		if err := json.Unmarshal([]byte(m), &message); err != nil {
-			return OutMessage{}, fmt.Errorf("decoding message: %w", err)
+		out, ok, err := decodeForMe(ctx, m, mp.meetingID, mp.uid, mp.channelID)
+		if err != nil {
+			return OutMessage{}, err
 		}
 
-		if message.forMe(mp.meetingID, mp.uid, mp.channelID) {
-			break
+		if ok {
+			return out, nil
 		}
 	}
+}
+
+// decodeForMe decodes a message from the topic. Returns false, if the message
+// is not for the given receiver.
+//
+// For each message, that is for the receiver, a span is recorded as child of
+// the publish span.
+func decodeForMe(ctx context.Context, m string, meetingID, uid int, cID channelID) (OutMessage, bool, error) {
+	var message Message
+	if err := json.Unmarshal([]byte(m), &message); err != nil {
+		return OutMessage{}, false, fmt.Errorf("decoding message: %w", err)
+	}
+
+	if !message.forMe(meetingID, uid, cID) {
+		return OutMessage{}, false, nil
+	}
+
+	_, span := icctrace.Start(
+		icctrace.Extract(ctx, message.Trace),
+		"notify.deliver",
+		attribute.String("icc.channel_id", cID.String()),
+		attribute.Int("icc.user_id", uid),
+		attribute.Int("icc.meeting_id", meetingID),
+	)
+	span.End()
 
 	out := OutMessage{
 		message.ChannelID.uid(),
@@ -214,5 +393,112 @@ func (mp *messageProvider) Next(ctx context.Context) (OutMessage, error) {
 		message.Message,
 	}
 
-	return out, nil
+	return out, true, nil
+}
+
+// pollChannel is the state of a channel, that receives its messages with
+// polling.
+type pollChannel struct {
+	uid       int
+	meetingID int
+	lastPoll  time.Time
+}
+
+// PollChannel creates a new channel for polling.
+//
+// Returns the channel id and the cursor for the first call to Poll().
+func (n *Notify) PollChannel(meetingID, uid int) (cid string, cursor uint64) {
+	channelID := n.cIDGen.generate(uid)
+
+	n.pollMu.Lock()
+	n.pollChannels[channelID] = &pollChannel{
+		uid:       uid,
+		meetingID: meetingID,
+		lastPoll:  time.Now(),
+	}
+	n.pollMu.Unlock()
+
+	return channelID.String(), n.topic.LastID()
+}
+
+// Poll returns all messages for a poll channel since the cursor and the cursor
+// for the next call.
+//
+// If there are no messages, Poll blocks until there is at least one message or
+// the context is done.
+func (n *Notify) Poll(ctx context.Context, cid string, cursor uint64, uid int) (uint64, []OutMessage, error) {
+	channelID := channelID(cid)
+
+	n.pollMu.Lock()
+	pc, ok := n.pollChannels[channelID]
+	if ok {
+		pc.lastPoll = time.Now()
+	}
+	n.pollMu.Unlock()
+
+	if !ok {
+		return 0, nil, iccerror.NewMessageError(iccerror.ErrNotFound, "unknown poll channel `%s`. Please create a new one.", cid)
+	}
+
+	if pc.uid != uid {
+		return 0, nil, iccerror.NewMessageError(iccerror.ErrNotAllowed, "poll channel `%s` belongs to another user.", cid)
+	}
+
+	defer func() {
+		n.pollMu.Lock()
+		pc.lastPoll = time.Now()
+		n.pollMu.Unlock()
+	}()
+
+	for {
+		tid, messages, err := n.topic.Receive(ctx, cursor)
+		if err != nil {
+			var errUnknownID topic.UnknownIDError
+			if errors.As(err, &errUnknownID) {
+				return 0, nil, iccerror.NewMessageError(iccerror.ErrInvalid, "unknown cursor %d", cursor)
+			}
+			return 0, nil, fmt.Errorf("fetching messages from topic: %w", err)
+		}
+		cursor = tid
+
+		var out []OutMessage
+		for _, m := range messages {
+			message, ok, err := decodeForMe(ctx, m, pc.meetingID, pc.uid, channelID)
+			if err != nil {
+				return 0, nil, err
+			}
+
+			if ok {
+				out = append(out, message)
+			}
+		}
+
+		if len(out) > 0 {
+			return cursor, out, nil
+		}
+	}
+}
+
+// prunePollChannels removes poll channels, that where not polled for some
+// time.
+func (n *Notify) prunePollChannels(ctx context.Context) {
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			tooOld := time.Now().Add(-pollChannelTimeout)
+
+			n.pollMu.Lock()
+			for cid, pc := range n.pollChannels {
+				if pc.lastPoll.Before(tooOld) {
+					delete(n.pollChannels, cid)
+				}
+			}
+			n.pollMu.Unlock()
+		}
+	}
 }
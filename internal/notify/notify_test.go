@@ -2,13 +2,21 @@ package notify_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
 	"github.com/OpenSlides/openslides-icc-service/internal/notify"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 func TestSend(t *testing.T) {
@@ -16,13 +24,13 @@ func TestSend(t *testing.T) {
 	defer cancel()
 
 	backend := newBackendStrub()
-	n, bg := notify.New(backend)
+	n, bg := notify.New(backend, 0)
 	go bg(shutdownCtx, nil)
 
 	t.Run("invalid json", func(t *testing.T) {
 		defer backend.reset()
 
-		err := n.Publish(strings.NewReader(`{123`), 1)
+		err := n.Publish(context.Background(), strings.NewReader(`{123`), 1)
 
 		if !errors.Is(err, iccerror.ErrInvalid) {
 			t.Errorf("send() returned err `%s`, expected `%s`", err, iccerror.ErrInvalid.Error())
@@ -32,7 +40,7 @@ func TestSend(t *testing.T) {
 	t.Run("invalid format", func(t *testing.T) {
 		defer backend.reset()
 
-		err := n.Publish(strings.NewReader(`{"to_users":1,"message":"hans"}`), 1)
+		err := n.Publish(context.Background(), strings.NewReader(`{"to_users":1,"message":"hans"}`), 1)
 
 		if !errors.Is(err, iccerror.ErrInvalid) {
 			t.Errorf("send() returned err `%s`, expected `%s`", err, iccerror.ErrInvalid.Error())
@@ -42,7 +50,7 @@ func TestSend(t *testing.T) {
 	t.Run("no channel_id", func(t *testing.T) {
 		defer backend.reset()
 
-		err := n.Publish(strings.NewReader(`
+		err := n.Publish(context.Background(), strings.NewReader(`
 		{
 			"to_users": [2], 
 			"message": "hans"
@@ -57,7 +65,7 @@ func TestSend(t *testing.T) {
 	t.Run("invalid channel_id", func(t *testing.T) {
 		defer backend.reset()
 
-		err := n.Publish(strings.NewReader(`
+		err := n.Publish(context.Background(), strings.NewReader(`
 		{
 			"channel_id": "abc",
 			"to_users": [2], 
@@ -72,7 +80,7 @@ func TestSend(t *testing.T) {
 	t.Run("no Name", func(t *testing.T) {
 		defer backend.reset()
 
-		err := n.Publish(strings.NewReader(`
+		err := n.Publish(context.Background(), strings.NewReader(`
 		{
 			"channel_id": "server:1:2",
 			"to_users": [2], 
@@ -87,7 +95,7 @@ func TestSend(t *testing.T) {
 	t.Run("valid", func(t *testing.T) {
 		defer backend.reset()
 
-		err := n.Publish(strings.NewReader(`
+		err := n.Publish(context.Background(), strings.NewReader(`
 		{
 			"channel_id": "server:1:2",
 			"name": "message-name",
@@ -110,18 +118,66 @@ func TestSend(t *testing.T) {
 	})
 }
 
+func FuzzPublish(f *testing.F) {
+	f.Add(`{"channel_id":"server:1:2","name":"message-name","to_users":[2],"message":"hans"}`)
+	f.Add(`{"channel_id":"server:1:2","name":"to-meeting-name","to_meeting":1,"message":{"a":[1,2]}}`)
+	f.Add(`{"channel_id":"server:2:2","name":"other-user","message":null}`)
+	f.Add(`{"channel_id":"abc","name":"n"}`)
+	f.Add(`{"to_users":1,"message":"hans"}`)
+	f.Add(`{123`)
+
+	backend := newBackendStrub()
+	n, _ := notify.New(backend, 0)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		defer backend.reset()
+
+		err := n.Publish(context.Background(), strings.NewReader(body), 1)
+		if err != nil {
+			if !errors.Is(err, iccerror.ErrInvalid) {
+				t.Errorf("Publish returned error `%v`, expected ErrInvalid", err)
+			}
+
+			if len(backend.receivedMessages) != 0 {
+				t.Errorf("Publish returned an error, but the backend received %d messages", len(backend.receivedMessages))
+			}
+			return
+		}
+
+		if len(backend.receivedMessages) != 1 {
+			t.Fatalf("backend received %d messages, expected 1", len(backend.receivedMessages))
+		}
+
+		var got struct {
+			ChannelID string `json:"channel_id"`
+			Name      string `json:"name"`
+		}
+		if err := json.Unmarshal(backend.receivedMessages[0], &got); err != nil {
+			t.Fatalf("backend received invalid json `%s`: %v", backend.receivedMessages[0], err)
+		}
+
+		if got.Name == "" {
+			t.Errorf("backend received message without a name: %s", backend.receivedMessages[0])
+		}
+
+		if parts := strings.Split(got.ChannelID, ":"); len(parts) != 3 || strings.TrimLeft(parts[1], "0") != "1" {
+			t.Errorf("backend received message with channel id `%s`, expected one of user 1", got.ChannelID)
+		}
+	})
+}
+
 func TestReceive(t *testing.T) {
 	shutdownCtx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	backend := newBackendStrub()
-	n, bg := notify.New(backend)
+	n, bg := notify.New(backend, 0)
 	go bg(shutdownCtx, nil)
 
 	_, next := n.Receive(1, 2)
 
 	t.Run("Get first message", func(t *testing.T) {
-		if err := n.Publish(strings.NewReader(`{"channel_id":"server:1:2","name":"message-name","to_users":[2],"message":"hans"}`), 1); err != nil {
+		if err := n.Publish(context.Background(), strings.NewReader(`{"channel_id":"server:1:2","name":"message-name","to_users":[2],"message":"hans"}`), 1); err != nil {
 			t.Fatalf("sending message: %v", err)
 		}
 
@@ -148,7 +204,7 @@ func TestReceive(t *testing.T) {
 	})
 
 	t.Run("Message for meeting", func(t *testing.T) {
-		if err := n.Publish(strings.NewReader(`{"channel_id":"server:1:2","name":"to-meeting-name","to_meeting":1,"message":"klaus"}`), 1); err != nil {
+		if err := n.Publish(context.Background(), strings.NewReader(`{"channel_id":"server:1:2","name":"to-meeting-name","to_meeting":1,"message":"klaus"}`), 1); err != nil {
 			t.Fatalf("sending message: %v", err)
 		}
 
@@ -167,7 +223,7 @@ func TestReceive(t *testing.T) {
 	})
 
 	t.Run("Message not for me", func(t *testing.T) {
-		if err := n.Publish(strings.NewReader(`{"channel_id":"server:1:2","name":"message-name","to_users":[3],"message":"hans"}`), 1); err != nil {
+		if err := n.Publish(context.Background(), strings.NewReader(`{"channel_id":"server:1:2","name":"message-name","to_users":[3],"message":"hans"}`), 1); err != nil {
 			t.Fatalf("sending message: %v", err)
 		}
 
@@ -189,3 +245,233 @@ func TestReceive(t *testing.T) {
 		}
 	})
 }
+
+func TestReceiveLag(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := newBackendStrub()
+	n, bg := notify.New(backend, 2)
+	go bg(shutdownCtx, nil)
+
+	t.Run("Too many messages", func(t *testing.T) {
+		_, next := n.Receive(1, 2)
+		publishToUser(t, n, 3)
+
+		_, err := next(context.Background())
+
+		var event icchttp.StreamEvent
+		if !errors.As(err, &event) || event.Event != icchttp.EventResync {
+			t.Errorf("Next() returned `%v`, expected a resync event", err)
+		}
+	})
+
+	t.Run("Fetched messages are not counted", func(t *testing.T) {
+		_, next := n.Receive(1, 2)
+		publishToUser(t, n, 2)
+
+		// Fetches both messages and returns the first.
+		if _, err := next(context.Background()); err != nil {
+			t.Fatalf("first Next(): %v", err)
+		}
+
+		// The provider is two messages behind the topic and has one message
+		// in its buffer.
+		publishToUser(t, n, 2)
+
+		for i := range 3 {
+			if _, err := next(context.Background()); err != nil {
+				t.Fatalf("Next() %d: %v", i, err)
+			}
+		}
+	})
+}
+
+// publishToUser publishes count messages to user 2 and waits until they are in
+// the topic.
+func publishToUser(t *testing.T, n *notify.Notify, count int) {
+	t.Helper()
+
+	sizeBefore := n.TopicSize()
+	for i := range count {
+		message := fmt.Sprintf(`{"channel_id":"server:1:2","name":"message-name","to_users":[2],"message":%d}`, i)
+		if err := n.Publish(context.Background(), strings.NewReader(message), 1); err != nil {
+			t.Fatalf("sending message: %v", err)
+		}
+	}
+
+	for start := time.Now(); n.TopicSize() < sizeBefore+count; {
+		if time.Since(start) > time.Second {
+			t.Fatalf("messages did not arrive in the topic")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestListenReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n, bg := notify.New(&reconnectBackendStub{}, 0)
+	go bg(ctx, nil)
+
+	// The backend fails once. Then it works again without a new message.
+	deadline := time.Now().Add(2 * time.Second)
+	failed := false
+	for {
+		if _, err := n.Status().State(); err != nil {
+			failed = true
+		}
+
+		if failed && n.Status().FailingSince().IsZero() {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("status did not recover after the reconnect (failed before: %t)", failed)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := newBackendStrub()
+	n, bg := notify.New(backend, 0)
+	go bg(shutdownCtx, nil)
+
+	cid, cursor := n.PollChannel(1, 2)
+
+	t.Run("Unknown channel", func(t *testing.T) {
+		_, _, err := n.Poll(context.Background(), "unknown:2:0", cursor, 2)
+
+		if !errors.Is(err, iccerror.ErrNotFound) {
+			t.Errorf("Poll returned err `%v`, expected `%v`", err, iccerror.ErrNotFound)
+		}
+	})
+
+	t.Run("Channel of other user", func(t *testing.T) {
+		_, _, err := n.Poll(context.Background(), cid, cursor, 3)
+
+		if !errors.Is(err, iccerror.ErrNotAllowed) {
+			t.Errorf("Poll returned err `%v`, expected `%v`", err, iccerror.ErrNotAllowed)
+		}
+	})
+
+	t.Run("Get messages since cursor", func(t *testing.T) {
+		if err := n.Publish(context.Background(), strings.NewReader(`{"channel_id":"server:1:2","name":"first","to_users":[2],"message":"hans"}`), 1); err != nil {
+			t.Fatalf("sending message: %v", err)
+		}
+
+		newCursor, messages, err := n.Poll(context.Background(), cid, cursor, 2)
+		if err != nil {
+			t.Fatalf("Poll returned: %v", err)
+		}
+
+		if len(messages) != 1 || messages[0].Name != "first" {
+			t.Errorf("Poll returned messages %v, expected one message with name first", messages)
+		}
+
+		if newCursor <= cursor {
+			t.Errorf("Poll returned cursor %d, expected a cursor bigger then %d", newCursor, cursor)
+		}
+
+		// Polling with the same cursor again returns the same messages.
+		_, messages, err = n.Poll(context.Background(), cid, cursor, 2)
+		if err != nil {
+			t.Fatalf("Poll returned: %v", err)
+		}
+
+		if len(messages) != 1 || messages[0].Name != "first" {
+			t.Errorf("second Poll returned messages %v, expected one message with name first", messages)
+		}
+
+		cursor = newCursor
+	})
+
+	t.Run("Message for meeting", func(t *testing.T) {
+		if err := n.Publish(context.Background(), strings.NewReader(`{"channel_id":"server:1:2","name":"not-for-me","to_users":[3],"message":"hans"}`), 1); err != nil {
+			t.Fatalf("sending message: %v", err)
+		}
+
+		if err := n.Publish(context.Background(), strings.NewReader(`{"channel_id":"server:1:2","name":"to-meeting","to_meeting":1,"message":"klaus"}`), 1); err != nil {
+			t.Fatalf("sending message: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		newCursor, messages, err := n.Poll(ctx, cid, cursor, 2)
+		if err != nil {
+			t.Fatalf("Poll returned: %v", err)
+		}
+		cursor = newCursor
+
+		if len(messages) != 1 || messages[0].Name != "to-meeting" {
+			t.Errorf("Poll returned messages %v, expected one message with name to-meeting", messages)
+		}
+	})
+
+	t.Run("Blocks without message", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, _, err := n.Poll(ctx, cid, cursor, 2)
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Poll returned err `%v`, expected `%v`", err, context.DeadlineExceeded)
+		}
+	})
+}
+
+func TestTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	shutdownCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := newBackendStrub()
+	n, bg := notify.New(backend, 0)
+	go bg(shutdownCtx, nil)
+
+	_, next := n.Receive(1, 2)
+
+	if err := n.Publish(context.Background(), strings.NewReader(`{"channel_id":"server:1:2","name":"message-name","to_users":[2],"message":"hans"}`), 1); err != nil {
+		t.Fatalf("sending message: %v", err)
+	}
+
+	if !strings.Contains(string(backend.receivedMessages[0]), `"trace":{"traceparent"`) {
+		t.Errorf("saved message does not contain the trace context: %s", backend.receivedMessages[0])
+	}
+
+	if _, err := next(context.Background()); err != nil {
+		t.Fatalf("Next() returned: %v", err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	publishSpan, ok := spans["notify.publish"]
+	if !ok {
+		t.Fatalf("no publish span recorded")
+	}
+
+	for _, name := range []string{"notify.backend.publish", "notify.listen", "notify.deliver"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span recorded", name)
+			continue
+		}
+
+		if span.Parent().SpanID() != publishSpan.SpanContext().SpanID() {
+			t.Errorf("span %s is not a child of the publish span", name)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Package permission contains permission checks, that are used by more then
+// one part of the service.
+package permission
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/peb-adr/openslides-go/datastore/dsfetch"
+)
+
+// IsSuperadmin returns true, if the user has the organization management level
+// superadmin.
+func IsSuperadmin(ctx context.Context, ds *dsfetch.Fetch, userID int) (bool, error) {
+	if userID == 0 {
+		return false, nil
+	}
+
+	oml, err := ds.User_OrganizationManagementLevel(userID).Value(ctx)
+	if err != nil {
+		return false, fmt.Errorf("getting oml of user %d: %w", userID, err)
+	}
+
+	return oml == "superadmin", nil
+}
+
+// InMeeting returns true, if the user is part of the meeting. Superadmins are
+// part of every meeting.
+func InMeeting(ctx context.Context, fetch *dsfetch.Fetch, userID, meetingID int) (bool, error) {
+	superadmin, err := IsSuperadmin(ctx, fetch, userID)
+	if err != nil {
+		return false, fmt.Errorf("checking for superadmin: %w", err)
+	}
+
+	if superadmin {
+		return true, nil
+	}
+
+	meetingUserIDs, err := fetch.User_MeetingUserIDs(userID).Value(ctx)
+	if err != nil {
+		return false, fmt.Errorf("getting meeting user ids: %w", err)
+	}
+
+	meetingIDs := make([]int, len(meetingUserIDs))
+	for i := 0; i < len(meetingUserIDs); i++ {
+		fetch.MeetingUser_MeetingID(meetingUserIDs[i]).Lazy(&meetingIDs[i])
+	}
+
+	if err := fetch.Execute(ctx); err != nil {
+		return false, fmt.Errorf("getting meeting IDs from user %d: %w", userID, err)
+	}
+
+	for _, mid := range meetingIDs {
+		if mid == meetingID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,41 @@
+package permission_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/permission"
+	"github.com/peb-adr/openslides-go/datastore/dsfetch"
+	"github.com/peb-adr/openslides-go/datastore/dsmock"
+)
+
+func TestIsSuperadmin(t *testing.T) {
+	ctx := context.Background()
+	ds := dsmock.Stub(dsmock.YAMLData(`---
+	user/1/organization_management_level: superadmin
+	user/2/organization_management_level: can_manage_users
+	user/3/id: 3
+	`))
+
+	for _, tt := range []struct {
+		name   string
+		userID int
+		expect bool
+	}{
+		{"superadmin", 1, true},
+		{"other level", 2, false},
+		{"no level", 3, false},
+		{"anonymous", 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := permission.IsSuperadmin(ctx, dsfetch.New(ds), tt.userID)
+			if err != nil {
+				t.Fatalf("IsSuperadmin: %v", err)
+			}
+
+			if got != tt.expect {
+				t.Errorf("IsSuperadmin() = %t, expected %t", got, tt.expect)
+			}
+		})
+	}
+}
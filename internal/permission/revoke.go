@@ -0,0 +1,111 @@
+package permission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/connection"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/icclog"
+	"github.com/peb-adr/openslides-go/datastore/dsfetch"
+	"github.com/peb-adr/openslides-go/datastore/dskey"
+	"github.com/peb-adr/openslides-go/datastore/flow"
+)
+
+// Revoker closes the streams of users, that are not allowed to see their
+// meeting anymore.
+type Revoker struct {
+	datastore flow.Getter
+	streams   *connection.Registry
+}
+
+// NewRevoker initializes a Revoker.
+func NewRevoker(db flow.Getter, streams *connection.Registry) *Revoker {
+	return &Revoker{
+		datastore: db,
+		streams:   streams,
+	}
+}
+
+// Update checks the open streams, that are affected by the changed keys from
+// the datastore. Streams, that are not allowed anymore, are closed with an
+// error.
+//
+// It has to be called after the datastore was updated.
+func (r *Revoker) Update(ctx context.Context, data map[dskey.Key][]byte) error {
+	users := make(map[int]bool)
+	meetings := make(map[int]bool)
+	for key := range data {
+		switch key.CollectionField() {
+		case "user/meeting_user_ids", "user/meeting_ids", "user/organization_management_level":
+			users[key.ID()] = true
+		case "meeting/enable_anonymous", "meeting/id":
+			meetings[key.ID()] = true
+		}
+	}
+
+	if len(users) == 0 && len(meetings) == 0 {
+		return nil
+	}
+
+	type userMeeting struct {
+		userID    int
+		meetingID int
+	}
+
+	var errs []error
+	checked := make(map[userMeeting]bool)
+	for _, info := range r.streams.List() {
+		if info.MeetingID == 0 || !(users[info.UserID] || meetings[info.MeetingID]) {
+			continue
+		}
+
+		um := userMeeting{info.UserID, info.MeetingID}
+		if _, ok := checked[um]; ok {
+			continue
+		}
+
+		allowed, err := r.canSeeMeeting(ctx, um.userID, um.meetingID)
+		if err != nil {
+			var errDoesNotExist dsfetch.DoesNotExistError
+			if !errors.As(err, &errDoesNotExist) {
+				errs = append(errs, fmt.Errorf("checking user %d in meeting %d: %w", um.userID, um.meetingID, err))
+				continue
+			}
+
+			// The user or the meeting was deleted.
+			allowed = false
+		}
+		checked[um] = allowed
+
+		if allowed {
+			continue
+		}
+
+		closed := r.streams.Close(
+			func(info connection.Info) bool {
+				return info.UserID == um.userID && info.MeetingID == um.meetingID
+			},
+			iccerror.NewMessageError(iccerror.ErrNotAllowed, "You are not part of meeting %d anymore.", um.meetingID),
+		)
+		icclog.Info(ctx, "Closed streams of removed user", "streams", closed, "user_id", um.userID, "meeting_id", um.meetingID)
+	}
+
+	return errors.Join(errs...)
+}
+
+// canSeeMeeting returns true, if the user can receive the messages of a
+// meeting. The anonymous user can see a meeting, if it is enabled.
+func (r *Revoker) canSeeMeeting(ctx context.Context, userID, meetingID int) (bool, error) {
+	fetch := dsfetch.New(r.datastore)
+	if userID == 0 {
+		anonymousEnabled, err := fetch.Meeting_EnableAnonymous(meetingID).Value(ctx)
+		if err != nil {
+			return false, fmt.Errorf("fetching anonymous enabled: %w", err)
+		}
+		return anonymousEnabled, nil
+	}
+
+	return InMeeting(ctx, fetch, userID, meetingID)
+}
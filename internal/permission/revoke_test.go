@@ -0,0 +1,100 @@
+package permission_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/connection"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
+	"github.com/OpenSlides/openslides-icc-service/internal/permission"
+	"github.com/peb-adr/openslides-go/datastore/dskey"
+	"github.com/peb-adr/openslides-go/datastore/dsmock"
+)
+
+func TestRevoker(t *testing.T) {
+	ctx := context.Background()
+	ds := dsmock.Stub(dsmock.YAMLData(`---
+	meeting/1/enable_anonymous: false
+	meeting/2/enable_anonymous: true
+
+	user/5/meeting_user_ids: []
+	user/6/meeting_user_ids: [60]
+	user/7/meeting_user_ids: []
+
+	meeting_user/60:
+		meeting_id: 1
+		user_id: 6
+	`))
+
+	open := func(streams *connection.Registry, userID, meetingID int) context.Context {
+		streamCtx, done, _ := streams.Open(ctx, connection.Info{Kind: connection.KindNotify, UserID: userID, MeetingID: meetingID})
+		t.Cleanup(done)
+		return streamCtx
+	}
+
+	t.Run("Removed user", func(t *testing.T) {
+		streams := new(connection.Registry)
+		removed := open(streams, 5, 1)
+		member := open(streams, 6, 1)
+		notChanged := open(streams, 7, 1)
+
+		err := permission.NewRevoker(ds, streams).Update(ctx, map[dskey.Key][]byte{
+			dskey.MustKey("user/5/meeting_user_ids"): []byte("[]"),
+			dskey.MustKey("user/6/meeting_user_ids"): []byte("[60]"),
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		if !errors.Is(context.Cause(removed), iccerror.ErrNotAllowed) {
+			t.Errorf("Stream of removed user was closed with `%v`, expected `%v`", context.Cause(removed), iccerror.ErrNotAllowed)
+		}
+
+		if member.Err() != nil {
+			t.Errorf("Stream of member was closed")
+		}
+
+		if notChanged.Err() != nil {
+			t.Errorf("Stream of not changed user was closed")
+		}
+	})
+
+	t.Run("Anonymous disabled", func(t *testing.T) {
+		streams := new(connection.Registry)
+		disabled := open(streams, 0, 1)
+		enabled := open(streams, 0, 2)
+
+		err := permission.NewRevoker(ds, streams).Update(ctx, map[dskey.Key][]byte{
+			dskey.MustKey("meeting/1/enable_anonymous"): []byte("false"),
+			dskey.MustKey("meeting/2/enable_anonymous"): []byte("true"),
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		if disabled.Err() == nil {
+			t.Errorf("Anonymous stream of meeting 1 was not closed")
+		}
+
+		if enabled.Err() != nil {
+			t.Errorf("Anonymous stream of meeting 2 was closed")
+		}
+	})
+
+	t.Run("Other keys", func(t *testing.T) {
+		streams := new(connection.Registry)
+		stream := open(streams, 5, 1)
+
+		err := permission.NewRevoker(ds, streams).Update(ctx, map[dskey.Key][]byte{
+			dskey.MustKey("user/5/first_name"): []byte(`"hugo"`),
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		if stream.Err() != nil {
+			t.Errorf("Stream was closed")
+		}
+	})
+}
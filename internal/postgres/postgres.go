@@ -0,0 +1,497 @@
+// Package postgres implements the icc backend with postgres.
+//
+// The notify messages and admin commands are saved in tables. Each new row is
+// announced with NOTIFY. The instances LISTEN for it and read the new rows. If
+// the connection is lost, the rows since the last read row are read after the
+// reconnect.
+//
+// The tables are created on first use.
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// notifyTable is the name of the table and the channel for notify
+	// messages.
+	notifyTable = "icc_notify"
+
+	// adminTable is the name of the table and the channel for admin
+	// commands.
+	adminTable = "icc_admin"
+
+	// keepMessages is the time, how long notify messages and admin commands
+	// are kept in the tables.
+	keepMessages = 5 * time.Minute
+
+	// cleanInterval is the time between two removals of old messages.
+	cleanInterval = time.Minute
+
+	// readLimit is the maximum number of rows, that are read at once.
+	readLimit = 100
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS icc_notify (
+	id bigserial PRIMARY KEY,
+	content bytea NOT NULL,
+	created timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS icc_admin (
+	id bigserial PRIMARY KEY,
+	content bytea NOT NULL,
+	created timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS icc_applause (
+	meeting_id integer NOT NULL,
+	user_id integer NOT NULL,
+	time bigint NOT NULL,
+	PRIMARY KEY (meeting_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS icc_applause_time ON icc_applause (time);
+
+CREATE TABLE IF NOT EXISTS icc_admin_streams (
+	instance text PRIMARY KEY,
+	streams bytea NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS icc_limit (
+	key text NOT NULL,
+	stream text NOT NULL,
+	expires timestamptz NOT NULL,
+	PRIMARY KEY (key, stream)
+);
+`
+
+// Postgres implements the icc backend by saving the data to postgres.
+//
+// Has to be created with postgres.New().
+type Postgres struct {
+	pool *pgxpool.Pool
+
+	setupMu sync.Mutex
+	isSetup bool
+
+	notify *listener
+	admin  *listener
+}
+
+// New creates a new initialized postgres instance. It does not connect to
+// postgres.
+//
+// connString is a postgres connection string, for example
+// `host=localhost user=openslides`.
+func New(connString string) (*Postgres, error) {
+	config, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
+	if err != nil {
+		return nil, fmt.Errorf("creating connection pool: %w", err)
+	}
+
+	p := &Postgres{pool: pool}
+	p.notify = &listener{postgres: p, table: notifyTable}
+	p.admin = &listener{postgres: p, table: adminTable}
+	return p, nil
+}
+
+// Ping checks the connection to postgres.
+func (p *Postgres) Ping(ctx context.Context) error {
+	if err := p.pool.Ping(ctx); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	return nil
+}
+
+// setup creates the tables, if this was not done before.
+func (p *Postgres) setup(ctx context.Context) error {
+	p.setupMu.Lock()
+	defer p.setupMu.Unlock()
+
+	if p.isSetup {
+		return nil
+	}
+
+	if _, err := p.pool.Exec(ctx, schema); err != nil {
+		return fmt.Errorf("creating tables: %w", err)
+	}
+
+	p.isSetup = true
+	return nil
+}
+
+// exec runs a statement after the setup.
+func (p *Postgres) exec(ctx context.Context, sql string, args ...any) error {
+	if err := p.setup(ctx); err != nil {
+		return err
+	}
+
+	_, err := p.pool.Exec(ctx, sql, args...)
+	return err
+}
+
+// SetNotifyConnected sets a function, that is called each time, the listener
+// of the notify messages connected to postgres.
+func (p *Postgres) SetNotifyConnected(f func()) {
+	p.notify.connected = f
+}
+
+// NotifyPublish saves a valid notify message.
+func (p *Postgres) NotifyPublish(message []byte) error {
+	if err := p.notify.publish(context.Background(), message); err != nil {
+		return fmt.Errorf("publish notify message: %w", err)
+	}
+	return nil
+}
+
+// NotifyReceive is a blocking function that receives the messages.
+//
+// The first call returnes the notify messages, that are published after the
+// first call, the next call the messages after them an so on. If there are no
+// more messages to read, the function blocks until there is or the context
+// ist canceled.
+//
+// It is expected, that only one goroutine is calling this function.
+func (p *Postgres) NotifyReceive(ctx context.Context) ([][]byte, error) {
+	messages, err := p.notify.receive(ctx, 0)
+	if err != nil {
+		return nil, fmt.Errorf("read notify messages from postgres: %w", err)
+	}
+	return messages, nil
+}
+
+// ApplausePublish saves an applause for the user at a given time as unix time
+// stamp.
+func (p *Postgres) ApplausePublish(meetingID, userID int, time int64) error {
+	sql := `INSERT INTO icc_applause (meeting_id, user_id, time) VALUES ($1, $2, $3)
+	ON CONFLICT (meeting_id, user_id) DO UPDATE SET time = excluded.time`
+
+	if err := p.exec(context.Background(), sql, meetingID, userID, time); err != nil {
+		return fmt.Errorf("adding applause in postgres: %w", err)
+	}
+	return nil
+}
+
+// ApplauseSince returned all applause since a given time as unix time stamp.
+func (p *Postgres) ApplauseSince(time int64) (map[int]int, error) {
+	ctx := context.Background()
+	if err := p.setup(ctx); err != nil {
+		return nil, fmt.Errorf("getting applause from postgres: %w", err)
+	}
+
+	sql := `SELECT meeting_id, count(*) FROM icc_applause WHERE time >= $1 GROUP BY meeting_id`
+	rows, err := p.pool.Query(ctx, sql, time)
+	if err != nil {
+		return nil, fmt.Errorf("getting applause from postgres: %w", err)
+	}
+
+	out := make(map[int]int)
+	var meetingID, count int
+	if _, err := pgx.ForEachRow(rows, []any{&meetingID, &count}, func() error {
+		out[meetingID] = count
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("reading applause from postgres: %w", err)
+	}
+
+	return out, nil
+}
+
+// ApplauseCleanOld removes applause that is older then a given time.
+func (p *Postgres) ApplauseCleanOld(olderThen int64) error {
+	if err := p.exec(context.Background(), `DELETE FROM icc_applause WHERE time < $1`, olderThen); err != nil {
+		return fmt.Errorf("removing old applause from postgres: %w", err)
+	}
+	return nil
+}
+
+// AdminSaveStreams saves the encoded streams of an instance.
+func (p *Postgres) AdminSaveStreams(instance string, streams []byte) error {
+	sql := `INSERT INTO icc_admin_streams (instance, streams) VALUES ($1, $2)
+	ON CONFLICT (instance) DO UPDATE SET streams = excluded.streams`
+
+	if err := p.exec(context.Background(), sql, instance, streams); err != nil {
+		return fmt.Errorf("saving streams in postgres: %w", err)
+	}
+	return nil
+}
+
+// AdminRegisterStreams saves the encoded streams of an instance, if the
+// instance is not saved yet.
+func (p *Postgres) AdminRegisterStreams(instance string, streams []byte) (bool, error) {
+	ctx := context.Background()
+	if err := p.setup(ctx); err != nil {
+		return false, fmt.Errorf("registering instance in postgres: %w", err)
+	}
+
+	sql := `INSERT INTO icc_admin_streams (instance, streams) VALUES ($1, $2)
+	ON CONFLICT (instance) DO NOTHING`
+
+	tag, err := p.pool.Exec(ctx, sql, instance, streams)
+	if err != nil {
+		return false, fmt.Errorf("registering instance in postgres: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// AdminStreams returns the saved streams of all instances.
+func (p *Postgres) AdminStreams() (map[string][]byte, error) {
+	ctx := context.Background()
+	if err := p.setup(ctx); err != nil {
+		return nil, fmt.Errorf("getting streams from postgres: %w", err)
+	}
+
+	rows, err := p.pool.Query(ctx, `SELECT instance, streams FROM icc_admin_streams`)
+	if err != nil {
+		return nil, fmt.Errorf("getting streams from postgres: %w", err)
+	}
+
+	out := make(map[string][]byte)
+	var instance string
+	var streams []byte
+	if _, err := pgx.ForEachRow(rows, []any{&instance, &streams}, func() error {
+		out[instance] = streams
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("reading streams from postgres: %w", err)
+	}
+	return out, nil
+}
+
+// AdminRemoveStreams removes the saved streams of an instance.
+func (p *Postgres) AdminRemoveStreams(instance string) error {
+	if err := p.exec(context.Background(), `DELETE FROM icc_admin_streams WHERE instance = $1`, instance); err != nil {
+		return fmt.Errorf("removing streams from postgres: %w", err)
+	}
+	return nil
+}
+
+// AdminPublish saves an admin command.
+func (p *Postgres) AdminPublish(command []byte) error {
+	if err := p.admin.publish(context.Background(), command); err != nil {
+		return fmt.Errorf("publish admin command: %w", err)
+	}
+	return nil
+}
+
+// AdminReceive is a blocking function that receives the admin commands, that
+// were published after the first call.
+//
+// It is expected, that only one goroutine is calling this function.
+func (p *Postgres) AdminReceive(ctx context.Context) ([]byte, error) {
+	commands, err := p.admin.receive(ctx, 1)
+	if err != nil {
+		return nil, fmt.Errorf("read admin command from postgres: %w", err)
+	}
+	return commands[0], nil
+}
+
+// LimitAdd adds a stream to each key with its expire time.
+func (p *Postgres) LimitAdd(keys []string, stream string, expires time.Time) error {
+	sql := `INSERT INTO icc_limit (key, stream, expires) SELECT unnest($1::text[]), $2, $3
+	ON CONFLICT (key, stream) DO UPDATE SET expires = excluded.expires`
+
+	if err := p.exec(context.Background(), sql, keys, stream, expires); err != nil {
+		return fmt.Errorf("adding stream %s: %w", stream, err)
+	}
+	return nil
+}
+
+// LimitStreams removes the expired streams of the key and returns the others
+// ordered by their expire time.
+func (p *Postgres) LimitStreams(key string) ([]string, error) {
+	ctx := context.Background()
+	if err := p.exec(ctx, `DELETE FROM icc_limit WHERE key = $1 AND expires <= now()`, key); err != nil {
+		return nil, fmt.Errorf("removing expired streams: %w", err)
+	}
+
+	rows, err := p.pool.Query(ctx, `SELECT stream FROM icc_limit WHERE key = $1 ORDER BY expires, stream`, key)
+	if err != nil {
+		return nil, fmt.Errorf("getting streams: %w", err)
+	}
+
+	streams, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, fmt.Errorf("reading streams: %w", err)
+	}
+	return streams, nil
+}
+
+// LimitRemove removes a stream from the keys.
+func (p *Postgres) LimitRemove(keys []string, stream string) error {
+	if err := p.exec(context.Background(), `DELETE FROM icc_limit WHERE key = ANY($1) AND stream = $2`, keys, stream); err != nil {
+		return fmt.Errorf("removing stream %s: %w", stream, err)
+	}
+	return nil
+}
+
+// listener publishes and receives the rows of a message table.
+type listener struct {
+	postgres *Postgres
+	table    string
+
+	// connected is called, when receive opened a new connection. Can be nil.
+	connected func()
+
+	// The following fields are only used by receive.
+	conn        *pgx.Conn
+	lastID      int64
+	started     bool
+	buf         [][]byte
+	lastCleaned time.Time
+}
+
+// publish saves a message and notifies the listeners.
+//
+// The publishers of a table are serialized with an advisory lock, so the ids
+// are committed in order. Otherwise, a listener could read a higher id before
+// a lower one is committed and miss the lower one.
+func (l *listener) publish(ctx context.Context, message []byte) error {
+	if err := l.postgres.setup(ctx); err != nil {
+		return err
+	}
+
+	return pgx.BeginFunc(ctx, l.postgres.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, l.table); err != nil {
+			return fmt.Errorf("locking %s: %w", l.table, err)
+		}
+
+		sql := fmt.Sprintf(`INSERT INTO %s (content) VALUES ($1)`, l.table)
+		if _, err := tx.Exec(ctx, sql, message); err != nil {
+			return fmt.Errorf("inserting into %s: %w", l.table, err)
+		}
+
+		if _, err := tx.Exec(ctx, `SELECT pg_notify($1, '')`, l.table); err != nil {
+			return fmt.Errorf("notify %s: %w", l.table, err)
+		}
+		return nil
+	})
+}
+
+// receive returns the next messages, but not more then limit. If limit is 0,
+// all read messages are returned. It blocks until there is a message or the
+// context is canceled.
+func (l *listener) receive(ctx context.Context, limit int) ([][]byte, error) {
+	for len(l.buf) == 0 {
+		if err := l.connect(ctx); err != nil {
+			return nil, err
+		}
+
+		if err := l.read(ctx); err != nil {
+			l.close()
+			return nil, err
+		}
+
+		if len(l.buf) > 0 {
+			break
+		}
+
+		if _, err := l.conn.WaitForNotification(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			l.close()
+			return nil, fmt.Errorf("waiting for notification: %w", err)
+		}
+	}
+
+	n := len(l.buf)
+	if limit > 0 && limit < n {
+		n = limit
+	}
+
+	messages := l.buf[:n:n]
+	l.buf = l.buf[n:]
+	return messages, nil
+}
+
+// connect opens the connection, that listens for new messages.
+//
+// On the first call, the id of the last message is fetched. Only messages
+// after it are received.
+func (l *listener) connect(ctx context.Context) error {
+	if l.conn != nil {
+		return nil
+	}
+
+	if err := l.postgres.setup(ctx); err != nil {
+		return err
+	}
+
+	conn, err := pgx.ConnectConfig(ctx, l.postgres.pool.Config().ConnConfig)
+	if err != nil {
+		return fmt.Errorf("connecting: %w", err)
+	}
+
+	if _, err := conn.Exec(ctx, "LISTEN "+l.table); err != nil {
+		conn.Close(context.Background())
+		return fmt.Errorf("listen %s: %w", l.table, err)
+	}
+
+	if !l.started {
+		sql := fmt.Sprintf(`SELECT coalesce(max(id), 0) FROM %s`, l.table)
+		if err := conn.QueryRow(ctx, sql).Scan(&l.lastID); err != nil {
+			conn.Close(context.Background())
+			return fmt.Errorf("getting last id: %w", err)
+		}
+		l.started = true
+	}
+
+	l.conn = conn
+
+	if l.connected != nil {
+		l.connected()
+	}
+	return nil
+}
+
+// read reads the messages after the last id into the buffer. It also removes
+// old messages from time to time.
+func (l *listener) read(ctx context.Context) error {
+	if time.Since(l.lastCleaned) > cleanInterval {
+		sql := fmt.Sprintf(`DELETE FROM %s WHERE created < $1`, l.table)
+		if _, err := l.conn.Exec(ctx, sql, time.Now().Add(-keepMessages)); err != nil {
+			return fmt.Errorf("removing old messages: %w", err)
+		}
+		l.lastCleaned = time.Now()
+	}
+
+	sql := fmt.Sprintf(`SELECT id, content FROM %s WHERE id > $1 ORDER BY id LIMIT %d`, l.table, readLimit)
+	rows, err := l.conn.Query(ctx, sql, l.lastID)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", l.table, err)
+	}
+
+	var id int64
+	var content []byte
+	if _, err := pgx.ForEachRow(rows, []any{&id, &content}, func() error {
+		l.buf = append(l.buf, content)
+		l.lastID = id
+		return nil
+	}); err != nil {
+		return fmt.Errorf("reading %s: %w", l.table, err)
+	}
+	return nil
+}
+
+// close closes the connection. The next call to receive reconnects and reads
+// the messages, that were published in the meantime.
+func (l *listener) close() {
+	if l.conn == nil {
+		return
+	}
+	l.conn.Close(context.Background())
+	l.conn = nil
+}
@@ -0,0 +1,259 @@
+package postgres_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/backendtest"
+	"github.com/OpenSlides/openslides-icc-service/internal/postgres"
+	"github.com/jackc/pgx/v5"
+	"github.com/ory/dockertest/v3"
+)
+
+func startPostgres(t *testing.T) (string, func()) {
+	t.Helper()
+
+	pool, err := dockertest.NewPool("")
+	if err != nil {
+		t.Fatalf("Could not connect to docker: %s", err)
+	}
+
+	resource, err := pool.Run("postgres", "15", []string{"POSTGRES_PASSWORD=password", "POSTGRES_DB=openslides"})
+	if err != nil {
+		t.Fatalf("Could not start postgres container: %s", err)
+	}
+
+	connString := fmt.Sprintf("host=localhost port=%s user=postgres password=password dbname=openslides", resource.GetPort("5432/tcp"))
+
+	if err := pool.Retry(func() error {
+		conn, err := pgx.Connect(context.Background(), connString)
+		if err != nil {
+			return err
+		}
+		return conn.Close(context.Background())
+	}); err != nil {
+		t.Fatalf("Could not connect to postgres: %s", err)
+	}
+
+	return connString, func() {
+		if err = pool.Purge(resource); err != nil {
+			t.Fatalf("Could not purge postgres container: %s", err)
+		}
+	}
+}
+
+func TestICC(t *testing.T) {
+	connString, stopPostgres := startPostgres(t)
+	defer stopPostgres()
+
+	// newBackend returns a backend with empty tables.
+	newBackend := func(t *testing.T) *postgres.Postgres {
+		conn, err := pgx.Connect(context.Background(), connString)
+		if err != nil {
+			t.Fatalf("connecting to postgres: %v", err)
+		}
+		defer conn.Close(context.Background())
+
+		if _, err := conn.Exec(context.Background(), `DROP TABLE IF EXISTS icc_notify, icc_admin, icc_applause, icc_admin_streams, icc_limit`); err != nil {
+			t.Fatalf("dropping tables: %v", err)
+		}
+
+		p, err := postgres.New(connString)
+		if err != nil {
+			t.Fatalf("creating backend: %v", err)
+		}
+		return p
+	}
+
+	t.Run("Notify", func(t *testing.T) {
+		backendtest.Notify(t, func(t *testing.T) backendtest.NotifyBackend {
+			return newBackend(t)
+		})
+	})
+
+	t.Run("Applause", func(t *testing.T) {
+		backendtest.Applause(t, func(t *testing.T) backendtest.ApplauseBackend {
+			return newBackend(t)
+		})
+	})
+
+	pgConn := newBackend(t)
+
+	t.Run("Delete applause", func(t *testing.T) {
+		if err := pgConn.ApplausePublish(1, 1, 10); err != nil {
+			t.Fatalf("sending applause: %v", err)
+		}
+
+		if err := pgConn.ApplauseCleanOld(100); err != nil {
+			t.Fatalf("deleting old applause: %v", err)
+		}
+
+		applause, err := pgConn.ApplauseSince(10)
+
+		if err != nil {
+			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+		}
+
+		if len(applause) != 0 {
+			t.Errorf("receiveApplause returned %d, expected 0", applause)
+		}
+	})
+
+	t.Run("Delete not new applause", func(t *testing.T) {
+		defer pgConn.ApplauseCleanOld(1000)
+
+		if err := pgConn.ApplausePublish(1, 1, 10); err != nil {
+			t.Fatalf("sending applause: %v", err)
+		}
+
+		if err := pgConn.ApplauseCleanOld(10); err != nil {
+			t.Fatalf("deleting old applause: %v", err)
+		}
+
+		applause, err := pgConn.ApplauseSince(10)
+
+		if err != nil {
+			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+		}
+
+		if len(applause) != 1 {
+			t.Errorf("receiveApplause returned %d, expected 1", applause)
+		}
+	})
+
+	t.Run("Save and remove admin streams", func(t *testing.T) {
+		if err := pgConn.AdminSaveStreams("instance1", []byte("streams")); err != nil {
+			t.Fatalf("saving streams: %v", err)
+		}
+
+		streams, err := pgConn.AdminStreams()
+		if err != nil {
+			t.Fatalf("getting streams: %v", err)
+		}
+
+		if string(streams["instance1"]) != "streams" {
+			t.Errorf("got streams %q, expected `streams`", streams["instance1"])
+		}
+
+		if err := pgConn.AdminRemoveStreams("instance1"); err != nil {
+			t.Fatalf("removing streams: %v", err)
+		}
+
+		streams, err = pgConn.AdminStreams()
+		if err != nil {
+			t.Fatalf("getting streams: %v", err)
+		}
+
+		if _, ok := streams["instance1"]; ok {
+			t.Errorf("streams of instance1 were not removed")
+		}
+	})
+
+	t.Run("Register admin streams", func(t *testing.T) {
+		created, err := pgConn.AdminRegisterStreams("instance2", []byte("first"))
+		if err != nil {
+			t.Fatalf("registering streams: %v", err)
+		}
+
+		if !created {
+			t.Errorf("first registration returned false")
+		}
+
+		created, err = pgConn.AdminRegisterStreams("instance2", []byte("second"))
+		if err != nil {
+			t.Fatalf("registering streams again: %v", err)
+		}
+
+		if created {
+			t.Errorf("second registration returned true")
+		}
+
+		streams, err := pgConn.AdminStreams()
+		if err != nil {
+			t.Fatalf("getting streams: %v", err)
+		}
+
+		if string(streams["instance2"]) != "first" {
+			t.Errorf("got streams %q, expected `first`", streams["instance2"])
+		}
+
+		if err := pgConn.AdminRemoveStreams("instance2"); err != nil {
+			t.Fatalf("removing streams: %v", err)
+		}
+	})
+
+	t.Run("Receive admin command", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		type receiveReturn struct {
+			command []byte
+			err     error
+		}
+
+		done := make(chan receiveReturn)
+		go func() {
+			command, err := pgConn.AdminReceive(ctx)
+			done <- receiveReturn{command, err}
+		}()
+
+		// Wait for AdminReceive to listen.
+		time.Sleep(100 * time.Millisecond)
+
+		if err := pgConn.AdminPublish([]byte("my command")); err != nil {
+			t.Fatalf("publish command: %v", err)
+		}
+
+		timer := time.NewTimer(time.Second)
+		defer timer.Stop()
+
+		select {
+		case data := <-done:
+			if err := data.err; err != nil {
+				t.Errorf("AdminReceive returned unexpected error: %v", err)
+			}
+
+			if string(data.command) != "my command" {
+				t.Errorf("AdminReceive returned command `%s`, expected `my command`", data.command)
+			}
+
+		case <-timer.C:
+			t.Errorf("AdminReceive did not unblock after command was send.")
+		}
+	})
+
+	t.Run("Limit streams", func(t *testing.T) {
+		keys := []string{"user:1", "meeting:1"}
+		if err := pgConn.LimitAdd(keys, "stream1", time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("adding stream1: %v", err)
+		}
+
+		if err := pgConn.LimitAdd(keys, "expired", time.Now().Add(-time.Minute)); err != nil {
+			t.Fatalf("adding expired stream: %v", err)
+		}
+
+		streams, err := pgConn.LimitStreams("meeting:1")
+		if err != nil {
+			t.Fatalf("getting streams: %v", err)
+		}
+
+		if len(streams) != 1 || streams[0] != "stream1" {
+			t.Errorf("got streams %v, expected [stream1]", streams)
+		}
+
+		if err := pgConn.LimitRemove(keys, "stream1"); err != nil {
+			t.Fatalf("removing stream: %v", err)
+		}
+
+		streams, err = pgConn.LimitStreams("user:1")
+		if err != nil {
+			t.Fatalf("getting streams: %v", err)
+		}
+
+		if len(streams) != 0 {
+			t.Errorf("got streams %v, expected none", streams)
+		}
+	})
+}
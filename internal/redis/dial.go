@@ -0,0 +1,323 @@
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// dialTimeout is the timeout to connect to redis or a sentinel.
+const dialTimeout = 5 * time.Second
+
+// clusterHashTag is the prefix of all keys in cluster mode. All keys get the
+// same hash slot, so they are on the same node.
+const clusterHashTag = "{icc}"
+
+// Config is the configuration to connect to redis.
+type Config struct {
+	// Addr is the address of redis as host:port.
+	//
+	// With SentinelMaster, it is a comma separated list of sentinel
+	// addresses. With Cluster, it is a comma separated list of cluster nodes.
+	Addr string
+
+	// Username is the ACL user. If empty, the default user is used.
+	Username string
+
+	// Password is used for AUTH, if not empty.
+	Password string
+
+	// TLS enables tls, if not nil.
+	TLS *tls.Config
+
+	// SentinelMaster is the name of the master, that is looked up from the
+	// sentinels in Addr.
+	SentinelMaster string
+
+	// Cluster enables the cluster mode. All keys use the same hash tag, so
+	// they are saved on the same node. That node is looked up from the
+	// cluster nodes in Addr.
+	Cluster bool
+}
+
+// TLSConfig returns a tls config, that trusts the certificates in caFile. If
+// caFile is empty, the system certificates are used.
+func TLSConfig(caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading ca file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %s", caFile)
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
+// addrs returns the addresses of Addr.
+func (c Config) addrs() []string {
+	var addrs []string
+	for _, addr := range strings.Split(c.Addr, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// dialOptions returns the options for a connection to a redis node.
+func (c Config) dialOptions() []redis.DialOption {
+	options := []redis.DialOption{
+		redis.DialConnectTimeout(dialTimeout),
+	}
+
+	if c.Username != "" {
+		options = append(options, redis.DialUsername(c.Username))
+	}
+
+	if c.Password != "" {
+		options = append(options, redis.DialPassword(c.Password))
+	}
+
+	if c.TLS != nil {
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(c.TLS))
+	}
+
+	return options
+}
+
+// dial connects to the redis node, that saves the icc data.
+func (c Config) dial() (redis.Conn, error) {
+	switch {
+	case c.SentinelMaster != "":
+		return c.dialSentinel()
+	case c.Cluster:
+		return c.dialCluster()
+	default:
+		return redis.Dial("tcp", c.Addr, c.dialOptions()...)
+	}
+}
+
+// dialSentinel asks the sentinels for the address of the master and connects
+// to it.
+func (c Config) dialSentinel() (redis.Conn, error) {
+	var errs []error
+	for _, sentinelAddr := range c.addrs() {
+		addr, err := c.sentinelMaster(sentinelAddr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sentinel %s: %w", sentinelAddr, err))
+			continue
+		}
+
+		conn, err := redis.Dial("tcp", addr, c.dialOptions()...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("master %s: %w", addr, err))
+			continue
+		}
+
+		if err := checkMaster(conn); err != nil {
+			conn.Close()
+			errs = append(errs, fmt.Errorf("master %s: %w", addr, err))
+			continue
+		}
+
+		return conn, nil
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no sentinel address")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// sentinelMaster asks one sentinel for the address of the master.
+//
+// The sentinel is connected with the same tls config, but without the
+// credentials of redis.
+func (c Config) sentinelMaster(sentinelAddr string) (string, error) {
+	options := []redis.DialOption{redis.DialConnectTimeout(dialTimeout)}
+	if c.TLS != nil {
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(c.TLS))
+	}
+
+	conn, err := redis.Dial("tcp", sentinelAddr, options...)
+	if err != nil {
+		return "", fmt.Errorf("connecting: %w", err)
+	}
+	defer conn.Close()
+
+	hostPort, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", c.SentinelMaster))
+	if err != nil {
+		return "", fmt.Errorf("getting master %s: %w", c.SentinelMaster, err)
+	}
+
+	if len(hostPort) != 2 {
+		return "", fmt.Errorf("invalid address of master %s: %v", c.SentinelMaster, hostPort)
+	}
+	return hostPort[0] + ":" + hostPort[1], nil
+}
+
+// checkMaster returns an error, if the connection is not to a master. After a
+// failover, the old master can still be reachable as replica.
+func checkMaster(conn redis.Conn) error {
+	role, err := redis.Values(conn.Do("ROLE"))
+	if err != nil {
+		return fmt.Errorf("getting role: %w", err)
+	}
+
+	if len(role) == 0 {
+		return fmt.Errorf("empty role")
+	}
+
+	if name, _ := redis.String(role[0], nil); name != "master" {
+		return fmt.Errorf("role is %s, not master", name)
+	}
+	return nil
+}
+
+// dialCluster looks up the node for the hash tag and connects to it.
+func (c Config) dialCluster() (redis.Conn, error) {
+	var errs []error
+	for _, nodeAddr := range c.addrs() {
+		conn, err := redis.Dial("tcp", nodeAddr, c.dialOptions()...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", nodeAddr, err))
+			continue
+		}
+
+		addr, err := clusterNode(conn)
+		if err != nil {
+			conn.Close()
+			errs = append(errs, fmt.Errorf("node %s: %w", nodeAddr, err))
+			continue
+		}
+
+		if addr == nodeAddr {
+			return &clusterConn{Conn: conn}, nil
+		}
+		conn.Close()
+
+		conn, err = redis.Dial("tcp", addr, c.dialOptions()...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", addr, err))
+			continue
+		}
+		return &clusterConn{Conn: conn}, nil
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no cluster address")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// clusterConn is a connection to the cluster node, that has the slot of the
+// hash tag. After a reshard or a failover, the node answers with MOVED or
+// READONLY. Then Err returns an error, so the pool closes the connection and
+// the next connection is dialed to the new node.
+type clusterConn struct {
+	redis.Conn
+	err error
+}
+
+func (c *clusterConn) Do(cmd string, args ...any) (any, error) {
+	reply, err := c.Conn.Do(cmd, args...)
+	c.check(err)
+	return reply, err
+}
+
+func (c *clusterConn) DoContext(ctx context.Context, cmd string, args ...any) (any, error) {
+	reply, err := redis.DoContext(c.Conn, ctx, cmd, args...)
+	c.check(err)
+	return reply, err
+}
+
+func (c *clusterConn) Err() error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.Conn.Err()
+}
+
+// check remembers an error, that means, that the node does not have the slot
+// anymore.
+func (c *clusterConn) check(err error) {
+	var redisErr redis.Error
+	if !errors.As(err, &redisErr) {
+		return
+	}
+
+	if strings.HasPrefix(string(redisErr), "MOVED ") || strings.HasPrefix(string(redisErr), "READONLY ") {
+		c.err = fmt.Errorf("slot moved to another node: %w", err)
+	}
+}
+
+// clusterNode returns the address of the master, that has the slot of the
+// hash tag.
+func clusterNode(conn redis.Conn) (string, error) {
+	slot, err := redis.Int(conn.Do("CLUSTER", "KEYSLOT", clusterHashTag))
+	if err != nil {
+		return "", fmt.Errorf("getting slot: %w", err)
+	}
+
+	ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return "", fmt.Errorf("getting slots: %w", err)
+	}
+
+	// Each range is [start, end, [host, port, ...], replicas...].
+	for _, r := range ranges {
+		values, err := redis.Values(r, nil)
+		if err != nil || len(values) < 3 {
+			return "", fmt.Errorf("invalid slot range: %v", r)
+		}
+
+		start, err1 := redis.Int(values[0], nil)
+		end, err2 := redis.Int(values[1], nil)
+		if err := errors.Join(err1, err2); err != nil {
+			return "", fmt.Errorf("invalid slot range: %w", err)
+		}
+
+		if slot < start || slot > end {
+			continue
+		}
+
+		master, err := redis.Values(values[2], nil)
+		if err != nil || len(master) < 2 {
+			return "", fmt.Errorf("invalid master of slot range: %v", values[2])
+		}
+
+		host, err1 := redis.String(master[0], nil)
+		port, err2 := redis.Int(master[1], nil)
+		if err := errors.Join(err1, err2); err != nil {
+			return "", fmt.Errorf("invalid master of slot range: %w", err)
+		}
+		return host + ":" + strconv.Itoa(port), nil
+	}
+
+	return "", fmt.Errorf("no node for slot %d", slot)
+}
+
+// key returns the name of a redis key. In cluster mode, the keys get the
+// hash tag.
+func (c Config) key(name string) string {
+	if c.Cluster {
+		return clusterHashTag + name
+	}
+	return name
+}
@@ -0,0 +1,388 @@
+package redis_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/redis"
+)
+
+// fakeRedis is a tcp server, that speaks the redis protocol. It answers each
+// command with the handler.
+type fakeRedis struct {
+	listener net.Listener
+	handler  func(cmd []string) any
+
+	mu       sync.Mutex
+	commands []string
+}
+
+func newFakeRedis(t *testing.T, handler func(cmd []string) any) *fakeRedis {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	f := &fakeRedis{listener: listener, handler: handler}
+	go f.serve()
+	return f
+}
+
+func (f *fakeRedis) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeRedis) host() string {
+	host, _, _ := net.SplitHostPort(f.addr())
+	return host
+}
+
+func (f *fakeRedis) port() int {
+	return f.listener.Addr().(*net.TCPAddr).Port
+}
+
+// received returns the received commands.
+func (f *fakeRedis) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.serveConn(conn)
+	}
+}
+
+func (f *fakeRedis) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		cmd, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.commands = append(f.commands, strings.Join(cmd, " "))
+		f.mu.Unlock()
+
+		if _, err := conn.Write(encodeReply(f.handler(cmd))); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid array: %q", line)
+	}
+
+	cmd := make([]string, n)
+	for i := range cmd {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk string: %q", line)
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		cmd[i] = string(buf[:size])
+	}
+	return cmd, nil
+}
+
+func encodeReply(reply any) []byte {
+	switch v := reply.(type) {
+	case nil:
+		return []byte("$-1\r\n")
+	case error:
+		return []byte("-" + v.Error() + "\r\n")
+	case int:
+		return []byte(":" + strconv.Itoa(v) + "\r\n")
+	case string:
+		return []byte("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []any:
+		out := []byte("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, e := range v {
+			out = append(out, encodeReply(e)...)
+		}
+		return out
+	default:
+		panic(fmt.Sprintf("unknown reply type %T", reply))
+	}
+}
+
+// nodeHandler answers the commands of a redis node with the given role.
+func nodeHandler(role string) func(cmd []string) any {
+	return func(cmd []string) any {
+		switch strings.ToUpper(cmd[0]) {
+		case "AUTH":
+			return "OK"
+		case "PING":
+			return "PONG"
+		case "ROLE":
+			return []any{role, 0, []any{}}
+		case "ZADD":
+			return 1
+		default:
+			return fmt.Errorf("ERR unknown command %s", cmd[0])
+		}
+	}
+}
+
+func TestSentinel(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Connect to master", func(t *testing.T) {
+		master := newFakeRedis(t, nodeHandler("master"))
+		sentinel := newFakeRedis(t, func(cmd []string) any {
+			if strings.Join(cmd, " ") == "SENTINEL get-master-addr-by-name mymaster" {
+				return []any{master.host(), strconv.Itoa(master.port())}
+			}
+			return fmt.Errorf("ERR unknown command")
+		})
+
+		r := redis.New(redis.Config{
+			Addr:           sentinel.addr(),
+			Username:       "icc",
+			Password:       "secret",
+			SentinelMaster: "mymaster",
+		})
+
+		if err := r.Ping(ctx); err != nil {
+			t.Fatalf("Ping: %v", err)
+		}
+
+		got := master.received()
+		if len(got) == 0 || got[0] != "AUTH icc secret" {
+			t.Errorf("master received %v, expected AUTH with the credentials first", got)
+		}
+	})
+
+	t.Run("Skip unreachable sentinel", func(t *testing.T) {
+		master := newFakeRedis(t, nodeHandler("master"))
+		sentinel := newFakeRedis(t, func(cmd []string) any {
+			return []any{master.host(), strconv.Itoa(master.port())}
+		})
+
+		// Get a free port, that is not used.
+		closed := newFakeRedis(t, nodeHandler("master"))
+		closed.listener.Close()
+
+		r := redis.New(redis.Config{
+			Addr:           closed.addr() + "," + sentinel.addr(),
+			SentinelMaster: "mymaster",
+		})
+
+		if err := r.Ping(ctx); err != nil {
+			t.Fatalf("Ping: %v", err)
+		}
+	})
+
+	t.Run("Master is a replica", func(t *testing.T) {
+		replica := newFakeRedis(t, nodeHandler("slave"))
+		sentinel := newFakeRedis(t, func(cmd []string) any {
+			return []any{replica.host(), strconv.Itoa(replica.port())}
+		})
+
+		r := redis.New(redis.Config{
+			Addr:           sentinel.addr(),
+			SentinelMaster: "mymaster",
+		})
+
+		if err := r.Ping(ctx); err == nil {
+			t.Errorf("Ping returned no error, expected an error for a replica")
+		}
+	})
+}
+
+func TestCluster(t *testing.T) {
+	node := newFakeRedis(t, nodeHandler("master"))
+	seed := newFakeRedis(t, func(cmd []string) any {
+		switch strings.Join(cmd, " ") {
+		case "CLUSTER KEYSLOT {icc}":
+			return 1234
+		case "CLUSTER SLOTS":
+			return []any{
+				[]any{0, 1000, []any{"127.0.0.1", 1, "other"}},
+				[]any{1001, 2000, []any{node.host(), node.port(), "node"}},
+			}
+		default:
+			return fmt.Errorf("ERR unknown command")
+		}
+	})
+
+	r := redis.New(redis.Config{
+		Addr:    seed.addr(),
+		Cluster: true,
+	})
+
+	if err := r.ApplausePublish(1, 2, 10); err != nil {
+		t.Fatalf("ApplausePublish: %v", err)
+	}
+
+	got := node.received()
+	if len(got) != 1 || got[0] != "ZADD {icc}applause 10 1-2" {
+		t.Errorf("node received %v, expected ZADD with the hash tag", got)
+	}
+}
+
+func TestClusterMoved(t *testing.T) {
+	var moved atomic.Bool
+
+	newNode := newFakeRedis(t, nodeHandler("master"))
+	oldNode := newFakeRedis(t, func(cmd []string) any {
+		if moved.Load() {
+			return fmt.Errorf("MOVED 1234 %s", newNode.addr())
+		}
+		return nodeHandler("master")(cmd)
+	})
+
+	seed := newFakeRedis(t, func(cmd []string) any {
+		node := oldNode
+		if moved.Load() {
+			node = newNode
+		}
+
+		switch strings.Join(cmd, " ") {
+		case "CLUSTER KEYSLOT {icc}":
+			return 1234
+		case "CLUSTER SLOTS":
+			return []any{[]any{0, 16383, []any{node.host(), node.port(), "node"}}}
+		default:
+			return fmt.Errorf("ERR unknown command")
+		}
+	})
+
+	r := redis.New(redis.Config{
+		Addr:    seed.addr(),
+		Cluster: true,
+	})
+
+	if err := r.ApplausePublish(1, 2, 10); err != nil {
+		t.Fatalf("ApplausePublish: %v", err)
+	}
+
+	moved.Store(true)
+
+	// The first command after the reshard fails. The connection is not used
+	// again.
+	if err := r.ApplausePublish(1, 2, 11); err == nil {
+		t.Fatalf("ApplausePublish returned no error for MOVED")
+	}
+
+	if err := r.ApplausePublish(1, 2, 12); err != nil {
+		t.Fatalf("ApplausePublish after MOVED: %v", err)
+	}
+
+	got := newNode.received()
+	if len(got) != 1 || got[0] != "ZADD {icc}applause 12 1-2" {
+		t.Errorf("new node received %v, expected the last ZADD", got)
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	t.Run("Without ca file", func(t *testing.T) {
+		config, err := redis.TLSConfig("")
+		if err != nil {
+			t.Fatalf("TLSConfig: %v", err)
+		}
+
+		if config.RootCAs != nil {
+			t.Errorf("got root cas, expected the system certificates")
+		}
+	})
+
+	t.Run("With ca file", func(t *testing.T) {
+		caFile := filepath.Join(t.TempDir(), "ca.pem")
+		if err := os.WriteFile(caFile, selfSignedCert(t), 0o600); err != nil {
+			t.Fatalf("writing ca file: %v", err)
+		}
+
+		config, err := redis.TLSConfig(caFile)
+		if err != nil {
+			t.Fatalf("TLSConfig: %v", err)
+		}
+
+		if config.RootCAs == nil {
+			t.Errorf("got no root cas")
+		}
+	})
+
+	t.Run("Invalid ca file", func(t *testing.T) {
+		caFile := filepath.Join(t.TempDir(), "ca.pem")
+		if err := os.WriteFile(caFile, []byte("no certificate"), 0o600); err != nil {
+			t.Fatalf("writing ca file: %v", err)
+		}
+
+		if _, err := redis.TLSConfig(caFile); err == nil {
+			t.Errorf("TLSConfig returned no error")
+		}
+	})
+}
+
+// selfSignedCert returns a pem encoded self signed certificate.
+func selfSignedCert(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redis-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
@@ -0,0 +1,97 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// streamReader reads a redis stream with its own connection. The connection
+// is kept between the calls and only replaced after an error.
+//
+// It is expected, that only one goroutine uses a streamReader.
+type streamReader struct {
+	config Config
+	key    string
+
+	// count is the maximum number of entries, that are read at once.
+	count int
+
+	// connected is called, when a new connection is opened. Can be nil.
+	connected func()
+
+	conn   redis.Conn
+	lastID string
+}
+
+// contentField is the field of a stream entry, that contains the message.
+const contentField = "content"
+
+// read blocks until there are new entries in the stream and returns their
+// content. Entries without content are skipped.
+//
+// The first call returns the entries, that were added after the call.
+func (s *streamReader) read(ctx context.Context) ([][]byte, error) {
+	for {
+		entries, err := s.readEntries(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var contents [][]byte
+		for _, entry := range entries {
+			if content, ok := entry.fields[contentField]; ok {
+				contents = append(contents, content)
+			}
+		}
+
+		if len(contents) > 0 {
+			return contents, nil
+		}
+	}
+}
+
+// readEntries blocks until there are new entries in the stream and returns
+// them.
+func (s *streamReader) readEntries(ctx context.Context) ([]streamEntry, error) {
+	if s.conn == nil {
+		conn, err := s.config.dial()
+		if err != nil {
+			return nil, fmt.Errorf("connecting: %w", err)
+		}
+		s.conn = conn
+
+		if s.connected != nil {
+			s.connected()
+		}
+	}
+
+	id := s.lastID
+	if id == "" {
+		id = "$"
+	}
+
+	reply, err := redis.DoContext(s.conn, ctx, "XREAD", "COUNT", s.count, "BLOCK", "0", "STREAMS", s.config.key(s.key), id)
+	if err != nil {
+		// A canceled command closes the connection.
+		s.conn.Close()
+		s.conn = nil
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("xread: %w", err)
+	}
+
+	streams, err := xread(reply, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decoding xread reply: %w", err)
+	}
+
+	entries := streams[s.config.key(s.key)]
+	if len(entries) > 0 {
+		s.lastID = entries[len(entries)-1].id
+	}
+	return entries, nil
+}
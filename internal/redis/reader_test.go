@@ -0,0 +1,95 @@
+package redis_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/redis"
+)
+
+func TestNotifyReceiveContinuesAfterLastEntry(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// Each XREAD returns two new entries. Only the ids of the second entries
+	// are the correct ids for the next XREAD.
+	var calls atomic.Int32
+	fake := newFakeRedis(t, func(cmd []string) any {
+		if strings.ToUpper(cmd[0]) != "XREAD" {
+			return "OK"
+		}
+
+		n := calls.Add(1)
+		return []any{
+			[]any{"icc-notify", []any{
+				[]any{fmt.Sprintf("%d-0", n), []any{"content", fmt.Sprintf("message %d-0", n)}},
+				[]any{fmt.Sprintf("%d-1", n), []any{"content", fmt.Sprintf("message %d-1", n)}},
+			}},
+		}
+	})
+
+	r := redis.New(redis.Config{Addr: fake.addr()})
+
+	for i := 1; i <= 3; i++ {
+		got, err := r.NotifyReceive(ctx)
+		if err != nil {
+			t.Fatalf("NotifyReceive %d: %v", i, err)
+		}
+
+		if len(got) != 2 || string(got[1]) != fmt.Sprintf("message %d-1", i) {
+			t.Errorf("NotifyReceive %d returned %q", i, got)
+		}
+	}
+
+	var ids []string
+	for _, cmd := range fake.received() {
+		if strings.HasPrefix(cmd, "XREAD") {
+			fields := strings.Fields(cmd)
+			ids = append(ids, fields[len(fields)-1])
+		}
+	}
+
+	expect := []string{"$", "1-1", "2-1"}
+	if strings.Join(ids, " ") != strings.Join(expect, " ") {
+		t.Errorf("XREAD was called with ids %v, expected %v", ids, expect)
+	}
+}
+
+func TestNotifyConnected(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// The first XREAD fails, the second returns a message.
+	var calls atomic.Int32
+	fake := newFakeRedis(t, func(cmd []string) any {
+		if strings.ToUpper(cmd[0]) != "XREAD" {
+			return "OK"
+		}
+
+		if calls.Add(1) == 1 {
+			return fmt.Errorf("ERR something went wrong")
+		}
+		return []any{[]any{"icc-notify", []any{[]any{"1-0", []any{"content", "message"}}}}}
+	})
+
+	r := redis.New(redis.Config{Addr: fake.addr()})
+
+	var connected int
+	r.SetNotifyConnected(func() { connected++ })
+
+	if _, err := r.NotifyReceive(ctx); err == nil {
+		t.Fatalf("NotifyReceive returned no error")
+	}
+
+	if _, err := r.NotifyReceive(ctx); err != nil {
+		t.Fatalf("NotifyReceive after the error: %v", err)
+	}
+
+	if connected != 2 {
+		t.Errorf("connected was called %d times, expected 2", connected)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/OpenSlides/openslides-icc-service/internal/icclog"
 	"github.com/gomodule/redigo/redis"
 )
 
@@ -15,43 +14,81 @@ const (
 
 	// applauseKey is the name of the redis key for applause.
 	applauseKey = "applause"
+
+	// adminStreamsKey is the name of the redis hash with the streams of each
+	// instance.
+	adminStreamsKey = "icc-admin-streams"
+
+	// adminKey is the name of the redis stream for admin commands.
+	adminKey = "icc-admin"
+
+	// limitKeyPrefix is the prefix of the redis sorted sets, that count the
+	// streams for the connection limits.
+	limitKeyPrefix = "icc-limit:"
+
+	// adminMaxLen is the approximated number of admin commands, that are kept
+	// in redis.
+	adminMaxLen = 1000
+
+	// notifyReadCount is the maximum number of notify messages, that are read
+	// at once.
+	notifyReadCount = 100
 )
 
 // Redis implements the icc backend by saving the data to redis.
 //
 // Has to be created with redis.New().
 type Redis struct {
-	pool         *redis.Pool
-	lastNotifyID string
+	pool   *redis.Pool
+	config Config
+	notify *streamReader
+	admin  *streamReader
 }
 
 // New creates a new initializes redis instance.
-func New(addr string) *Redis {
+func New(config Config) *Redis {
 	pool := redis.Pool{
 		MaxActive:   100,
 		Wait:        true,
 		MaxIdle:     10,
 		IdleTimeout: 240 * time.Second,
-		Dial:        func() (redis.Conn, error) { return redis.Dial("tcp", addr) },
+		Dial:        config.dial,
+	}
+
+	if config.SentinelMaster != "" {
+		// After a failover, idle connections to the old master are
+		// closed.
+		pool.TestOnBorrow = func(conn redis.Conn, lastUsed time.Time) error {
+			if time.Since(lastUsed) < time.Second {
+				return nil
+			}
+			return checkMaster(conn)
+		}
 	}
 
 	return &Redis{
-		pool: &pool,
+		pool:   &pool,
+		config: config,
+		notify: &streamReader{config: config, key: notifyKey, count: notifyReadCount},
+		admin:  &streamReader{config: config, key: adminKey, count: 1},
 	}
 }
 
-// Wait blocks until a connection to redis can be established.
-func (r *Redis) Wait(ctx context.Context) {
-	for ctx.Err() == nil {
-		conn := r.pool.Get()
-		_, err := conn.Do("PING")
-		conn.Close()
-		if err == nil {
-			return
-		}
-		icclog.Info("Waiting for redis: %v", err)
-		time.Sleep(500 * time.Millisecond)
+// Ping checks the connection to redis.
+func (r *Redis) Ping(ctx context.Context) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if _, err := redis.DoContext(conn, ctx, "PING"); err != nil {
+		return fmt.Errorf("ping: %w", err)
 	}
+	return nil
+}
+
+// SetNotifyConnected sets a function, that is called each time, the reader
+// of the notify stream connected to redis.
+func (r *Redis) SetNotifyConnected(f func()) {
+	r.notify.connected = f
 }
 
 // NotifyPublish saves a valid notify message.
@@ -59,7 +96,7 @@ func (r *Redis) NotifyPublish(message []byte) error {
 	conn := r.pool.Get()
 	defer conn.Close()
 
-	_, err := conn.Do("XADD", notifyKey, "*", "content", message)
+	_, err := conn.Do("XADD", r.config.key(notifyKey), "*", contentField, message)
 	if err != nil {
 		return fmt.Errorf("xadd: %w", err)
 	}
@@ -68,49 +105,19 @@ func (r *Redis) NotifyPublish(message []byte) error {
 
 // NotifyReceive is a blocking function that receives the messages.
 //
-// The first call returnes the first notify message, the next call the second an
-// so on. If there are no more messages to read, the function blocks until there
-// is or the context ist canceled.
+// The first call returnes the notify messages, that were published after the
+// first call. The next call returns the messages after them and so on. If
+// there are no more messages to read, the function blocks until there is or
+// the context ist canceled. Up to 100 messages are returned at once.
 //
-// It is expected, that only one goroutine is calling this function.
-func (r *Redis) NotifyReceive(ctx context.Context) ([]byte, error) {
-	id := r.lastNotifyID
-	if id == "" {
-		id = "$"
-	}
-
-	type streamReturn struct {
-		id   string
-		data []byte
-		err  error
-	}
-
-	streamFinished := make(chan streamReturn)
-
-	go func() {
-		conn := r.pool.Get()
-		defer conn.Close()
-
-		id, data, err := stream(conn.Do("XREAD", "COUNT", 1, "BLOCK", "0", "STREAMS", notifyKey, id))
-		streamFinished <- streamReturn{id, data, err}
-	}()
-
-	var received streamReturn
-	select {
-	case received = <-streamFinished:
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	}
-
-	if received.id != "" {
-		r.lastNotifyID = id
-	}
-
-	if err := received.err; err != nil {
-		return nil, fmt.Errorf("read notify message from redis: %w", err)
+// The messages are read with a connection, that is only used for this
+// function. It is expected, that only one goroutine is calling this function.
+func (r *Redis) NotifyReceive(ctx context.Context) ([][]byte, error) {
+	messages, err := r.notify.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read notify messages from redis: %w", err)
 	}
-
-	return received.data, nil
+	return messages, nil
 }
 
 // ApplausePublish saves an applause for the user at a given time as unix time
@@ -120,7 +127,7 @@ func (r *Redis) ApplausePublish(meetingID, userID int, time int64) error {
 	defer conn.Close()
 
 	meetingUser := fmt.Sprintf("%d-%d", meetingID, userID)
-	if _, err := conn.Do("ZADD", applauseKey, time, meetingUser); err != nil {
+	if _, err := conn.Do("ZADD", r.config.key(applauseKey), time, meetingUser); err != nil {
 		return fmt.Errorf("adding applause in redis: %w", err)
 	}
 
@@ -132,7 +139,7 @@ func (r *Redis) ApplauseSince(time int64) (map[int]int, error) {
 	conn := r.pool.Get()
 	defer conn.Close()
 
-	meetingUsers, err := redis.Strings(conn.Do("ZRANGE", applauseKey, time, "+inf", "BYSCORE"))
+	meetingUsers, err := redis.Strings(conn.Do("ZRANGE", r.config.key(applauseKey), time, "+inf", "BYSCORE"))
 	if err != nil {
 		return nil, fmt.Errorf("getting applause from redis: %w", err)
 	}
@@ -154,8 +161,133 @@ func (r *Redis) ApplauseCleanOld(olderThen int64) error {
 	conn := r.pool.Get()
 	defer conn.Close()
 
-	if _, err := conn.Do("ZREMRANGEBYSCORE", applauseKey, 0, olderThen-1); err != nil {
+	if _, err := conn.Do("ZREMRANGEBYSCORE", r.config.key(applauseKey), 0, olderThen-1); err != nil {
 		return fmt.Errorf("removing old applause from redis: %w", err)
 	}
 	return nil
 }
+
+// AdminSaveStreams saves the encoded streams of an instance.
+func (r *Redis) AdminSaveStreams(instance string, streams []byte) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("HSET", r.config.key(adminStreamsKey), instance, streams); err != nil {
+		return fmt.Errorf("saving streams in redis: %w", err)
+	}
+	return nil
+}
+
+// AdminRegisterStreams saves the encoded streams of an instance, if the
+// instance is not saved yet.
+func (r *Redis) AdminRegisterStreams(instance string, streams []byte) (bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	created, err := redis.Bool(conn.Do("HSETNX", r.config.key(adminStreamsKey), instance, streams))
+	if err != nil {
+		return false, fmt.Errorf("registering instance in redis: %w", err)
+	}
+	return created, nil
+}
+
+// AdminStreams returns the saved streams of all instances.
+func (r *Redis) AdminStreams() (map[string][]byte, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", r.config.key(adminStreamsKey)))
+	if err != nil {
+		return nil, fmt.Errorf("getting streams from redis: %w", err)
+	}
+
+	out := make(map[string][]byte, len(values))
+	for instance, streams := range values {
+		out[instance] = []byte(streams)
+	}
+	return out, nil
+}
+
+// AdminRemoveStreams removes the saved streams of an instance.
+func (r *Redis) AdminRemoveStreams(instance string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("HDEL", r.config.key(adminStreamsKey), instance); err != nil {
+		return fmt.Errorf("removing streams from redis: %w", err)
+	}
+	return nil
+}
+
+// AdminPublish saves an admin command.
+func (r *Redis) AdminPublish(command []byte) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("XADD", r.config.key(adminKey), "MAXLEN", "~", adminMaxLen, "*", contentField, command); err != nil {
+		return fmt.Errorf("xadd: %w", err)
+	}
+	return nil
+}
+
+// AdminReceive is a blocking function that receives the admin commands, that
+// were published after the first call.
+//
+// It is expected, that only one goroutine is calling this function.
+func (r *Redis) AdminReceive(ctx context.Context) ([]byte, error) {
+	commands, err := r.admin.read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read admin command from redis: %w", err)
+	}
+	return commands[0], nil
+}
+
+// LimitAdd adds a stream to a sorted set for each key. The score is the expire
+// time.
+func (r *Redis) LimitAdd(keys []string, stream string, expires time.Time) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	for _, key := range keys {
+		if _, err := conn.Do("ZADD", r.config.key(limitKeyPrefix+key), expires.UnixMilli(), stream); err != nil {
+			return fmt.Errorf("adding stream to %s: %w", key, err)
+		}
+
+		// Remove the whole set, when all streams are expired.
+		if expires.After(time.Now()) {
+			if _, err := conn.Do("PEXPIREAT", r.config.key(limitKeyPrefix+key), expires.UnixMilli()); err != nil {
+				return fmt.Errorf("setting expire time of %s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// LimitStreams removes the expired streams of the key and returns the others.
+func (r *Redis) LimitStreams(key string) ([]string, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	if _, err := conn.Do("ZREMRANGEBYSCORE", r.config.key(limitKeyPrefix+key), "-inf", time.Now().UnixMilli()); err != nil {
+		return nil, fmt.Errorf("removing expired streams: %w", err)
+	}
+
+	streams, err := redis.Strings(conn.Do("ZRANGE", r.config.key(limitKeyPrefix+key), 0, -1))
+	if err != nil {
+		return nil, fmt.Errorf("getting streams: %w", err)
+	}
+	return streams, nil
+}
+
+// LimitRemove removes a stream from the keys.
+func (r *Redis) LimitRemove(keys []string, stream string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	for _, key := range keys {
+		if _, err := conn.Do("ZREM", r.config.key(limitKeyPrefix+key), stream); err != nil {
+			return fmt.Errorf("removing stream from %s: %w", key, err)
+		}
+	}
+	return nil
+}
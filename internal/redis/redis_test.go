@@ -2,11 +2,12 @@ package redis_test
 
 import (
 	"context"
-	"errors"
 	"testing"
 	"time"
 
+	"github.com/OpenSlides/openslides-icc-service/backendtest"
 	"github.com/OpenSlides/openslides-icc-service/internal/redis"
+	redigo "github.com/gomodule/redigo/redis"
 	"github.com/ory/dockertest/v3"
 )
 
@@ -23,6 +24,13 @@ func startRedis(t *testing.T) (string, func()) {
 		t.Fatalf("Could not start redis container: %s", err)
 	}
 
+	addr := "localhost:" + resource.GetPort("6379/tcp")
+	if err := pool.Retry(func() error {
+		return redis.New(redis.Config{Addr: addr}).Ping(context.Background())
+	}); err != nil {
+		t.Fatalf("Could not connect to redis: %s", err)
+	}
+
 	return resource.GetPort("6379/tcp"), func() {
 		if err = pool.Purge(resource); err != nil {
 			t.Fatalf("Could not purge redis container: %s", err)
@@ -34,100 +42,33 @@ func TestICC(t *testing.T) {
 	port, stopRedis := startRedis(t)
 	defer stopRedis()
 
-	redisConn := redis.New("localhost:" + port)
-	redisConn.Wait(context.Background())
-
-	t.Run("Receive blocks", func(t *testing.T) {
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-
-		done := make(chan error)
-		go func() {
-			_, err := redisConn.NotifyReceive(ctx)
-			done <- err
-		}()
-
-		timer := time.NewTimer(10 * time.Millisecond)
-		defer timer.Stop()
-		select {
-		case err := <-done:
-			t.Errorf("ReceiveICC returned with error: %v. Expected it to block.", err)
-		case <-timer.C:
-		}
-	})
-
-	t.Run("Receive unblocks on cancel", func(t *testing.T) {
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+	addr := "localhost:" + port
+	redisConn := redis.New(redis.Config{Addr: addr})
 
-		done := make(chan error)
-		go func() {
-			_, err := redisConn.NotifyReceive(ctx)
-			done <- err
-		}()
-
-		cancel()
-
-		timer := time.NewTimer(10 * time.Millisecond)
-		defer timer.Stop()
-		select {
-		case err := <-done:
-			if !errors.Is(err, context.Canceled) {
-				t.Errorf("ReceiveICC returned with error: %v, expected context.Canceled", err)
-			}
-		case <-timer.C:
-			t.Errorf("ReceiveICC did not unblock after context was canceled.")
+	// newBackend returns a connection to an empty redis.
+	newBackend := func(t *testing.T) *redis.Redis {
+		conn, err := redigo.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("connecting to redis: %v", err)
 		}
-	})
-
-	t.Run("Receive gets a send message", func(t *testing.T) {
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
+		defer conn.Close()
 
-		type receiveReturn struct {
-			message []byte
-			err     error
+		if _, err := conn.Do("FLUSHALL"); err != nil {
+			t.Fatalf("flushing redis: %v", err)
 		}
+		return redis.New(redis.Config{Addr: addr})
+	}
 
-		done := make(chan receiveReturn)
-		go func() {
-			message, err := redisConn.NotifyReceive(ctx)
-			done <- receiveReturn{message, err}
-		}()
-
-		// Wait for ReceiveICC to be called.
-		time.Sleep(10 * time.Millisecond)
-
-		redisConn.NotifyPublish([]byte("my message"))
-
-		timer := time.NewTimer(50 * time.Millisecond)
-		defer timer.Stop()
-
-		select {
-		case data := <-done:
-			if err := data.err; err != nil {
-				t.Errorf("ReceiveICC returned unexpected error: %v", err)
-			}
-
-			if string(data.message) != "my message" {
-				t.Errorf("RecieveICC returned message `%s`, expected `my message`", data.message)
-			}
-
-		case <-timer.C:
-			t.Errorf("ReceiveICC did not unblock after message was send.")
-		}
+	t.Run("Notify", func(t *testing.T) {
+		backendtest.Notify(t, func(t *testing.T) backendtest.NotifyBackend {
+			return newBackend(t)
+		})
 	})
 
-	t.Run("Receive empty applause", func(t *testing.T) {
-		applause, err := redisConn.ApplauseSince(1000)
-
-		if err != nil {
-			t.Fatalf("receiveApplause returned unexpected error: %v", err)
-		}
-
-		if len(applause) != 0 {
-			t.Errorf("receiveApplause returned %d, expected 0", applause)
-		}
+	t.Run("Applause", func(t *testing.T) {
+		backendtest.Applause(t, func(t *testing.T) backendtest.ApplauseBackend {
+			return newBackend(t)
+		})
 	})
 
 	t.Run("Delete applause", func(t *testing.T) {
@@ -172,109 +113,137 @@ func TestICC(t *testing.T) {
 		}
 	})
 
-	t.Run("Receive applause for one user", func(t *testing.T) {
-		defer redisConn.ApplauseCleanOld(1000)
-
-		if err := redisConn.ApplausePublish(1, 1, 10); err != nil {
-			t.Fatalf("sending applause: %v", err)
+	t.Run("Save and remove admin streams", func(t *testing.T) {
+		if err := redisConn.AdminSaveStreams("instance1", []byte("streams")); err != nil {
+			t.Fatalf("saving streams: %v", err)
 		}
 
-		applause, err := redisConn.ApplauseSince(10)
-
+		streams, err := redisConn.AdminStreams()
 		if err != nil {
-			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+			t.Fatalf("getting streams: %v", err)
 		}
 
-		if applause[1] != 1 {
-			t.Errorf("receiveApplause returned %d, expected 1", applause)
+		if string(streams["instance1"]) != "streams" {
+			t.Errorf("got streams %q, expected `streams`", streams["instance1"])
 		}
-	})
 
-	t.Run("Receive applause for one user twice", func(t *testing.T) {
-		defer redisConn.ApplauseCleanOld(1000)
-
-		if err := redisConn.ApplausePublish(1, 1, 10); err != nil {
-			t.Fatalf("sending applause: %v", err)
+		if err := redisConn.AdminRemoveStreams("instance1"); err != nil {
+			t.Fatalf("removing streams: %v", err)
 		}
 
-		if err := redisConn.ApplausePublish(1, 1, 11); err != nil {
-			t.Fatalf("sending applause: %v", err)
+		streams, err = redisConn.AdminStreams()
+		if err != nil {
+			t.Fatalf("getting streams: %v", err)
 		}
 
-		applause, err := redisConn.ApplauseSince(10)
+		if _, ok := streams["instance1"]; ok {
+			t.Errorf("streams of instance1 were not removed")
+		}
+	})
 
+	t.Run("Register admin streams", func(t *testing.T) {
+		created, err := redisConn.AdminRegisterStreams("instance2", []byte("first"))
 		if err != nil {
-			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+			t.Fatalf("registering streams: %v", err)
 		}
 
-		if applause[1] != 1 {
-			t.Errorf("receiveApplause returned %d, expected 1", applause)
+		if !created {
+			t.Errorf("first registration returned false")
 		}
-	})
 
-	t.Run("Receive applause for one user to old", func(t *testing.T) {
-		defer redisConn.ApplauseCleanOld(1000)
-
-		if err := redisConn.ApplausePublish(1, 1, 9); err != nil {
-			t.Fatalf("sending applause: %v", err)
+		created, err = redisConn.AdminRegisterStreams("instance2", []byte("second"))
+		if err != nil {
+			t.Fatalf("registering streams again: %v", err)
 		}
 
-		applause, err := redisConn.ApplauseSince(10)
+		if created {
+			t.Errorf("second registration returned true")
+		}
 
+		streams, err := redisConn.AdminStreams()
 		if err != nil {
-			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+			t.Fatalf("getting streams: %v", err)
 		}
 
-		if applause[1] != 0 {
-			t.Errorf("receiveApplause returned %d, expected 0", applause)
+		if string(streams["instance2"]) != "first" {
+			t.Errorf("got streams %q, expected `first`", streams["instance2"])
+		}
+
+		if err := redisConn.AdminRemoveStreams("instance2"); err != nil {
+			t.Fatalf("removing streams: %v", err)
 		}
 	})
 
-	t.Run("Receive applause for two users", func(t *testing.T) {
-		defer redisConn.ApplauseCleanOld(1000)
+	t.Run("Receive admin command", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 
-		if err := redisConn.ApplausePublish(1, 1, 10); err != nil {
-			t.Fatalf("sending applause: %v", err)
+		type receiveReturn struct {
+			command []byte
+			err     error
 		}
 
-		if err := redisConn.ApplausePublish(1, 2, 10); err != nil {
-			t.Fatalf("sending applause: %v", err)
-		}
+		done := make(chan receiveReturn)
+		go func() {
+			command, err := redisConn.AdminReceive(ctx)
+			done <- receiveReturn{command, err}
+		}()
 
-		applause, err := redisConn.ApplauseSince(10)
+		// Wait for AdminReceive to be called.
+		time.Sleep(10 * time.Millisecond)
 
-		if err != nil {
-			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+		if err := redisConn.AdminPublish([]byte("my command")); err != nil {
+			t.Fatalf("publish command: %v", err)
 		}
 
-		if applause[1] != 2 {
-			t.Errorf("receiveApplause returned %d, expected 2", applause)
+		timer := time.NewTimer(50 * time.Millisecond)
+		defer timer.Stop()
+
+		select {
+		case data := <-done:
+			if err := data.err; err != nil {
+				t.Errorf("AdminReceive returned unexpected error: %v", err)
+			}
+
+			if string(data.command) != "my command" {
+				t.Errorf("AdminReceive returned command `%s`, expected `my command`", data.command)
+			}
+
+		case <-timer.C:
+			t.Errorf("AdminReceive did not unblock after command was send.")
 		}
 	})
 
-	t.Run("Receive applause for one user in two meetings", func(t *testing.T) {
-		defer redisConn.ApplauseCleanOld(1000)
+	t.Run("Limit streams", func(t *testing.T) {
+		keys := []string{"user:1", "meeting:1"}
+		if err := redisConn.LimitAdd(keys, "stream1", time.Now().Add(time.Minute)); err != nil {
+			t.Fatalf("adding stream1: %v", err)
+		}
 
-		if err := redisConn.ApplausePublish(1, 1, 10); err != nil {
-			t.Fatalf("sending applause: %v", err)
+		if err := redisConn.LimitAdd(keys, "expired", time.Now().Add(-time.Minute)); err != nil {
+			t.Fatalf("adding expired stream: %v", err)
 		}
 
-		if err := redisConn.ApplausePublish(2, 2, 10); err != nil {
-			t.Fatalf("sending applause: %v", err)
+		streams, err := redisConn.LimitStreams("meeting:1")
+		if err != nil {
+			t.Fatalf("getting streams: %v", err)
 		}
 
-		applause, err := redisConn.ApplauseSince(10)
+		if len(streams) != 1 || streams[0] != "stream1" {
+			t.Errorf("got streams %v, expected [stream1]", streams)
+		}
 
-		if err != nil {
-			t.Fatalf("receiveApplause returned unexpected error: %v", err)
+		if err := redisConn.LimitRemove(keys, "stream1"); err != nil {
+			t.Fatalf("removing stream: %v", err)
 		}
 
-		if applause[1] != 1 {
-			t.Errorf("receiveApplause returned %d, expected 2", applause)
+		streams, err = redisConn.LimitStreams("user:1")
+		if err != nil {
+			t.Fatalf("getting streams: %v", err)
 		}
 
-		if applause[2] != 1 {
-			t.Errorf("receiveApplause returned %d, expected 2", applause)
+		if len(streams) != 0 {
+			t.Errorf("got streams %v, expected none", streams)
 		}
 	})
 }
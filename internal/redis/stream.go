@@ -2,74 +2,134 @@ package redis
 
 import (
 	"fmt"
+
+	"github.com/gomodule/redigo/redis"
 )
 
-// stream parses a redis stream object.
-func stream(reply interface{}, err error) (string, []byte, error) {
+// streamEntry is one entry of a redis stream.
+type streamEntry struct {
+	id string
+
+	// fields are the field-value pairs of the entry. If a field is set many
+	// times, the last value is used. An entry, that was deleted while it was
+	// read, has no fields.
+	fields map[string][]byte
+}
+
+// xread decodes the reply of XREAD. It returns the entries of each stream by
+// the key of the stream.
+//
+// XREAD returns nil, when it reaches the block timeout. This is returned as an
+// empty map.
+func xread(reply any, err error) (map[string][]streamEntry, error) {
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
+
 	if reply == nil {
-		return "", nil, fmt.Errorf("no data returned")
+		return map[string][]streamEntry{}, nil
 	}
-	streams, ok := reply.([]interface{})
-	if !ok {
-		return "", nil, fmt.Errorf("invalid input. Data has to be a list, not %T", reply)
-	}
-	if len(streams) == 0 {
-		return "", nil, fmt.Errorf("invalid input. No stream in data")
+
+	streams, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decoding list of streams: %w", err)
 	}
-	stream1, ok := streams[0].([]interface{})
-	if !ok {
-		return "", nil, fmt.Errorf("invalid input. Stream has to be a two-tuple, not %T", streams[0])
+
+	out := make(map[string][]streamEntry, len(streams))
+	for i, s := range streams {
+		pair, err := redis.Values(s, nil)
+		if err != nil {
+			return nil, fmt.Errorf("decoding stream %d: %w", i, err)
+		}
+
+		if len(pair) != 2 {
+			return nil, fmt.Errorf("decoding stream %d: got %d elements, expected key and entries", i, len(pair))
+		}
+
+		key, err := redis.String(pair[0], nil)
+		if err != nil {
+			return nil, fmt.Errorf("decoding key of stream %d: %w", i, err)
+		}
+
+		entries, err := xrange(pair[1], nil)
+		if err != nil {
+			return nil, fmt.Errorf("decoding stream %s: %w", key, err)
+		}
+
+		out[key] = append(out[key], entries...)
 	}
-	if len(stream1) != 2 {
-		return "", nil, fmt.Errorf("invalid input. Stream has to be a two-tuple, got %d elements", len(stream1))
+	return out, nil
+}
+
+// xrange decodes the reply of XRANGE or XREVRANGE. The entries of one stream in
+// the reply of XREAD have the same format.
+func xrange(reply any, err error) ([]streamEntry, error) {
+	if err != nil {
+		return nil, err
 	}
-	data, ok := stream1[1].([]interface{})
-	if !ok {
-		return "", nil, fmt.Errorf("invalid input. Stream data has to be a list, got %T", stream1[1])
+
+	values, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decoding list of entries: %w", err)
 	}
 
-	if len(data) != 1 {
-		return "", nil, fmt.Errorf("invalid input. Expected got %d stream data, expected 1", len(data))
+	entries := make([]streamEntry, len(values))
+	for i, v := range values {
+		entry, err := streamElement(v)
+		if err != nil {
+			return nil, fmt.Errorf("decoding entry %d: %w", i, err)
+		}
+		entries[i] = entry
 	}
+	return entries, nil
+}
 
-	v := data[0]
-	element, ok := v.([]interface{})
-	if !ok {
-		return "", nil, fmt.Errorf("invalid input. Stream element has to be a two-tuple, got %T", v)
+// streamElement decodes one entry of a stream. It is a tuple of the id and a
+// flat list of field-value pairs.
+func streamElement(v any) (streamEntry, error) {
+	element, err := redis.Values(v, nil)
+	if err != nil {
+		return streamEntry{}, err
 	}
+
 	if len(element) != 2 {
-		return "", nil, fmt.Errorf("invalid input. Stream element has to be a two-tuple, got %d elements", len(element))
+		return streamEntry{}, fmt.Errorf("got %d elements, expected id and fields", len(element))
+	}
+
+	id, err := redis.String(element[0], nil)
+	if err != nil {
+		return streamEntry{}, fmt.Errorf("decoding id: %w", err)
 	}
-	id, ok := element[0].([]byte)
-	if !ok {
-		return "", nil, fmt.Errorf("invalid input. Stream ID has to be a string, got %T", element[0])
+
+	if id == "" {
+		return streamEntry{}, fmt.Errorf("empty id")
 	}
-	kv, ok := element[1].([]interface{})
-	if !ok {
-		return "", nil, fmt.Errorf("invalid input. Key values has to be a list of strings, got %T", element[1])
+
+	entry := streamEntry{id: id, fields: map[string][]byte{}}
+	if element[1] == nil {
+		return entry, nil
 	}
+
+	kv, err := redis.Values(element[1], nil)
+	if err != nil {
+		return streamEntry{}, fmt.Errorf("decoding fields of %s: %w", id, err)
+	}
+
 	if len(kv)%2 != 0 {
-		return "", nil, fmt.Errorf("invalid input. Odd number of key value pairs")
+		return streamEntry{}, fmt.Errorf("decoding fields of %s: odd number of field-value pairs", id)
 	}
 
-	for i := 0; i < len(kv)-1; i += 2 {
-		key, ok := kv[i].([]byte)
-		if !ok {
-			return "", nil, fmt.Errorf("invalid input. Key has to be a string, got %T", kv[i])
+	for i := 0; i < len(kv); i += 2 {
+		field, err := redis.String(kv[i], nil)
+		if err != nil {
+			return streamEntry{}, fmt.Errorf("decoding field %d of %s: %w", i/2, id, err)
 		}
-		value, ok := kv[i+1].([]byte)
-		if !ok {
-			return "", nil, fmt.Errorf("invalid input. Values has to be a []byte, got %T", kv[i+1])
-		}
-		switch string(key) {
-		case "content":
-			return string(id), value, nil
-		default:
-			return "", nil, fmt.Errorf("invalid input. Unknown key \"%s\"", key)
+
+		value, err := redis.Bytes(kv[i+1], nil)
+		if err != nil {
+			return streamEntry{}, fmt.Errorf("decoding value of %s in %s: %w", field, id, err)
 		}
+		entry.fields[field] = value
 	}
-	return "", nil, fmt.Errorf("invalid input. `content` not in response")
+	return entry, nil
 }
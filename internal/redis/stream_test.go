@@ -0,0 +1,348 @@
+package redis
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestXRead(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		reply  any
+		expect map[string][]streamEntry
+		err    bool
+	}{
+		{
+			"Timeout",
+			nil,
+			map[string][]streamEntry{},
+			false,
+		},
+		{
+			"One entry",
+			[]any{
+				[]any{[]byte("icc_notify"), []any{
+					[]any{[]byte("1-0"), []any{[]byte("content"), []byte("hello")}},
+				}},
+			},
+			map[string][]streamEntry{
+				"icc_notify": {{id: "1-0", fields: map[string][]byte{"content": []byte("hello")}}},
+			},
+			false,
+		},
+		{
+			"Many entries and fields",
+			[]any{
+				[]any{[]byte("icc_notify"), []any{
+					[]any{[]byte("1-0"), []any{[]byte("content"), []byte("a"), []byte("other"), []byte("b")}},
+					[]any{[]byte("2-0"), []any{[]byte("content"), []byte("c")}},
+				}},
+			},
+			map[string][]streamEntry{
+				"icc_notify": {
+					{id: "1-0", fields: map[string][]byte{"content": []byte("a"), "other": []byte("b")}},
+					{id: "2-0", fields: map[string][]byte{"content": []byte("c")}},
+				},
+			},
+			false,
+		},
+		{
+			"Many streams",
+			[]any{
+				[]any{[]byte("a"), []any{[]any{[]byte("1-0"), []any{[]byte("content"), []byte("x")}}}},
+				[]any{[]byte("b"), []any{[]any{[]byte("2-0"), []any{[]byte("content"), []byte("y")}}}},
+			},
+			map[string][]streamEntry{
+				"a": {{id: "1-0", fields: map[string][]byte{"content": []byte("x")}}},
+				"b": {{id: "2-0", fields: map[string][]byte{"content": []byte("y")}}},
+			},
+			false,
+		},
+		{
+			"Deleted entry",
+			[]any{
+				[]any{[]byte("icc_notify"), []any{[]any{[]byte("1-0"), nil}}},
+			},
+			map[string][]streamEntry{
+				"icc_notify": {{id: "1-0", fields: map[string][]byte{}}},
+			},
+			false,
+		},
+		{
+			"Odd fields",
+			[]any{
+				[]any{[]byte("icc_notify"), []any{[]any{[]byte("1-0"), []any{[]byte("content")}}}},
+			},
+			nil,
+			true,
+		},
+		{
+			"Empty id",
+			[]any{
+				[]any{[]byte("icc_notify"), []any{[]any{[]byte(""), []any{}}}},
+			},
+			nil,
+			true,
+		},
+		{
+			"Stream without entries",
+			[]any{[]any{[]byte("icc_notify")}},
+			nil,
+			true,
+		},
+		{
+			"Not a list",
+			int64(5),
+			nil,
+			true,
+		},
+		{
+			"Redis error",
+			redis.Error("WRONGTYPE"),
+			nil,
+			true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := xread(tt.reply, nil)
+
+			if tt.err {
+				if err == nil {
+					t.Errorf("xread returned no error")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("xread: %v", err)
+			}
+
+			if !equalStreams(got, tt.expect) {
+				t.Errorf("xread returned %v, expected %v", got, tt.expect)
+			}
+		})
+	}
+
+	t.Run("Error from the command", func(t *testing.T) {
+		myErr := errors.New("my error")
+
+		if _, err := xread(nil, myErr); !errors.Is(err, myErr) {
+			t.Errorf("xread returned error `%v`, expected `%v`", err, myErr)
+		}
+	})
+}
+
+func TestXRange(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		got, err := xrange([]any{}, nil)
+		if err != nil {
+			t.Fatalf("xrange: %v", err)
+		}
+
+		if len(got) != 0 {
+			t.Errorf("xrange returned %v, expected no entries", got)
+		}
+	})
+
+	t.Run("Entries", func(t *testing.T) {
+		got, err := xrange([]any{
+			[]any{[]byte("1-0"), []any{[]byte("content"), []byte("a")}},
+			[]any{[]byte("1-1"), []any{[]byte("content"), []byte("b"), []byte("content"), []byte("c")}},
+		}, nil)
+		if err != nil {
+			t.Fatalf("xrange: %v", err)
+		}
+
+		expect := []streamEntry{
+			{id: "1-0", fields: map[string][]byte{"content": []byte("a")}},
+			{id: "1-1", fields: map[string][]byte{"content": []byte("c")}},
+		}
+		if !equalEntries(got, expect) {
+			t.Errorf("xrange returned %v, expected %v", got, expect)
+		}
+	})
+
+	t.Run("Nil", func(t *testing.T) {
+		if _, err := xrange(nil, nil); err == nil {
+			t.Errorf("xrange returned no error")
+		}
+	})
+}
+
+func FuzzXRead(f *testing.F) {
+	f.Add("*-1\r\n")
+	f.Add("*1\r\n*2\r\n$10\r\nicc_notify\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$7\r\ncontent\r\n$5\r\nhello\r\n")
+	f.Add("*1\r\n*2\r\n$10\r\nicc_notify\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*-1\r\n")
+	f.Add("*1\r\n*2\r\n+a\r\n*1\r\n*2\r\n:1\r\n*1\r\n$1\r\nx\r\n")
+	f.Add("-ERR wrong\r\n")
+
+	f.Fuzz(func(t *testing.T, data string) {
+		reply, ok := decodeRESP(data)
+		if !ok {
+			return
+		}
+
+		streams, err := xread(reply, nil)
+		if err != nil {
+			return
+		}
+
+		for _, entries := range streams {
+			checkEntries(t, entries)
+		}
+	})
+}
+
+func FuzzXRange(f *testing.F) {
+	f.Add("*0\r\n")
+	f.Add("*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$7\r\ncontent\r\n$5\r\nhello\r\n")
+	f.Add("*1\r\n*2\r\n$3\r\n1-0\r\n*1\r\n$7\r\ncontent\r\n")
+	f.Add("*2\r\n*2\r\n$3\r\n1-0\r\n*-1\r\n*2\r\n$0\r\n\r\n*0\r\n")
+
+	f.Fuzz(func(t *testing.T, data string) {
+		reply, ok := decodeRESP(data)
+		if !ok {
+			return
+		}
+
+		entries, err := xrange(reply, nil)
+		if err != nil {
+			return
+		}
+
+		checkEntries(t, entries)
+	})
+}
+
+func checkEntries(t *testing.T, entries []streamEntry) {
+	t.Helper()
+
+	for _, entry := range entries {
+		if entry.id == "" {
+			t.Errorf("got entry with an empty id")
+		}
+
+		if entry.fields == nil {
+			t.Errorf("entry %s has nil fields", entry.id)
+		}
+	}
+}
+
+// maxRESPDepth is the maximum nesting of arrays, that decodeRESP accepts.
+const maxRESPDepth = 32
+
+// decodeRESP decodes a reply in the redis protocol to the values, that redigo
+// returns. It is used to turn fuzz input into replies.
+//
+// ok is false, if data is not a valid reply.
+func decodeRESP(data string) (reply any, ok bool) {
+	reply, rest, ok := decodeRESPValue(data, 0)
+	if !ok || rest != "" {
+		return nil, false
+	}
+	return reply, true
+}
+
+func decodeRESPValue(data string, depth int) (reply any, rest string, ok bool) {
+	if depth > maxRESPDepth || data == "" {
+		return nil, "", false
+	}
+
+	line, rest, found := strings.Cut(data[1:], "\r\n")
+	if !found {
+		return nil, "", false
+	}
+
+	switch data[0] {
+	case '+':
+		return line, rest, true
+
+	case '-':
+		return redis.Error(line), rest, true
+
+	case ':':
+		n, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return nil, "", false
+		}
+		return n, rest, true
+
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 {
+			return nil, "", false
+		}
+
+		if n == -1 {
+			return nil, rest, true
+		}
+
+		if len(rest) < n+2 || rest[n:n+2] != "\r\n" {
+			return nil, "", false
+		}
+		return []byte(rest[:n]), rest[n+2:], true
+
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 {
+			return nil, "", false
+		}
+
+		if n == -1 {
+			return nil, rest, true
+		}
+
+		// Each element needs at least three bytes.
+		if n > len(rest)/3 {
+			return nil, "", false
+		}
+
+		values := make([]any, n)
+		for i := range values {
+			values[i], rest, ok = decodeRESPValue(rest, depth+1)
+			if !ok {
+				return nil, "", false
+			}
+		}
+		return values, rest, true
+
+	default:
+		return nil, "", false
+	}
+}
+
+func equalStreams(a, b map[string][]streamEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, entries := range a {
+		if !equalEntries(entries, b[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalEntries(a, b []streamEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].id != b[i].id || len(a[i].fields) != len(b[i].fields) {
+			return false
+		}
+
+		for field, value := range a[i].fields {
+			if string(b[i].fields[field]) != string(value) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/client"
+)
+
+// loadtestMessageName is the name of the notify messages sent by the load
+// test.
+const loadtestMessageName = "icc-loadtest"
+
+// loadtestCmd is the subcommand `loadtest`.
+type loadtestCmd struct {
+	clientFlags      `embed:""`
+	Streams          int           `help:"Number of notify streams" short:"n" default:"100"`
+	Meetings         int           `help:"Number of meetings. The streams are distributed over the meetings with the ids 1 to M." short:"m" default:"10"`
+	Rate             float64       `help:"Published notify messages per second" short:"r" default:"10"`
+	ApplauseBurst    int           `help:"Applause requests per meeting in each burst. 0 disables applause." default:"10"`
+	ApplauseInterval time.Duration `help:"Time between two applause bursts" default:"5s"`
+	Duration         time.Duration `help:"Time to publish messages" short:"d" default:"30s"`
+	Wait             time.Duration `help:"Time to wait for outstanding messages after the last publish" default:"2s"`
+}
+
+// loadtestPayload is the content of each notify message.
+type loadtestPayload struct {
+	ID   int   `json:"id"`
+	Sent int64 `json:"sent"`
+}
+
+// loadtestStats collects the results of a load test.
+type loadtestStats struct {
+	mu sync.Mutex
+
+	streamsOpen    int
+	streamsFailed  int
+	streamsClosed  int
+	meetingStreams map[int]int
+
+	published    int
+	publishError int
+	publishTime  time.Duration
+	expected     int
+	delivered    int
+	latencies    []time.Duration
+
+	applauseSent      int
+	applauseErrors    int
+	applauseLatencies []time.Duration
+}
+
+func (s *loadtestStats) add(f func(s *loadtestStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(s)
+}
+
+func (cmd loadtestCmd) run(ctx context.Context, w io.Writer) error {
+	if cmd.Streams < 1 || cmd.Meetings < 1 || cmd.Rate <= 0 {
+		return fmt.Errorf("streams, meetings and rate have to be positive")
+	}
+
+	c := cmd.client()
+	// Each stream needs its own connection.
+	c.HTTPClient.Transport.(*http.Transport).MaxIdleConnsPerHost = cmd.Streams
+
+	stats := &loadtestStats{meetingStreams: make(map[int]int)}
+
+	streamCtx, stopStreams := context.WithCancel(ctx)
+	defer stopStreams()
+
+	fmt.Fprintf(w, "Opening %d streams in %d meetings\n", cmd.Streams, cmd.Meetings)
+	var opened sync.WaitGroup
+	var streamsDone sync.WaitGroup
+	for i := 0; i < cmd.Streams; i++ {
+		meetingID := i%cmd.Meetings + 1
+		opened.Add(1)
+		streamsDone.Add(1)
+		go func() {
+			defer streamsDone.Done()
+			cmd.stream(streamCtx, c.HTTPClient, meetingID, stats, opened.Done)
+		}()
+	}
+	opened.Wait()
+
+	fmt.Fprintf(w, "Publishing %.1f messages per second for %s\n", cmd.Rate, cmd.Duration)
+	publishCtx, stopPublish := context.WithTimeout(ctx, cmd.Duration)
+	defer stopPublish()
+
+	var background sync.WaitGroup
+	if cmd.ApplauseBurst > 0 {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			cmd.applause(publishCtx, c, stats)
+		}()
+	}
+
+	cmd.publish(publishCtx, c, stats)
+	background.Wait()
+
+	select {
+	case <-time.After(cmd.Wait):
+	case <-ctx.Done():
+	}
+	stopStreams()
+	streamsDone.Wait()
+
+	stats.report(w)
+	return nil
+}
+
+// stream opens one notify stream and counts the received messages. ready is
+// called, when the stream is open or could not be opened.
+func (cmd loadtestCmd) stream(ctx context.Context, httpClient *http.Client, meetingID int, stats *loadtestStats, ready func()) {
+	readyOnce := sync.OnceFunc(ready)
+	defer readyOnce()
+
+	u := cmd.baseURL() + client.Path + "/notify?" + url.Values{"meeting_id": {fmt.Sprint(meetingID)}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		stats.add(func(s *loadtestStats) { s.streamsFailed++ })
+		return
+	}
+
+	if err := cmd.auth(req); err != nil {
+		stats.add(func(s *loadtestStats) { s.streamsFailed++ })
+		return
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil || resp.StatusCode != 200 {
+		if err == nil {
+			resp.Body.Close()
+		}
+		stats.add(func(s *loadtestStats) { s.streamsFailed++ })
+		return
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	// The first line is the channel id.
+	if !scanner.Scan() {
+		stats.add(func(s *loadtestStats) { s.streamsFailed++ })
+		return
+	}
+	stats.add(func(s *loadtestStats) {
+		s.streamsOpen++
+		s.meetingStreams[meetingID]++
+	})
+	readyOnce()
+
+	for scanner.Scan() {
+		received := time.Now()
+
+		var message client.OutMessage
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil || message.Name != loadtestMessageName {
+			continue
+		}
+
+		var payload loadtestPayload
+		if err := json.Unmarshal(message.Message, &payload); err != nil {
+			continue
+		}
+
+		stats.add(func(s *loadtestStats) {
+			s.delivered++
+			s.latencies = append(s.latencies, received.Sub(time.Unix(0, payload.Sent)))
+		})
+	}
+
+	if ctx.Err() == nil {
+		// The server closed the stream.
+		stats.add(func(s *loadtestStats) { s.streamsClosed++ })
+	}
+}
+
+// publish sends notify messages to the meetings in turn until ctx is done.
+//
+// Each message is sent in its own goroutine, so slow requests do not lower the
+// rate. It returns, when all requests are finished.
+func (cmd loadtestCmd) publish(ctx context.Context, c *client.Client, stats *loadtestStats) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / cmd.Rate))
+	defer ticker.Stop()
+
+	started := time.Now()
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		stats.add(func(s *loadtestStats) { s.publishTime = time.Since(started) })
+	}()
+
+	for id := 0; ; id++ {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			meetingID := id%cmd.Meetings + 1
+			payload, _ := json.Marshal(loadtestPayload{ID: id, Sent: time.Now().UnixNano()})
+
+			err := c.NotifyPublish(ctx, client.Message{
+				ToMeeting: meetingID,
+				Name:      loadtestMessageName,
+				Message:   payload,
+			})
+
+			stats.add(func(s *loadtestStats) {
+				if err != nil {
+					if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+						s.publishError++
+					}
+					return
+				}
+				s.published++
+				s.expected += s.meetingStreams[meetingID]
+			})
+		}()
+	}
+}
+
+// applause sends bursts of applause to all meetings until ctx is done.
+func (cmd loadtestCmd) applause(ctx context.Context, c *client.Client, stats *loadtestStats) {
+	ticker := time.NewTicker(cmd.ApplauseInterval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for meetingID := 1; meetingID <= cmd.Meetings; meetingID++ {
+			for i := 0; i < cmd.ApplauseBurst; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					started := time.Now()
+					err := c.ApplauseSend(ctx, meetingID)
+					duration := time.Since(started)
+
+					stats.add(func(s *loadtestStats) {
+						if err != nil {
+							if ctx.Err() == nil {
+								s.applauseErrors++
+							}
+							return
+						}
+						s.applauseSent++
+						s.applauseLatencies = append(s.applauseLatencies, duration)
+					})
+				}()
+			}
+		}
+		wg.Wait()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// report writes the result of the load test.
+func (s *loadtestStats) report(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Streams:   %d open, %d failed, %d closed by the server\n", s.streamsOpen, s.streamsFailed, s.streamsClosed)
+	fmt.Fprintf(w, "Published: %d messages, %d errors, %.1f per second\n", s.published, s.publishError, s.publishRate())
+	fmt.Fprintf(w, "Delivered: %d of %d, %d dropped\n", s.delivered, s.expected, max(s.expected-s.delivered, 0))
+	fmt.Fprintf(w, "Latency:   %s\n", percentiles(s.latencies))
+	fmt.Fprintf(w, "Applause:  %d sent, %d errors\n", s.applauseSent, s.applauseErrors)
+	fmt.Fprintf(w, "Applause request: %s\n", percentiles(s.applauseLatencies))
+}
+
+// publishRate returns the achieved number of published messages per second.
+func (s *loadtestStats) publishRate() float64 {
+	if s.publishTime <= 0 {
+		return 0
+	}
+	return float64(s.published) / s.publishTime.Seconds()
+}
+
+// percentiles returns the p50, p90, p99 and max of the durations.
+func percentiles(durations []time.Duration) string {
+	if len(durations) == 0 {
+		return "no data"
+	}
+
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+
+	at := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+
+	return fmt.Sprintf(
+		"p50 %s, p90 %s, p99 %s, max %s",
+		at(0.5).Round(time.Microsecond),
+		at(0.9).Round(time.Microsecond),
+		at(0.99).Round(time.Microsecond),
+		sorted[len(sorted)-1].Round(time.Microsecond),
+	)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadtest(t *testing.T) {
+	cmd := loadtestCmd{
+		clientFlags:      newTestFlags(t, 1),
+		Streams:          6,
+		Meetings:         2,
+		Rate:             50,
+		ApplauseBurst:    2,
+		ApplauseInterval: 50 * time.Millisecond,
+		Duration:         200 * time.Millisecond,
+		Wait:             200 * time.Millisecond,
+	}
+
+	var out strings.Builder
+	if err := cmd.run(context.Background(), &out); err != nil {
+		t.Fatalf("loadtest: %v", err)
+	}
+
+	for _, expect := range []string{
+		"Streams:   6 open, 0 failed, 0 closed by the server",
+		"Published: ",
+		" per second\n",
+		", 0 dropped",
+		"Latency:   p50 ",
+		", 0 errors\nApplause request: p50 ",
+	} {
+		if !strings.Contains(out.String(), expect) {
+			t.Errorf("report does not contain `%s`:\n%s", expect, out.String())
+		}
+	}
+
+	if strings.Contains(out.String(), "Published: 0 messages") {
+		t.Errorf("no message was published:\n%s", out.String())
+	}
+}
+
+func TestLoadtestPublishRate(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		published int
+		duration  time.Duration
+		expect    float64
+	}{
+		{"Not started", 0, 0, 0},
+		{"Requested rate", 20, 2 * time.Second, 10},
+		{"Slow requests", 5, 2 * time.Second, 2.5},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := loadtestStats{published: tt.published, publishTime: tt.duration}
+
+			if got := stats.publishRate(); got != tt.expect {
+				t.Errorf("publishRate() returned %f, expected %f", got, tt.expect)
+			}
+		})
+	}
+}
@@ -4,65 +4,156 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/peb-adr/openslides-go/auth"
+	"github.com/peb-adr/openslides-go/datastore/dskey"
 	"github.com/peb-adr/openslides-go/environment"
 	messageBusRedis "github.com/peb-adr/openslides-go/redis"
+	"github.com/OpenSlides/openslides-icc-service/internal/admin"
 	"github.com/OpenSlides/openslides-icc-service/internal/applause"
+	"github.com/OpenSlides/openslides-icc-service/internal/connection"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccerror"
 	"github.com/OpenSlides/openslides-icc-service/internal/icchttp"
 	"github.com/OpenSlides/openslides-icc-service/internal/icclog"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccmetric"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccopenapi"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccstatus"
+	"github.com/OpenSlides/openslides-icc-service/internal/icctls"
+	"github.com/OpenSlides/openslides-icc-service/internal/icctrace"
+	"github.com/OpenSlides/openslides-icc-service/internal/limit"
+	"github.com/OpenSlides/openslides-icc-service/internal/memory"
 	"github.com/OpenSlides/openslides-icc-service/internal/notify"
+	"github.com/OpenSlides/openslides-icc-service/internal/permission"
+	"github.com/OpenSlides/openslides-icc-service/internal/postgres"
 	"github.com/OpenSlides/openslides-icc-service/internal/redis"
 	"github.com/alecthomas/kong"
 )
 
-//go:generate  sh -c "go run main.go build-doc > environment.md"
+//go:generate  sh -c "go run . build-doc > environment.md"
 
 var (
 	envICCServicePort = environment.NewVariable("ICC_PORT", "9007", "Port on which the service listen on.")
+	envICCBackend     = environment.NewVariable("ICC_BACKEND", "redis", "Where the icc messages are saved. One of redis, postgres or memory. The postgres backend uses the database of the datastore. The memory backend only works with one instance of the service.")
 	envICCRedisHost   = environment.NewVariable("CACHE_HOST", "localhost", "The host of the redis instance to save icc messages.")
 	envICCRedisPort   = environment.NewVariable("CACHE_PORT", "6379", "The port of the redis instance to save icc messages.")
+
+	envICCRedisAddrs          = environment.NewVariable("ICC_REDIS_ADDRS", "", "Comma separated list of redis addresses as host:port. If set, it is used instead of CACHE_HOST and CACHE_PORT. With ICC_REDIS_SENTINEL_MASTER, these are the sentinels. With ICC_REDIS_CLUSTER, these are cluster nodes.")
+	envICCRedisUser           = environment.NewVariable("ICC_REDIS_USER", "", "ACL user for redis. If empty, the default user is used.")
+	envICCRedisPasswordFile   = environment.NewVariable("ICC_REDIS_PASSWORD_FILE", "", "File with the password for redis. If empty, no password is used.")
+	envICCRedisTLS            = environment.NewVariable("ICC_REDIS_TLS", "false", "Connect to redis with tls.")
+	envICCRedisTLSCAFile      = environment.NewVariable("ICC_REDIS_TLS_CA_FILE", "", "File with the ca certificates to verify redis. If empty, the system certificates are used. Setting it enables tls.")
+	envICCRedisSentinelMaster = environment.NewVariable("ICC_REDIS_SENTINEL_MASTER", "", "Name of the redis master. If set, the address of the master is looked up from the sentinels in ICC_REDIS_ADDRS.")
+	envICCRedisCluster        = environment.NewVariable("ICC_REDIS_CLUSTER", "false", "Use a redis cluster. All keys use the hash tag {icc}, so they are saved on one node. After a reshard or failover, the connections are replaced.")
+
+	envICCMetrics = environment.NewVariable("ICC_METRICS", "false", "Expose prometheus metrics on /system/icc/metrics.")
+
+	envICCLogLevel  = environment.NewVariable("ICC_LOG_LEVEL", "info", "Minimum level of log messages. One of debug, info, warn or error. In development mode, the default is debug.")
+	envICCLogFormat = environment.NewVariable("ICC_LOG_FORMAT", "json", "Format of the log messages. Either json or text.")
+
+	envICCTraceExporter = environment.NewVariable("ICC_TRACE_EXPORTER", "none", "Exporter for opentelemetry traces. One of none, stdout, file or otlp. The otlp exporter is configured with the OTEL_EXPORTER_OTLP_* variables.")
+	envICCTraceFile     = environment.NewVariable("ICC_TRACE_FILE", "icc-trace.json", "File for the traces, if ICC_TRACE_EXPORTER is file.")
+
+	envICCReconnectDelay  = environment.NewVariable("ICC_RECONNECT_DELAY", "10s", "On shutdown, each stream gets a reconnect event with a random delay up to this value.")
+	envICCShutdownTimeout = environment.NewVariable("ICC_SHUTDOWN_TIMEOUT", "15s", "Maximum time to wait for open requests on shutdown.")
+
+	envICCTLSCertFile = environment.NewVariable("ICC_TLS_CERT_FILE", "", "Certificate file for https. If set, ICC_TLS_KEY_FILE is also required. The file is reloaded when it changes.")
+	envICCTLSKeyFile  = environment.NewVariable("ICC_TLS_KEY_FILE", "", "Private key file for https.")
+	envICCH2C         = environment.NewVariable("ICC_H2C", "false", "Accept unencrypted http2 (h2c), if https is not used.")
+
+	envICCWriteTimeout = environment.NewVariable("ICC_WRITE_TIMEOUT", "10s", "Time a client has to read a message of a stream. Slower streams are closed. 0 means no timeout.")
+	envICCMaxLag       = environment.NewVariable("ICC_MAX_LAG", "1000", "Number of messages a notify stream can fall behind. Slower streams are closed with a resync event. 0 means no limit.")
+
+	envICCLimitUser    = environment.NewVariable("ICC_LIMIT_USER", "0", "Maximum number of open streams per user over all instances. 0 means no limit.")
+	envICCLimitMeeting = environment.NewVariable("ICC_LIMIT_MEETING", "0", "Maximum number of open streams per meeting over all instances. 0 means no limit.")
+	envICCLimitMode    = environment.NewVariable("ICC_LIMIT_MODE", "reject", "What happens, when a limit is reached. reject rejects the new stream, evict closes the oldest stream of the user.")
 )
 
 var cli struct {
 	Run      struct{} `cmd:"" help:"Runs the service." default:"withargs"`
 	BuildDoc struct{} `cmd:"" help:"Build the environment documentation."`
 	Health   struct {
-		Host     string `help:"Host of the service" short:"h" default:"localhost"`
-		Port     string `help:"Port of the service" short:"p" default:"9007" env:"ICC_PORT"`
-		UseHTTPS bool   `help:"Use https to connect to the service" short:"s"`
-		Insecure bool   `help:"Accept invalid cert" short:"k"`
+		connectionFlags `embed:""`
+		Ready           bool `help:"Check the readiness of the service and its dependencies" short:"r"`
 	} `cmd:"" help:"Runs a health check."`
+	Notify struct {
+		Listen  notifyListenCmd  `cmd:"" help:"Prints the messages of a notify stream."`
+		Publish notifyPublishCmd `cmd:"" help:"Publishes a notify message."`
+	} `cmd:"" help:"Debug notify messages."`
+	Applause struct {
+		Watch applauseWatchCmd `cmd:"" help:"Prints the applause of a meeting."`
+		Send  applauseSendCmd  `cmd:"" help:"Sends applause to a meeting."`
+	} `cmd:"" help:"Debug applause."`
+	Admin struct {
+		Instances adminInstancesCmd `cmd:"" help:"Lists the running instances with their open streams."`
+	} `cmd:"" help:"Inspect the instances."`
+	Loadtest loadtestCmd `cmd:"" help:"Runs a load test against a service."`
 }
 
 func main() {
 	ctx, cancel := environment.InterruptContext()
 	defer cancel()
 
-	icclog.SetInfoLogger(log.Default())
-
 	kongCTX := kong.Parse(&cli, kong.UsageOnError())
 	switch kongCTX.Command() {
 	case "run":
 		if err := contextDone(run(ctx)); err != nil {
-			handleError(err)
+			handleError("main", err)
 			os.Exit(1)
 		}
 
 	case "build-doc":
 		if err := contextDone(buildDocu()); err != nil {
-			handleError(err)
+			handleError("main", err)
 			os.Exit(1)
 		}
 
 	case "health":
-		if err := contextDone(icchttp.HealthClient(ctx, cli.Health.UseHTTPS, cli.Health.Host, cli.Health.Port, cli.Health.Insecure)); err != nil {
-			handleError(err)
+		if err := contextDone(icchttp.HealthClient(ctx, cli.Health.UseHTTPS, cli.Health.Host, cli.Health.Port, cli.Health.Insecure, cli.Health.Ready)); err != nil {
+			handleError("health", err)
+			os.Exit(1)
+		}
+
+	case "notify listen":
+		if err := contextDone(cli.Notify.Listen.run(ctx, os.Stdout)); err != nil {
+			handleError("notify listen", err)
+			os.Exit(1)
+		}
+
+	case "notify publish":
+		if err := contextDone(cli.Notify.Publish.run(ctx, os.Stdin)); err != nil {
+			handleError("notify publish", err)
+			os.Exit(1)
+		}
+
+	case "applause watch":
+		if err := contextDone(cli.Applause.Watch.run(ctx, os.Stdout)); err != nil {
+			handleError("applause watch", err)
+			os.Exit(1)
+		}
+
+	case "applause send":
+		if err := contextDone(cli.Applause.Send.run(ctx)); err != nil {
+			handleError("applause send", err)
+			os.Exit(1)
+		}
+
+	case "admin instances":
+		if err := contextDone(cli.Admin.Instances.run(ctx, os.Stdout)); err != nil {
+			handleError("admin instances", err)
+			os.Exit(1)
+		}
+
+	case "loadtest":
+		if err := contextDone(cli.Loadtest.run(ctx, os.Stdout)); err != nil {
+			handleError("loadtest", err)
 			os.Exit(1)
 		}
 	}
@@ -99,85 +190,539 @@ func buildDocu() error {
 //
 // Returns a the service as callable.
 func initService(lookup environment.Environmenter) (func(context.Context) error, error) {
-	if devMode, _ := strconv.ParseBool(environment.EnvDevelopment.Value(lookup)); devMode {
-		icclog.SetDebugLogger(log.Default())
+	if err := setupLogging(lookup); err != nil {
+		return nil, fmt.Errorf("setup logging: %w", err)
 	}
 
-	var backgroundTasks []func(context.Context, func(error))
+	type backgroundTask struct {
+		subsystem string
+		fn        func(context.Context, func(error))
+	}
+	var backgroundTasks []backgroundTask
 	listenAddr := ":" + envICCServicePort.Value(lookup)
+	withMetrics, _ := strconv.ParseBool(envICCMetrics.Value(lookup))
+	traceExporter := envICCTraceExporter.Value(lookup)
+	traceFile := envICCTraceFile.Value(lookup)
+
+	reconnectDelay, err := environment.ParseDuration(envICCReconnectDelay.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", envICCReconnectDelay.Key, err)
+	}
+
+	shutdownTimeout, err := environment.ParseDuration(envICCShutdownTimeout.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", envICCShutdownTimeout.Key, err)
+	}
+
+	tlsCert, err := loadTLS(lookup)
+	if err != nil {
+		return nil, fmt.Errorf("loading tls: %w", err)
+	}
+
+	h2c, _ := strconv.ParseBool(envICCH2C.Value(lookup))
+
+	writeTimeout, err := environment.ParseDuration(envICCWriteTimeout.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", envICCWriteTimeout.Key, err)
+	}
+
+	maxLag, err := strconv.Atoi(envICCMaxLag.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", envICCMaxLag.Key, err)
+	}
+
+	limitUser, err := strconv.Atoi(envICCLimitUser.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", envICCLimitUser.Key, err)
+	}
+
+	limitMeeting, err := strconv.Atoi(envICCLimitMeeting.Value(lookup))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", envICCLimitMeeting.Key, err)
+	}
+
+	limitMode := envICCLimitMode.Value(lookup)
 
 	// Redis as message bus for datastore and logout events.
 	messageBus := messageBusRedis.New(lookup)
 
-	// Datastore Service.
-	database, err := applause.Flow(lookup, messageBus)
+	status := new(iccstatus.Status)
+
+	// Datastore Service. The postgres backend uses the variables of the
+	// datastore, so they are recorded.
+	datastoreEnv := newRecordEnv(lookup)
+	database, databasePing, err := applause.Flow(datastoreEnv, messageBus)
 	if err != nil {
 		return nil, fmt.Errorf("init database: %w", err)
 	}
+	status.AddCheck("datastore", databasePing)
+
+	databaseLoop := new(iccstatus.Loop)
+	status.AddLoop("datastore", databaseLoop)
 
 	// Auth Service.
 	authService, authBackground, err := auth.New(lookup, messageBus)
 	if err != nil {
 		return nil, fmt.Errorf("init auth system: %w", err)
 	}
-	backgroundTasks = append(backgroundTasks, authBackground)
+	backgroundTasks = append(backgroundTasks, backgroundTask{"auth", authBackground})
 
-	backend := redis.New(envICCRedisHost.Value(lookup) + ":" + envICCRedisPort.Value(lookup))
+	backend, err := initBackend(lookup, status, datastoreEnv.used)
+	if err != nil {
+		return nil, fmt.Errorf("init backend: %w", err)
+	}
 
-	notifyService, notifyBackground := notify.New(backend)
-	backgroundTasks = append(backgroundTasks, notifyBackground)
+	notifyService, notifyBackground := notify.New(backend, maxLag)
+	backgroundTasks = append(backgroundTasks, backgroundTask{"notify", notifyBackground})
 
 	applauseService, applauseBackground := applause.New(backend, database)
-	backgroundTasks = append(backgroundTasks, applauseBackground)
+	backgroundTasks = append(backgroundTasks, backgroundTask{"applause", applauseBackground})
+
+	streams := new(connection.Registry)
+	streams.SetWriteTimeout(writeTimeout)
+	adminService, adminBackground := admin.New(backend, database, streams, admin.NewInstanceID())
+
+	// The service is only ready, after the instance was registered.
+	var registered atomic.Bool
+	status.AddCheck("register", func(context.Context) error {
+		if !registered.Load() {
+			return errors.New("instance is not registered yet")
+		}
+		return nil
+	})
+
+	if limitUser > 0 || limitMeeting > 0 {
+		limiter, limitBackground, err := limit.New(backend, adminService, limitUser, limitMeeting, limitMode)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", envICCLimitMode.Key, err)
+		}
+		streams.SetLimiter(limiter)
+		backgroundTasks = append(backgroundTasks, backgroundTask{"limit", limitBackground})
+	}
+
+	// Closes streams of users, that were removed from their meeting.
+	revoker := permission.NewRevoker(database, streams)
+
+	status.AddLoop("notify", notifyService.Status())
+	status.AddLoop("applause", applauseService.Status())
+
+	backendState := iccstatus.NewBackend(backendDownAfter, notifyService.Status(), applauseService.Status())
+	status.SetBackend(backendState)
+
+	// Streams, that are opened while the backend fails, get the degraded
+	// event at the start.
+	var backendDegraded atomic.Bool
+	streams.SetOpenEvent(func() (icchttp.StreamEvent, bool) {
+		return icchttp.StreamEvent{Event: icchttp.EventDegraded}, backendDegraded.Load()
+	})
+	backgroundTasks = append(backgroundTasks, backgroundTask{"backend", func(ctx context.Context, _ func(error)) {
+		watchBackend(ctx, backendState, streams, &backendDegraded)
+	}})
+
+	stateNames := make([]string, len(iccstatus.States))
+	for i, state := range iccstatus.States {
+		stateNames[i] = string(state)
+	}
+	iccmetric.BackendState(stateNames, func() string { return string(backendState.State()) })
+
+	iccmetric.TopicSize(iccmetric.KindNotify, notifyService.TopicSize)
+	iccmetric.TopicSize(iccmetric.KindApplause, applauseService.TopicSize)
+
+	spec, err := iccopenapi.Load()
+	if err != nil {
+		return nil, fmt.Errorf("loading openapi document: %w", err)
+	}
 
 	service := func(ctx context.Context) error {
-		go database.Update(ctx, nil)
+		shutdownTracing, err := icctrace.Setup(ctx, traceExporter, traceFile)
+		if err != nil {
+			return fmt.Errorf("setup tracing: %w", err)
+		}
+		defer func() {
+			if err := shutdownTracing(context.Background()); err != nil {
+				handleError("trace", err)
+			}
+		}()
+
+		// The instance id is needed for the channel ids. The registration
+		// waits for the backend, so it runs in the background. Until it
+		// succeeds, requests, that create a channel, are rejected.
+		go func() {
+			// Register retries with a backoff and only fails, when the
+			// context is done.
+			instanceID, err := adminService.Register(ctx, errorHandler("admin"))
+			if err != nil {
+				handleError("admin", err)
+				return
+			}
+			if err := notifyService.SetInstance(instanceID); err != nil {
+				handleError("notify", err)
+			}
+			registered.Store(true)
+			icclog.Info(ctx, "Registered instance", "instance", instanceID)
+
+			adminBackground(ctx, errorHandler("admin"))
+		}()
+
+		go database.Update(ctx, func(data map[dskey.Key][]byte, err error) {
+			if err != nil {
+				databaseLoop.Failure(err)
+				handleError("datastore", err)
+				return
+			}
+			databaseLoop.Success()
+
+			if err := revoker.Update(ctx, data); err != nil {
+				handleError("revoke", err)
+			}
+		})
 
 		for _, bg := range backgroundTasks {
-			go bg(ctx, handleError)
+			go bg.fn(ctx, errorHandler(bg.subsystem))
 		}
 
 		// Start http server.
-		fmt.Printf("Listen on %s\n", listenAddr)
-		return Run(ctx, listenAddr, notifyService, applauseService, authService)
+		icclog.Info(ctx, "Listening", "addr", listenAddr, "tls", tlsCert != nil, "h2c", h2c && tlsCert == nil)
+		return runServer(ctx, listenAddr, serverConfig{
+			notify:          notifyService,
+			applause:        applauseService,
+			admin:           adminService,
+			auth:            authService,
+			status:          status,
+			streams:         streams,
+			registered:      registered.Load,
+			spec:            spec,
+			withMetrics:     withMetrics,
+			reconnectDelay:  reconnectDelay,
+			shutdownTimeout: shutdownTimeout,
+			tlsCert:         tlsCert,
+			h2c:             h2c,
+		})
 	}
 
 	return service, nil
 }
 
-// Run starts a webserver
-func Run(ctx context.Context, addr string, notifyService *notify.Notify, applauseService *applause.Applause, auth icchttp.Authenticater) error {
+// backendDownAfter is the time after that a failing backend is reported as
+// down instead of degraded.
+const backendDownAfter = 10 * time.Second
+
+// watchBackend sends a degraded event to all streams, when the backend fails,
+// and a recovered event, when it works again. degraded is set, while the
+// backend fails.
+func watchBackend(ctx context.Context, backendState *iccstatus.Backend, streams *connection.Registry, degraded *atomic.Bool) {
+	backendState.Watch(ctx, time.Second, func(old, new iccstatus.State) {
+		icclog.Info(ctx, "Backend state changed", "state", new)
+
+		switch {
+		case old == iccstatus.StateConnected:
+			degraded.Store(true)
+			streams.Broadcast(icchttp.StreamEvent{Event: icchttp.EventDegraded})
+		case new == iccstatus.StateConnected:
+			degraded.Store(false)
+			streams.Broadcast(icchttp.StreamEvent{Event: icchttp.EventRecovered})
+		}
+	})
+}
+
+// backend saves the data of all services.
+type backend interface {
+	notify.Backend
+	applause.Backend
+	admin.Backend
+	limit.Backend
+}
+
+// initBackend creates the backend from the environment.
+//
+// databaseVariables are the variables, that the datastore uses to connect to
+// postgres.
+func initBackend(lookup environment.Environmenter, status *iccstatus.Status, databaseVariables map[string]environment.Variable) (backend, error) {
+	switch name := envICCBackend.Value(lookup); name {
+	case "redis":
+		redisConfig, err := loadRedisConfig(lookup)
+		if err != nil {
+			return nil, fmt.Errorf("reading redis config: %w", err)
+		}
+
+		r := redis.New(redisConfig)
+		status.AddCheck("redis", r.Ping)
+		return r, nil
+
+	case "postgres":
+		connString, err := postgresConnString(lookup, databaseVariables)
+		if err != nil {
+			return nil, fmt.Errorf("reading postgres config: %w", err)
+		}
+
+		p, err := postgres.New(connString)
+		if err != nil {
+			return nil, fmt.Errorf("init postgres: %w", err)
+		}
+		status.AddCheck("postgres", p.Ping)
+		return p, nil
+
+	case "memory":
+		return memory.New(), nil
+
+	default:
+		return nil, fmt.Errorf("parsing %s: unknown backend %q", envICCBackend.Key, name)
+	}
+}
+
+// loadRedisConfig returns the config of the redis backend from the
+// environment.
+func loadRedisConfig(lookup environment.Environmenter) (redis.Config, error) {
+	config := redis.Config{
+		Addr:           envICCRedisHost.Value(lookup) + ":" + envICCRedisPort.Value(lookup),
+		Username:       envICCRedisUser.Value(lookup),
+		SentinelMaster: envICCRedisSentinelMaster.Value(lookup),
+	}
+
+	if addrs := envICCRedisAddrs.Value(lookup); addrs != "" {
+		config.Addr = addrs
+	}
+
+	cluster, err := strconv.ParseBool(envICCRedisCluster.Value(lookup))
+	if err != nil {
+		return redis.Config{}, fmt.Errorf("parsing %s: %w", envICCRedisCluster.Key, err)
+	}
+	config.Cluster = cluster
+
+	if config.Cluster && config.SentinelMaster != "" {
+		return redis.Config{}, fmt.Errorf("%s and %s can not be used together", envICCRedisCluster.Key, envICCRedisSentinelMaster.Key)
+	}
+
+	if passwordFile := envICCRedisPasswordFile.Value(lookup); passwordFile != "" {
+		password, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return redis.Config{}, fmt.Errorf("reading %s: %w", envICCRedisPasswordFile.Key, err)
+		}
+		config.Password = string(password)
+	}
+
+	useTLS, err := strconv.ParseBool(envICCRedisTLS.Value(lookup))
+	if err != nil {
+		return redis.Config{}, fmt.Errorf("parsing %s: %w", envICCRedisTLS.Key, err)
+	}
+
+	caFile := envICCRedisTLSCAFile.Value(lookup)
+	if useTLS || caFile != "" {
+		tlsConfig, err := redis.TLSConfig(caFile)
+		if err != nil {
+			return redis.Config{}, fmt.Errorf("loading %s: %w", envICCRedisTLSCAFile.Key, err)
+		}
+		config.TLS = tlsConfig
+	}
+
+	return config, nil
+}
+
+// postgresConnString returns the connection string for the postgres backend.
+// It uses the same database and the same environment variables as the
+// datastore.
+func postgresConnString(lookup environment.Environmenter, databaseVariables map[string]environment.Variable) (string, error) {
+	for _, key := range []string{"DATABASE_USER", "DATABASE_PASSWORD_FILE", "DATABASE_HOST", "DATABASE_PORT", "DATABASE_NAME"} {
+		if _, ok := databaseVariables[key]; !ok {
+			return "", fmt.Errorf("the datastore does not use %s", key)
+		}
+	}
+
+	password, err := environment.ReadSecret(lookup, databaseVariables["DATABASE_PASSWORD_FILE"])
+	if err != nil {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+
+	// See https://www.postgresql.org/docs/current/libpq-connect.html#LIBPQ-CONNSTRING
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	return fmt.Sprintf(
+		`user='%s' password='%s' host='%s' port='%s' dbname='%s'`,
+		quote.Replace(databaseVariables["DATABASE_USER"].Value(lookup)),
+		quote.Replace(password),
+		quote.Replace(databaseVariables["DATABASE_HOST"].Value(lookup)),
+		quote.Replace(databaseVariables["DATABASE_PORT"].Value(lookup)),
+		quote.Replace(databaseVariables["DATABASE_NAME"].Value(lookup)),
+	), nil
+}
+
+// recordEnv is an environment, that remembers the variables, that are used
+// with it.
+type recordEnv struct {
+	environment.Environmenter
+	used map[string]environment.Variable
+}
+
+func newRecordEnv(lookup environment.Environmenter) *recordEnv {
+	return &recordEnv{
+		Environmenter: lookup,
+		used:          make(map[string]environment.Variable),
+	}
+}
+
+// UseVariable remembers the variable and passes it to the wrapped
+// environment.
+func (e *recordEnv) UseVariable(v environment.Variable) {
+	e.used[v.Key] = v
+	e.Environmenter.UseVariable(v)
+}
+
+// serverConfig contains the services and settings for the webserver.
+type serverConfig struct {
+	notify   *notify.Notify
+	applause *applause.Applause
+	admin    *admin.Admin
+	auth     icchttp.Authenticater
+	status   *iccstatus.Status
+	streams  *connection.Registry
+
+	// registered tells, if the instance is registered in the backend. Before
+	// that, no channels can be created. If nil, the instance counts as
+	// registered.
+	registered func() bool
+
+	// spec is used to validate the requests. If nil, the requests are not
+	// validated.
+	spec *iccopenapi.Spec
+
+	withMetrics bool
+
+	// reconnectDelay is the maximum delay, that is sent to the clients on
+	// shutdown.
+	reconnectDelay time.Duration
+
+	// shutdownTimeout is the maximum time to wait for open requests on
+	// shutdown.
+	shutdownTimeout time.Duration
+
+	// tlsCert is the certificate for https. If nil, plain http is used.
+	tlsCert *icctls.Certificate
+
+	// h2c enables unencrypted http2. It is only used without tlsCert.
+	h2c bool
+}
+
+// handler returns the handler with all routes of the service.
+func (cfg serverConfig) handler() http.Handler {
 	mux := http.NewServeMux()
 	icchttp.HandleHealth(mux)
-	notify.HandleReceive(mux, notifyService, auth)
-	notify.HandlePublish(mux, notifyService, auth)
-	applause.HandleReceive(mux, applauseService, auth)
-	applause.HandleSend(mux, applauseService, auth)
+	icchttp.HandleReady(mux, cfg.status)
+	if cfg.withMetrics {
+		iccmetric.HandleMetrics(mux)
+	}
+	notify.HandleReceive(mux, cfg.notify, cfg.auth, cfg.streams)
+	notify.HandlePublish(mux, cfg.notify, cfg.auth)
+	notify.HandlePoll(mux, cfg.notify, cfg.auth, cfg.streams)
+	applause.HandleReceive(mux, cfg.applause, cfg.auth, cfg.streams)
+	applause.HandleSend(mux, cfg.applause, cfg.auth)
+	applause.HandlePoll(mux, cfg.applause, cfg.auth, cfg.streams)
+	admin.HandleStreams(mux, cfg.admin, cfg.auth)
+	admin.HandleInstances(mux, cfg.admin, cfg.auth)
+	admin.HandleClose(mux, cfg.admin, cfg.auth)
+	iccopenapi.Handle(mux)
+
+	var handler http.Handler = mux
+	if cfg.registered != nil {
+		handler = waitRegistered(handler, cfg.registered)
+	}
+
+	if cfg.spec == nil {
+		return handler
+	}
+	return cfg.spec.Middleware(handler)
+}
+
+// waitRegistered rejects the requests, that create a notify channel, until
+// the instance is registered. The channel ids contain the name of the
+// instance.
+func waitRegistered(next http.Handler, registered func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		createsChannel := r.URL.Path == icchttp.Path+"/notify" ||
+			r.URL.Path == icchttp.Path+"/notify/poll" && r.URL.Query().Get("channel_id") == ""
+
+		if createsChannel && !registered() {
+			icchttp.Error(w, iccerror.NewMessageError(iccerror.ErrUnavailable, "The instance is not registered in the backend yet."))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
 
+// runServer starts a webserver
+//
+// When ctx is done, the server is drained. All open streams get a reconnect
+// event and are closed. After that, the server waits for the other requests up
+// to the shutdown timeout.
+func runServer(ctx context.Context, addr string, cfg serverConfig) error {
 	srv := &http.Server{
-		Addr:        addr,
-		Handler:     mux,
-		BaseContext: func(net.Listener) context.Context { return ctx },
+		Addr:    addr,
+		Handler: cfg.handler(),
+
+		// The requests are not canceled with ctx, so the streams can be
+		// drained.
+		BaseContext: func(net.Listener) context.Context { return context.WithoutCancel(ctx) },
+	}
+
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	if cfg.h2c && cfg.tlsCert == nil {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	srv.Protocols = &protocols
+
+	if cfg.tlsCert != nil {
+		srv.TLSConfig = cfg.tlsCert.Config()
 	}
 
 	// Shutdown logic in separate goroutine.
 	wait := make(chan error)
 	go func() {
 		<-ctx.Done()
-		if err := srv.Shutdown(context.Background()); err != nil {
+
+		icclog.Info(context.Background(), "Draining streams", "streams", cfg.streams.Count())
+		cfg.streams.Drain(cfg.reconnectDelay)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+		defer cancel()
+
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			srv.Close()
 			wait <- fmt.Errorf("HTTP server shutdown: %w", err)
 			return
 		}
 		wait <- nil
 	}()
 
-	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+	serve := srv.ListenAndServe
+	if cfg.tlsCert != nil {
+		serve = func() error { return srv.ListenAndServeTLS("", "") }
+	}
+
+	if err := serve(); err != http.ErrServerClosed {
 		return fmt.Errorf("HTTP Server failed: %v", err)
 	}
 
 	return <-wait
 }
 
+// loadTLS loads the tls certificate from the files in the environment. It
+// returns nil, if no files are configured.
+func loadTLS(lookup environment.Environmenter) (*icctls.Certificate, error) {
+	certFile := envICCTLSCertFile.Value(lookup)
+	keyFile := envICCTLSKeyFile.Value(lookup)
+
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("%s and %s have to be set together", envICCTLSCertFile.Key, envICCTLSKeyFile.Key)
+	}
+
+	return icctls.NewCertificate(certFile, keyFile)
+}
+
 // contextDone returns an empty error if the context is done or exceeded
 func contextDone(err error) error {
 	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
@@ -186,13 +731,44 @@ func contextDone(err error) error {
 	return err
 }
 
-// handleError handles an error.
+// setupLogging configures the logger from the environment.
+func setupLogging(lookup environment.Environmenter) error {
+	level, err := logLevel(lookup)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", envICCLogLevel.Key, err)
+	}
+
+	if err := icclog.Setup(os.Stderr, level, envICCLogFormat.Value(lookup)); err != nil {
+		return fmt.Errorf("parsing %s: %w", envICCLogFormat.Key, err)
+	}
+	return nil
+}
+
+// logLevel returns the log level from the environment. In development mode,
+// the default is debug. An explicitly set level is always used.
+func logLevel(lookup environment.Environmenter) (slog.Level, error) {
+	levelStr := envICCLogLevel.Value(lookup)
+	if devMode, _ := strconv.ParseBool(environment.EnvDevelopment.Value(lookup)); devMode && lookup.Getenv(envICCLogLevel.Key) == "" {
+		levelStr = "debug"
+	}
+
+	return icclog.ParseLevel(levelStr)
+}
+
+// errorHandler returns a function that handles the errors of a subsystem.
+func errorHandler(subsystem string) func(error) {
+	return func(err error) {
+		handleError(subsystem, err)
+	}
+}
+
+// handleError handles an error from a subsystem.
 //
 // Ignores context closed errors.
-func handleError(err error) {
+func handleError(subsystem string, err error) {
 	if contextDone(err) == nil {
 		return
 	}
 
-	icclog.Info("Error: %v", err)
+	icclog.Error(context.Background(), "Error", "subsystem", subsystem, "error", err)
 }
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OpenSlides/openslides-icc-service/internal/admin"
+	"github.com/OpenSlides/openslides-icc-service/internal/applause"
+	"github.com/OpenSlides/openslides-icc-service/internal/connection"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccopenapi"
+	"github.com/OpenSlides/openslides-icc-service/internal/iccstatus"
+	"github.com/OpenSlides/openslides-icc-service/internal/icctest"
+	"github.com/OpenSlides/openslides-icc-service/internal/memory"
+	"github.com/OpenSlides/openslides-icc-service/internal/notify"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/peb-adr/openslides-go/datastore/dsmock"
+	"github.com/peb-adr/openslides-go/environment"
+)
+
+// testData is the datastore content for the server tests. User 1 is a
+// superadmin, user 2 is a member of meeting 1 and user 3 is in no meeting.
+const testData = `---
+user/1/organization_management_level: superadmin
+user/2/meeting_user_ids: [20]
+user/3/id: 3
+meeting_user/20/meeting_id: 1
+meeting/1/applause_enable: true
+meeting/1/enable_anonymous: false
+meeting/1/present_user_ids: [2]
+meeting/2/applause_enable: true
+`
+
+// newTestConfig returns a server config with a memory backend and the
+// datastore content of testData. The background tasks run until the test is
+// finished.
+func newTestConfig(t *testing.T) serverConfig {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	backend := memory.New()
+	datastore := dsmock.Stub(dsmock.YAMLData(testData))
+	streams := new(connection.Registry)
+
+	notifyService, notifyBackground := notify.New(backend, 0)
+	go notifyBackground(ctx, func(error) {})
+
+	applauseService, applauseBackground := applause.New(backend, datastore)
+	go applauseBackground(ctx, func(error) {})
+
+	adminService, _ := admin.New(backend, datastore, streams, "test")
+
+	spec, err := iccopenapi.Load()
+	if err != nil {
+		t.Fatalf("loading openapi document: %v", err)
+	}
+
+	return serverConfig{
+		notify:      notifyService,
+		applause:    applauseService,
+		admin:       adminService,
+		auth:        &icctest.AutherStub{UserID: 1},
+		status:      new(iccstatus.Status),
+		streams:     streams,
+		spec:        spec,
+		withMetrics: true,
+	}
+}
+
+func TestOpenAPIContract(t *testing.T) {
+	cfg := newTestConfig(t)
+	covered := make(map[string]bool)
+
+	for _, tt := range []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		userID int
+		status int
+	}{
+		{"health", "GET", "/system/icc/health", "", 0, 200},
+		{"ready", "GET", "/system/icc/ready", "", 0, 200},
+		{"metrics", "GET", "/system/icc/metrics", "", 0, 200},
+		{"openapi", "GET", "/system/icc/openapi.yml", "", 0, 200},
+
+		{"notify", "GET", "/system/icc/notify?meeting_id=1", "", 2, 200},
+		{"notify anonymous", "GET", "/system/icc/notify", "", 0, 401},
+		{"notify invalid meeting", "GET", "/system/icc/notify?meeting_id=abc", "", 2, 400},
+		{"notify wrong method", "DELETE", "/system/icc/notify", "", 2, 405},
+		{"publish", "POST", "/system/icc/notify/publish", `{"channel_id":"abc:2:0","to_meeting":1,"name":"test","message":"hello"}`, 2, 200},
+		{"publish anonymous", "POST", "/system/icc/notify/publish", `{}`, 0, 401},
+		{"publish invalid", "POST", "/system/icc/notify/publish", `{"channel_id":"abc:2:0"}`, 2, 400},
+		{"publish too large", "POST", "/system/icc/notify/publish", `{"name":"` + strings.Repeat("x", 2<<20) + `"}`, 2, 413},
+		{"publish wrong method", "GET", "/system/icc/notify/publish", "", 2, 405},
+		{"notify poll", "GET", "/system/icc/notify/poll?meeting_id=1", "", 2, 200},
+		{"notify poll unknown channel", "GET", "/system/icc/notify/poll?channel_id=abc:2:0&cursor=1", "", 2, 404},
+		{"notify poll invalid cursor", "GET", "/system/icc/notify/poll?channel_id=abc:2:0&cursor=-1", "", 2, 400},
+
+		{"applause", "GET", "/system/icc/applause?meeting_id=1", "", 2, 200},
+		{"applause not in meeting", "GET", "/system/icc/applause?meeting_id=1", "", 3, 403},
+		{"applause without meeting", "GET", "/system/icc/applause", "", 2, 400},
+		{"applause send", "POST", "/system/icc/applause/send?meeting_id=1", "", 2, 200},
+		{"applause send get", "GET", "/system/icc/applause/send?meeting_id=1", "", 2, 200},
+		{"applause send anonymous", "POST", "/system/icc/applause/send?meeting_id=1", "", 0, 401},
+		{"applause send not in meeting", "POST", "/system/icc/applause/send?meeting_id=1", "", 3, 403},
+		{"applause poll", "GET", "/system/icc/applause/poll?meeting_id=1", "", 2, 200},
+		{"applause poll not in meeting", "GET", "/system/icc/applause/poll?meeting_id=1", "", 3, 403},
+
+		{"admin streams", "GET", "/system/icc/admin/streams", "", 1, 200},
+		{"admin streams not allowed", "GET", "/system/icc/admin/streams", "", 2, 403},
+		{"admin streams anonymous", "GET", "/system/icc/admin/streams", "", 0, 401},
+		{"admin instances", "GET", "/system/icc/admin/instances", "", 1, 200},
+		{"admin instances not allowed", "GET", "/system/icc/admin/instances", "", 2, 403},
+		{"admin close", "POST", "/system/icc/admin/close", `{"user_id":2}`, 1, 200},
+		{"admin close unknown channel", "POST", "/system/icc/admin/close", `{"channel_id":"abc:2:0"}`, 1, 404},
+		{"admin close invalid", "POST", "/system/icc/admin/close", `{}`, 1, 400},
+		{"admin close too large", "POST", "/system/icc/admin/close", `{"stream_id":"` + strings.Repeat("x", 1<<13) + `"}`, 1, 413},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.auth = &icctest.AutherStub{UserID: tt.userID}
+
+			// Streams are closed after a short time.
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body)).WithContext(ctx)
+			resp := httptest.NewRecorder()
+			cfg.handler().ServeHTTP(resp, req)
+
+			if resp.Code != tt.status {
+				t.Fatalf("got status %d, expected %d: %s", resp.Code, tt.status, resp.Body.String())
+			}
+
+			if err := validateResponse(cfg.spec, req, resp.Result()); err != nil {
+				t.Errorf("response does not match the openapi document: %v", err)
+			}
+
+			if route, _, err := cfg.spec.FindRoute(req); err == nil {
+				covered[route.Operation.OperationID] = true
+			}
+		})
+	}
+
+	t.Run("All operations are tested", func(t *testing.T) {
+		for path, item := range cfg.spec.Doc().Paths.Map() {
+			for method, operation := range item.Operations() {
+				if !covered[operation.OperationID] {
+					t.Errorf("%s %s is not tested", method, path)
+				}
+			}
+		}
+	})
+}
+
+func TestHandlerWithoutSpec(t *testing.T) {
+	cfg := newTestConfig(t)
+	cfg.spec = nil
+
+	resp := httptest.NewRecorder()
+	cfg.handler().ServeHTTP(resp, httptest.NewRequest("GET", "/system/icc/health", nil))
+
+	if resp.Code != 200 {
+		t.Errorf("health returned status %d, expected 200: %s", resp.Code, resp.Body.String())
+	}
+}
+
+func TestWaitRegistered(t *testing.T) {
+	cfg := newTestConfig(t)
+
+	var registered atomic.Bool
+	cfg.registered = registered.Load
+	handler := cfg.handler()
+
+	for _, tt := range []struct {
+		name       string
+		url        string
+		registered bool
+		expect     int
+	}{
+		{"Stream", "/system/icc/notify", false, 503},
+		{"New poll channel", "/system/icc/notify/poll", false, 503},
+		{"Existing poll channel", "/system/icc/notify/poll?channel_id=unknown&cursor=0", false, 404},
+		{"Health", "/system/icc/health", false, 200},
+		{"Poll channel after registration", "/system/icc/notify/poll", true, 200},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			registered.Store(tt.registered)
+
+			req := httptest.NewRequest("GET", tt.url, nil)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+
+			if resp.Code != tt.expect {
+				t.Fatalf("got status %d, expected %d: %s", resp.Code, tt.expect, resp.Body.String())
+			}
+
+			if err := validateResponse(cfg.spec, req, resp.Result()); err != nil {
+				t.Errorf("response does not match the openapi document: %v", err)
+			}
+		})
+	}
+}
+
+// validateResponse checks, that the status code and the body of the response
+// are documented for the route.
+//
+// Each line of a json body is validated separately, so streams can be checked.
+// A request with a wrong method has to return the error schema.
+func validateResponse(spec *iccopenapi.Spec, req *http.Request, resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	route, _, err := spec.FindRoute(req)
+	if err != nil {
+		if resp.StatusCode == http.StatusMethodNotAllowed {
+			return validateLines(body, spec.Doc().Components.Schemas["Error"].Value)
+		}
+		return err
+	}
+
+	responseRef := route.Operation.Responses.Status(resp.StatusCode)
+	if responseRef == nil {
+		return fmt.Errorf("status %d is not documented", resp.StatusCode)
+	}
+
+	if len(responseRef.Value.Content) == 0 {
+		if len(body) != 0 {
+			return fmt.Errorf("body has to be empty, got: %s", body)
+		}
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("parsing content type: %v", err)
+	}
+
+	content := responseRef.Value.Content.Get(mediaType)
+	if content == nil {
+		return fmt.Errorf("content type %s is not documented", mediaType)
+	}
+
+	if mediaType == "text/plain" || mediaType == "application/yaml" {
+		return nil
+	}
+
+	return validateLines(body, content.Schema.Value)
+}
+
+// validateLines validates each line of body against the schema.
+func validateLines(body []byte, schema *openapi3.Schema) error {
+	for _, line := range bytes.Split(bytes.TrimSpace(body), []byte("\n")) {
+		var value any
+		if err := json.Unmarshal(line, &value); err != nil {
+			return fmt.Errorf("line `%s` is not json: %v", line, err)
+		}
+
+		if err := schema.VisitJSON(value); err != nil {
+			return fmt.Errorf("line `%s`: %v", line, err)
+		}
+	}
+	return nil
+}
+
+func TestLogLevel(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		env    environment.ForTests
+		expect slog.Level
+	}{
+		{"Default", environment.ForTests{"OPENSLIDES_DEVELOPMENT": "false"}, slog.LevelInfo},
+		{"Development default", environment.ForTests{}, slog.LevelDebug},
+		{"Development with level", environment.ForTests{"ICC_LOG_LEVEL": "info"}, slog.LevelInfo},
+		{"Production with level", environment.ForTests{"OPENSLIDES_DEVELOPMENT": "false", "ICC_LOG_LEVEL": "warn"}, slog.LevelWarn},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := logLevel(tt.env)
+			if err != nil {
+				t.Fatalf("logLevel: %v", err)
+			}
+
+			if got != tt.expect {
+				t.Errorf("got level %s, expected %s", got, tt.expect)
+			}
+		})
+	}
+}
+
+func TestPostgresConnString(t *testing.T) {
+	env := environment.ForTests{"DATABASE_HOST": "db.example", "DATABASE_USER": "o'brien"}
+
+	// The variables are the ones, that the datastore uses.
+	datastoreEnv := newRecordEnv(env)
+	if _, _, err := applause.Flow(datastoreEnv, nil); err != nil {
+		t.Fatalf("init datastore: %v", err)
+	}
+
+	t.Run("Variables of the datastore", func(t *testing.T) {
+		got, err := postgresConnString(env, datastoreEnv.used)
+		if err != nil {
+			t.Fatalf("postgresConnString: %v", err)
+		}
+
+		expect := `user='o\'brien' password='openslides' host='db.example' port='5432' dbname='openslides'`
+		if got != expect {
+			t.Errorf("got `%s`, expected `%s`", got, expect)
+		}
+	})
+
+	t.Run("Variable not used by the datastore", func(t *testing.T) {
+		if _, err := postgresConnString(env, map[string]environment.Variable{}); err == nil {
+			t.Errorf("postgresConnString returned no error")
+		}
+	})
+}
+
+func TestInitBackendIgnoresRedisConfig(t *testing.T) {
+	env := environment.ForTests{
+		"ICC_BACKEND":             "memory",
+		"ICC_REDIS_PASSWORD_FILE": "/does/not/exist",
+		"ICC_REDIS_CLUSTER":       "not a bool",
+	}
+
+	if _, err := initBackend(env, new(iccstatus.Status), nil); err != nil {
+		t.Errorf("initBackend: %v", err)
+	}
+}